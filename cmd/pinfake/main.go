@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/metaconflux/backend/internal/pinning/fake"
+)
+
+// Runs an in-memory pinning service for local development, point
+// pinning.endpoint at it
+func main() {
+	addr := flag.String("addr", "localhost:8099", "Listen address")
+	token := flag.String("token", "secret", "Bearer token clients have to send")
+	flag.Parse()
+
+	log.Printf("Fake pinning service listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, fake.NewService(*token)))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	shell "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/metaconflux/backend/internal/api/v1alpha"
+	"github.com/metaconflux/backend/internal/backup"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	objects_flag = "objects"
+	from_flag    = "from"
+	fromDsn_flag = "from-dsn"
+	to_flag      = "to"
+	toDsn_flag   = "to-dsn"
+)
+
+// objectPrefixes are the resolver keys whose values are objects worth
+// keeping, composite images included
+var objectPrefixes = append([]string{"image#"}, v1alpha.PINNED_PREFIXES...)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Write the repository, the resolver and optionally the objects to a zip archive",
+	Long: `Write the repository rows, the resolver entries and, with --objects, the
+objects the manifest, result and image keys point to as a CAR file into a zip
+archive. Pending hook deliveries are not backed up, pins are tracked again
+from the resolver entries when restoring.
+
+The archive is not encrypted. It contains the hook secrets of the manifests
+and the sign in nonces of the users in plaintext, so store it like the
+database itself.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		objects, _ := cmd.Flags().GetBool(objects_flag)
+
+		sh := newShell()
+		s, err := openState(viper.GetString("database.driver"), viper.GetString("database.dsn"), sh)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		options := backup.Options{Prefixes: objectPrefixes}
+		if objects {
+			options.Blocks = sh
+		}
+
+		f, err := os.Create(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		meta, err := backup.Write(cmd.Context(), f, s.repository, s.resolver, options)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(args[0])
+			log.Fatal(err)
+		}
+
+		err = utils.JsonPretty(meta)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore a backup into an empty database",
+	Long: `Restore a backup into the configured database, which has to be empty.
+The objects in the archive are imported into the IPFS node first unless
+--objects=false, then the resolver entries are restored and their objects
+pinned.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		objects, _ := cmd.Flags().GetBool(objects_flag)
+
+		sh := newShell()
+		s, err := openState(viper.GetString("database.driver"), viper.GetString("database.dsn"), sh)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var importer backup.Importer
+		if objects {
+			importer = dagImporter(cmd.Context(), sh)
+		}
+
+		meta, err := backup.Read(cmd.Context(), args[0], s.repository, s.resolver, importer)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = utils.JsonPretty(meta)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy the repository and the resolver to another, empty database",
+	Long: `Copy the repository rows and the resolver entries to another database,
+e.g. from the sqlite file to postgres:
+
+  server migrate --to postgres --to-dsn "host=localhost user=metaconflux dbname=metaconflux"
+
+The source defaults to the configured database. Stop the server first,
+pending hook deliveries are not copied.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString(from_flag)
+		fromDsn, _ := cmd.Flags().GetString(fromDsn_flag)
+		to, _ := cmd.Flags().GetString(to_flag)
+		toDsn, _ := cmd.Flags().GetString(toDsn_flag)
+
+		if len(to) == 0 {
+			cmd.Help()
+			log.Fatal("Need --to")
+		}
+
+		if len(from) == 0 {
+			from = viper.GetString("database.driver")
+			fromDsn = viper.GetString("database.dsn")
+		}
+
+		sh := newShell()
+
+		src, err := openState(from, fromDsn, sh)
+		if err != nil {
+			log.Fatal(fmt.Errorf("Failed to open the source database: %s", err))
+		}
+
+		dst, err := openState(to, toDsn, sh)
+		if err != nil {
+			log.Fatal(fmt.Errorf("Failed to open the target database: %s", err))
+		}
+
+		stats, err := dst.resolver.Count("")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if stats.Keys > 0 {
+			log.Fatal(backup.ErrResolverNotEmpty)
+		}
+
+		dump, err := src.repository.Dump(cmd.Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = dst.repository.Load(cmd.Context(), dump)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		entries, err := resolver.Copy(src.resolver, dst.resolver)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Copied %d users, %d manifests, %d domains and %d resolver entries", len(dump.Users), len(dump.Manifests), len(dump.Domains), entries)
+	},
+}
+
+// dagImporter imports CAR files with ipfs dag import, which pins the roots
+func dagImporter(ctx context.Context, sh *shell.Shell) backup.Importer {
+	return func(car io.Reader) error {
+		fr := files.NewReaderFile(car)
+		slf := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", fr)})
+		fileReader := files.NewMultiFileReader(slf, true)
+
+		return sh.Request("dag/import").Body(fileReader).Exec(ctx, nil)
+	}
+}
+
+func init() {
+	backupCmd.Flags().Bool(objects_flag, false, "Include the objects as a CAR file")
+	restoreCmd.Flags().Bool(objects_flag, true, "Import the objects of the archive into the IPFS node")
+
+	migrateCmd.Flags().String(from_flag, "", "Source database driver (sqlite or postgres), the configured database by default")
+	migrateCmd.Flags().String(fromDsn_flag, "", "Source database file or connection string")
+	migrateCmd.Flags().String(to_flag, "", "Target database driver (sqlite or postgres)")
+	migrateCmd.Flags().String(toDsn_flag, "", "Target database file or connection string")
+
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
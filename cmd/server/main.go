@@ -1,33 +1,67 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/ethclient"
 	shell "github.com/ipfs/go-ipfs-api"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/metaconflux/backend/internal/api/admin"
 	"github.com/metaconflux/backend/internal/api/users"
 	"github.com/metaconflux/backend/internal/api/users/jwtmaker"
+	"github.com/metaconflux/backend/internal/api/users/repository"
 	"github.com/metaconflux/backend/internal/api/users/repository/sqliterepo"
 	"github.com/metaconflux/backend/internal/api/v1alpha"
 	cache "github.com/metaconflux/backend/internal/cache/ipfs"
 	"github.com/metaconflux/backend/internal/chains"
+	"github.com/metaconflux/backend/internal/database"
+	"github.com/metaconflux/backend/internal/directory"
+	"github.com/metaconflux/backend/internal/domains"
 	"github.com/metaconflux/backend/internal/hooks"
 	"github.com/metaconflux/backend/internal/hooks/api"
+	"github.com/metaconflux/backend/internal/hooks/eip4906"
+	"github.com/metaconflux/backend/internal/hooks/outbox"
+	sqliteoutbox "github.com/metaconflux/backend/internal/hooks/outbox/sqlite"
+	"github.com/metaconflux/backend/internal/pinning"
+	sqlitepinning "github.com/metaconflux/backend/internal/pinning/sqlite"
+	"github.com/metaconflux/backend/internal/resolver"
 	sqliteresolver "github.com/metaconflux/backend/internal/resolver/sqlite"
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
 	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/composite"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/compute"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/contract"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/ipfs"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/print"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/svg"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/switchcase"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/traits"
 )
 
+// SHUTDOWN_TIMEOUT is how long running requests get to finish on interrupt
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+var rootCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Run the API server",
+	Run: func(cmd *cobra.Command, args []string) {
+		serve(cmd.Context())
+	},
+}
+
 func main() {
 	var err error
 	viper.AddConfigPath(".")
@@ -38,14 +72,39 @@ func main() {
 		log.Fatal(err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = rootCmd.ExecuteContext(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(ctx context.Context) {
+	var err error
+
 	port := viper.GetInt("server.port")
 	host := viper.GetString("server.host")
 
 	m := jwtmaker.NewJWTMaker(viper.GetString("auth.secretKey"))
 
+	url := viper.GetString("ipfs.apiEndpoint")
+	shell := newShell()
+
+	s, err := openState(viper.GetString("database.driver"), viper.GetString("database.dsn"), shell)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db := s.db
+	repository := s.repository
+	r := s.resolver
+	pins := s.pins
+
 	e := echo.New()
 	e.Use(
 		middleware.Logger(), // Log everything to stdout
+		middleware.Recover(),
 		middleware.CORSWithConfig(middleware.CORSConfig{
 			AllowOrigins:     []string{"http://localhost:3000"},
 			AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
@@ -53,10 +112,10 @@ func main() {
 		}),
 		middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
 			Skipper: func(c echo.Context) bool {
-				if strings.Contains(c.Request().URL.String(), v1alpha.PUBLIC_GROUP) {
+				if strings.Contains(c.Request().URL.Path, v1alpha.PUBLIC_GROUP) {
 					return true
 				}
-				if strings.Contains(c.Request().URL.String(), users.PUBLIC_GROUP) {
+				if strings.Contains(c.Request().URL.Path, users.PUBLIC_GROUP) {
 					return true
 				}
 				return false
@@ -67,6 +126,16 @@ func main() {
 					return false, err
 				}
 
+				// Tokens cannot be revoked, so disabled users are checked on every
+				// request
+				um, err := repository.GetByAddress(c.Request().Context(), claims.Subject)
+				if err != nil {
+					return false, err
+				}
+				if um.Disabled {
+					return false, users.ErrDisabled
+				}
+
 				c.Set("user", claims)
 
 				return true, nil
@@ -83,23 +152,15 @@ func main() {
 
 	tm, _ := transformers.NewTransformerManager()
 
-	url := viper.GetString("ipfs.apiEndpoint")
-	projectId := viper.GetString("ipfs.projectId")
-	projectSecret := viper.GetString("ipfs.projectSecret")
+	pins.Start(ctx)
 
-	httpClient := &http.Client{}
+	// Everything pushed is pinned, objects stay pinned while a manifest or
+	// result key points to them
+	c := pinning.NewCache(cache.NewIPFSCache(url, shell), pins)
 
-	if projectId != "" && projectSecret != "" {
-		httpClient = &http.Client{
-			Transport: authTransport{
-				RoundTripper:  http.DefaultTransport,
-				ProjectId:     projectId,
-				ProjectSecret: projectSecret,
-			},
-		}
-	}
+	sweeper := resolver.NewSweeper(r, v1alpha.NewSweepPolicy(r), viper.GetDuration("resolver.sweepRetention"), viper.GetDuration("resolver.sweepInterval"))
+	sweeper.Start(ctx)
 
-	shell := shell.NewShellWithClient(url, httpClient)
 	ipfsT := ipfs.NewTransformer(shell)
 
 	clients := chains.NewClients(nil)
@@ -125,6 +186,41 @@ func main() {
 		log.Fatal(err)
 	}
 
+	computeT := compute.NewTransformer()
+
+	err = tm.Register(compute.GVK, computeT.WithSpec, compute.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	traitsT := traits.NewTransformer()
+
+	err = tm.Register(traits.GVK, traitsT.WithSpec, traits.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	imageT := composite.NewTransformer(shell, c, r)
+
+	err = tm.Register(composite.GVK, imageT.WithSpec, composite.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	svgT := svg.NewTransformer(shell, c)
+
+	err = tm.Register(svg.GVK, svgT.WithSpec, svg.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switchT := switchcase.NewTransformer(tm)
+
+	err = tm.Register(switchcase.GVK, switchT.WithSpec, switchcase.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	///Hooks
 	hm := hooks.NewHooksManager()
 
@@ -135,34 +231,162 @@ func main() {
 		log.Fatal(err)
 	}
 
-	db, err := gorm.Open(sqlite.Open("./gorm.db"), &gorm.Config{})
+	if keystorePath := viper.GetString("eip4906.keystore"); len(keystorePath) > 0 {
+		key, err := eip4906.LoadKey(keystorePath, viper.GetString("eip4906.password"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rpcs := make(map[int64]string)
+		for _, chain := range chains.Chains {
+			rpcs[chain.ChainId] = chain.Rpc
+		}
+		for chainId, rpc := range viper.GetStringMapString("eip4906.rpc") {
+			id, err := strconv.ParseInt(chainId, 10, 64)
+			if err != nil {
+				log.Fatal(fmt.Errorf("Invalid chain id %s in eip4906.rpc", chainId))
+			}
+			rpcs[id] = rpc
+		}
+
+		backends := make(map[int64]bind.ContractBackend)
+		for chainId, rpc := range rpcs {
+			client, err := ethclient.Dial(rpc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer client.Close()
+			backends[chainId] = client
+		}
+
+		err = hm.Register(eip4906.TYPE, eip4906.NewHook(key, backends, eip4906.Limits{
+			MaxGasLimit:     viper.GetUint64("eip4906.maxGasLimit"),
+			MaxTransactions: viper.GetInt("eip4906.maxTransactions"),
+			MaxGas:          viper.GetUint64("eip4906.maxGas"),
+			Period:          viper.GetDuration("eip4906.period"),
+		}))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	store, err := sqliteoutbox.NewStore(db)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	repository, err := sqliterepo.NewSqliteRepository(db)
+	secrets := func(ctx context.Context, chainId int64, contract string) (string, error) {
+		mm, err := repository.GetManifest(ctx, chainId, contract)
+		if err != nil {
+			return "", err
+		}
+		return mm.HookSecret, nil
+	}
+
+	dispatcher := outbox.NewDispatcher(store, hm, secrets, viper.GetInt("hooks.workers"), viper.GetInt("hooks.maxAttempts"))
+	dispatcher.Start(ctx)
+
+	verifier := domains.NewVerifier(viper.GetString("domains.dnsServer"), viper.GetString("domains.wellKnownUrl"))
+
+	directories := directory.NewPublisher(ctx, shell, viper.GetString("directory.root"), viper.GetDuration("directory.debounce"))
+
+	a := v1alpha.NewAPI(c, r, tm, repository, hm, dispatcher, clients.Clients(), verifier, directories)
+	a.Register(g)
+	e.Pre(a.DomainRouter())
+
+	u := users.NewUserAPI(m, c, r, repository)
+	u.Register(g)
+
+	adminApi := admin.NewAdminAPI(viper.GetStringSlice("admin.addresses"), repository, r, a, dispatcher)
+	adminApi.Register(g)
+
+	go func() {
+		err := e.Start(fmt.Sprintf("%s:%d", host, port))
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Interrupting cancels ctx, which stops the background jobs as well
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	err = e.Shutdown(shutdownCtx)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = repository.Migrate()
+}
+
+// state is what lives in the database, the objects the resolver points to
+// are tracked for pinning
+type state struct {
+	db         *gorm.DB
+	repository repository.UserRepository
+	resolver   resolver.IResolver
+	pins       *pinning.Manager
+}
+
+func openState(driver string, dsn string, shell *shell.Shell) (state, error) {
+	var s state
+
+	db, err := database.Open(driver, dsn)
 	if err != nil {
-		log.Fatal(err)
+		return s, err
+	}
+	s.db = db
+
+	s.repository, err = sqliterepo.NewSqliteRepository(db)
+	if err != nil {
+		return s, err
+	}
+	err = s.repository.Migrate()
+	if err != nil {
+		return s, err
 	}
 
 	//r := memory.NewResolver()
 	//r, err := file.NewResolver("./resolver.json")
 	r, err := sqliteresolver.NewResolver(db)
 	if err != nil {
-		log.Fatal(err)
+		return s, err
 	}
-	c := cache.NewIPFSCache(url, shell)
-	a := v1alpha.NewAPI(c, r, tm, repository, hm)
-	a.Register(g)
 
-	u := users.NewUserAPI(m, c, r, repository)
-	u.Register(g)
+	pinStore, err := sqlitepinning.NewStore(db)
+	if err != nil {
+		return s, err
+	}
+
+	var remote *pinning.Client
+	if endpoint := viper.GetString("pinning.endpoint"); len(endpoint) > 0 {
+		remote = pinning.NewClient(endpoint, viper.GetString("pinning.token"))
+	}
+
+	s.pins = pinning.NewManager(pinStore, shell, remote, viper.GetDuration("pinning.retention"), viper.GetDuration("pinning.gcInterval"))
+	s.resolver = pinning.NewResolver(r, s.pins, v1alpha.PINNED_PREFIXES)
+
+	return s, nil
+}
+
+func newShell() *shell.Shell {
+	url := viper.GetString("ipfs.apiEndpoint")
+	projectId := viper.GetString("ipfs.projectId")
+	projectSecret := viper.GetString("ipfs.projectSecret")
+
+	httpClient := &http.Client{}
+
+	if projectId != "" && projectSecret != "" {
+		httpClient = &http.Client{
+			Transport: authTransport{
+				RoundTripper:  http.DefaultTransport,
+				ProjectId:     projectId,
+				ProjectSecret: projectSecret,
+			},
+		}
+	}
 
-	log.Fatal(e.Start(fmt.Sprintf("%s:%d", host, port)))
+	return shell.NewShellWithClient(url, httpClient)
 }
 
 // authTransport decorates each request with a basic auth header.
@@ -0,0 +1,212 @@
+package synth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/metaconflux/backend/internal/api/admin"
+	"github.com/metaconflux/backend/internal/api/v1alpha"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	search_flag = "search"
+	offset_flag = "offset"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Manage users and manifests of the gateway, the token has to belong to an admin address",
+}
+
+var adminStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Count the users, manifests, resolver entries and hook deliveries",
+	Run: func(cmd *cobra.Command, args []string) {
+		var stats admin.Stats
+		adminRun(http.MethodGet, "stats/", nil, &stats)
+	},
+}
+
+var adminTiersCmd = &cobra.Command{
+	Use:   "tiers",
+	Short: "List the tiers, tier 0 is the default one",
+	Run: func(cmd *cobra.Command, args []string) {
+		var tiers []admin.Tier
+		adminRun(http.MethodGet, "tiers/", nil, &tiers)
+	},
+}
+
+var adminUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "List the users, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		search, _ := cmd.Flags().GetString(search_flag)
+		limit, _ := cmd.Flags().GetInt(limit_flag)
+		offset, _ := cmd.Flags().GetInt(offset_flag)
+
+		query := url.Values{}
+		if len(search) > 0 {
+			query.Set("search", search)
+		}
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(offset))
+
+		var page admin.UserPage
+		adminRun(http.MethodGet, fmt.Sprintf("users/?%s", query.Encode()), nil, &page)
+	},
+}
+
+var adminUserCmd = &cobra.Command{
+	Use:   "user <id|address>",
+	Short: "Show a user and their manifests",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var user admin.UserDetail
+		adminRun(http.MethodGet, fmt.Sprintf("users/%s/", url.PathEscape(args[0])), nil, &user)
+	},
+}
+
+var adminSetTierCmd = &cobra.Command{
+	Use:   "set-tier <id|address> <tierId>",
+	Short: "Move a user to another tier",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		tierId, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			logrus.Fatalf("Invalid tier id %s", args[1])
+		}
+
+		var user admin.UserDetail
+		adminRun(http.MethodPut, fmt.Sprintf("users/%s/tier/", url.PathEscape(args[0])), admin.TierRequest{TierID: uint(tierId)}, &user)
+	},
+}
+
+var adminDisableCmd = &cobra.Command{
+	Use:   "disable <id|address>",
+	Short: "Block the sign in and the API for a user, their collections are still served",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var user admin.UserDetail
+		adminRun(http.MethodPost, fmt.Sprintf("users/%s/disable/", url.PathEscape(args[0])), nil, &user)
+	},
+}
+
+var adminEnableCmd = &cobra.Command{
+	Use:   "enable <id|address>",
+	Short: "Enable a disabled user again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var user admin.UserDetail
+		adminRun(http.MethodPost, fmt.Sprintf("users/%s/enable/", url.PathEscape(args[0])), nil, &user)
+	},
+}
+
+var adminManifestCmd = &cobra.Command{
+	Use:   "manifest <chainId> <contract|alias>",
+	Short: "Show any manifest with its owner and resolver entries",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var inspection v1alpha.Inspection
+		adminRun(http.MethodGet, fmt.Sprintf("manifests/%s/%s/", args[0], args[1]), nil, &inspection)
+	},
+}
+
+var adminRefreshCmd = &cobra.Command{
+	Use:   "refresh <chainId> <contract|alias>",
+	Short: "Expire every result of any manifest and generate its collection metadata again",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var result v1alpha.RefreshResult
+		adminRun(http.MethodPost, fmt.Sprintf("manifests/%s/%s/refresh/", args[0], args[1]), nil, &result)
+	},
+}
+
+// adminRun sends the request to the admin API of the configured gateway and
+// prints the response
+func adminRun(method string, path string, body interface{}, target interface{}) {
+	err := adminRequest(method, path, body, target)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	err = utils.JsonPretty(target)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func adminRequest(method string, path string, body interface{}, target interface{}) error {
+	token := viper.GetString("token")
+	if token == "" {
+		return fmt.Errorf("Auth token missing in config")
+	}
+	gateway := viper.GetString("gateway")
+	if gateway == "" {
+		return fmt.Errorf("Failed to load gateway from config")
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/api/%s/%s", gateway, admin.GROUP, path), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr utils.ApiError
+		if json.Unmarshal(respData, &apiErr) == nil && len(apiErr.Error) > 0 {
+			return fmt.Errorf("Failed with status %s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("Failed with status %s", resp.Status)
+	}
+
+	return json.Unmarshal(respData, target)
+}
+
+func init() {
+	adminUsersCmd.Flags().String(search_flag, "", "Part of the id, address or email")
+	adminUsersCmd.Flags().Int(limit_flag, admin.DEFAULT_USERS_LIMIT, "Maximum number of users")
+	adminUsersCmd.Flags().Int(offset_flag, 0, "Number of users to skip")
+
+	adminCmd.AddCommand(adminStatsCmd)
+	adminCmd.AddCommand(adminTiersCmd)
+	adminCmd.AddCommand(adminUsersCmd)
+	adminCmd.AddCommand(adminUserCmd)
+	adminCmd.AddCommand(adminSetTierCmd)
+	adminCmd.AddCommand(adminDisableCmd)
+	adminCmd.AddCommand(adminEnableCmd)
+	adminCmd.AddCommand(adminManifestCmd)
+	adminCmd.AddCommand(adminRefreshCmd)
+
+	rootCmd.AddCommand(adminCmd)
+}
@@ -0,0 +1,279 @@
+package synth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/metaconflux/backend/internal/api/v1alpha"
+	"github.com/metaconflux/backend/internal/car"
+	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	range_flag     = "range"
+	out_flag       = "out"
+	car_flag       = "car"
+	workers_flag   = "workers"
+	keepInfo_flag  = "keep-info"
+	workersDefault = 8
+)
+
+type exportResult struct {
+	id   uint64
+	data []byte
+	err  error
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Render a range of tokens to <id>.json files and/or a CAR file",
+	Long: `Render every token of the range with the manifest and write the results
+as <id>.json files to the output directory and/or as a CARv1 archive holding
+a UnixFS directory of the same files. The CIDs match ipfs add --cid-version=1,
+so the archive can be verified and imported (ipfs dag import) anywhere.
+Directories ipfs add would shard, from roughly 6000 tokens on, are rejected,
+export larger ranges as several archives.
+
+The manifest info (generation time and runtime) is dropped unless
+--keep-info is set, so exports of the same manifest result in the same CIDs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manifest, err := cmd.Flags().GetString(manifest_flag)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		tokenRange, _ := cmd.Flags().GetString(range_flag)
+		out, _ := cmd.Flags().GetString(out_flag)
+		carFile, _ := cmd.Flags().GetString(car_flag)
+		workers, _ := cmd.Flags().GetInt(workers_flag)
+		keepInfo, _ := cmd.Flags().GetBool(keepInfo_flag)
+
+		if len(out) == 0 && len(carFile) == 0 {
+			cmd.Help()
+			logrus.Fatal("Need --out and/or --car")
+		}
+
+		start, end, err := parseRange(tokenRange)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if workers < 1 {
+			workers = 1
+		}
+
+		b, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		var m v1alpha.Manifest
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if TransformerMananager == nil {
+			logrus.Fatal(fmt.Errorf("Failed to load Transformer Manager"))
+		}
+
+		if len(out) > 0 {
+			err = os.MkdirAll(out, 0755)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		}
+
+		logrus.Infof("Exporting tokens %d-%d of manifest '%s' with %d workers", start, end, manifest, workers)
+
+		ids := make(chan uint64)
+		results := make(chan exportResult)
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for id := range ids {
+					data, err := render(cmd, m, id, keepInfo)
+					results <- exportResult{id: id, data: data, err: err}
+				}
+			}()
+		}
+
+		go func() {
+			defer close(ids)
+			for id := start; id <= end; id++ {
+				select {
+				case ids <- id:
+				case <-cmd.Context().Done():
+					return
+				}
+
+				if id == end {
+					break
+				}
+			}
+		}()
+
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		rendered := make(map[uint64][]byte)
+		failures := make(map[uint64]error)
+		done := 0
+		for result := range results {
+			done++
+			if done%1000 == 0 {
+				logrus.Infof("Rendered %d tokens", done)
+			}
+
+			if result.err != nil {
+				logrus.Errorf("Token %d failed: %s", result.id, result.err)
+				failures[result.id] = result.err
+				continue
+			}
+
+			if len(out) > 0 {
+				err = ioutil.WriteFile(filepath.Join(out, fmt.Sprintf("%d.json", result.id)), result.data, 0644)
+				if err != nil {
+					logrus.Errorf("Token %d failed: %s", result.id, err)
+					failures[result.id] = err
+					continue
+				}
+			}
+
+			if len(carFile) > 0 {
+				rendered[result.id] = result.data
+			}
+		}
+
+		if cmd.Context().Err() != nil {
+			logrus.Fatal("Export interrupted")
+		}
+
+		if len(carFile) > 0 {
+			root, err := writeCar(carFile, rendered)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			logrus.Infof("Wrote %s with root %s", carFile, root)
+		}
+
+		total := end - start + 1
+		logrus.Infof("Exported %d of %d tokens", total-uint64(len(failures)), total)
+
+		if len(failures) > 0 {
+			failed := make([]uint64, 0, len(failures))
+			for id := range failures {
+				failed = append(failed, id)
+			}
+			sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+
+			for _, id := range failed {
+				fmt.Fprintf(os.Stderr, "%d\t%s\n", id, failures[id])
+			}
+			logrus.Fatalf("%d tokens failed", len(failures))
+		}
+	},
+}
+
+func render(cmd *cobra.Command, m v1alpha.Manifest, id uint64, keepInfo bool) ([]byte, error) {
+	tokenId, err := v1alpha.ParseTokenID(strconv.FormatUint(id, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{
+		"id":       tokenId.Decimal,
+		"idHex":    tokenId.Hex,
+		"contract": m.Contract,
+		"chainId":  strconv.FormatInt(m.ChainID, 10),
+	}
+
+	result, err := TransformerMananager.Execute(cmd.Context(), m.Transformers, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keepInfo {
+		delete(result, transformers.MANIFEST_INFO)
+	}
+
+	return json.Marshal(result)
+}
+
+func writeCar(path string, rendered map[uint64][]byte) (string, error) {
+	ids := make([]uint64, 0, len(rendered))
+	for id := range rendered {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	builder := car.NewBuilder()
+	for _, id := range ids {
+		_, err := builder.AddFile(fmt.Sprintf("%d.json", id), rendered[id])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	root, err := builder.Write(f)
+	if err != nil {
+		return "", err
+	}
+
+	return root.String(), f.Close()
+}
+
+// parseRange parses "<start>-<end>" (inclusive) or a single id
+func parseRange(value string) (uint64, uint64, error) {
+	parts := strings.SplitN(value, "-", 2)
+
+	start, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid range %s", value)
+	}
+
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid range %s", value)
+		}
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("Invalid range %s, end is before start", value)
+	}
+
+	return start, end, nil
+}
+
+func init() {
+	exportCmd.Flags().String(range_flag, "", "Token ids to export, e.g. 1-10000")
+	exportCmd.Flags().String(out_flag, "", "Directory to write the <id>.json files to")
+	exportCmd.Flags().String(car_flag, "", "CARv1 file to write")
+	exportCmd.Flags().Int(workers_flag, workersDefault, "Number of tokens rendered in parallel")
+	exportCmd.Flags().Bool(keepInfo_flag, false, "Keep the manifest info in the results")
+	exportCmd.MarkFlagRequired(range_flag)
+
+	rootCmd.AddCommand(exportCmd)
+}
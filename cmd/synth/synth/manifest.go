@@ -140,7 +140,7 @@ var pushCmd = &cobra.Command{
 				logrus.Error(err)
 			}
 
-			logrus.Infof("%s", apiErr)
+			logrus.Infof("%v", apiErr)
 
 			logrus.Fatalf("Failed with status %s", apiErr.Error)
 		}
@@ -0,0 +1,203 @@
+package synth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/metaconflux/backend/internal/api/v1alpha"
+	"github.com/metaconflux/backend/internal/pinning"
+	sqlitepinning "github.com/metaconflux/backend/internal/pinning/sqlite"
+	"github.com/metaconflux/backend/internal/resolver"
+	sqliteresolver "github.com/metaconflux/backend/internal/resolver/sqlite"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	db_flag        = "db"
+	db_default     = "./gorm.db"
+	limit_flag     = "limit"
+	after_flag     = "after"
+	expired_flag   = "expired"
+	retention_flag = "retention"
+)
+
+var resolverCmd = &cobra.Command{
+	Use:   "resolver",
+	Short: "Inspect and maintain the resolver of a server database",
+}
+
+var resolverKeysCmd = &cobra.Command{
+	Use:   "keys <prefix>",
+	Short: "List the entries with keys starting with the prefix, e.g. token#1#0xabc...#",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		r, _ := openResolver(cmd)
+
+		limit, _ := cmd.Flags().GetInt(limit_flag)
+		after, _ := cmd.Flags().GetString(after_flag)
+		expired, _ := cmd.Flags().GetBool(expired_flag)
+
+		query := resolver.Query{
+			Prefix: args[0],
+			After:  after,
+			Limit:  limit,
+		}
+		if expired {
+			query.ExpiredBefore = time.Now()
+		}
+
+		entries, err := r.List(query)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		err = utils.JsonPretty(entries)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var resolverStatsCmd = &cobra.Command{
+	Use:   "stats [prefix...]",
+	Short: "Count the entries per namespace or with keys starting with the prefixes",
+	Run: func(cmd *cobra.Command, args []string) {
+		r, _ := openResolver(cmd)
+
+		prefixes := args
+		if len(prefixes) == 0 {
+			prefixes = v1alpha.NAMESPACES
+		}
+
+		stats := make(map[string]resolver.Stats)
+		for _, prefix := range prefixes {
+			s, err := r.Count(prefix)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			stats[prefix] = s
+		}
+
+		if len(args) == 0 {
+			s, err := r.Count("")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			stats["total"] = s
+		}
+
+		err := utils.JsonPretty(stats)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var resolverExpireCmd = &cobra.Command{
+	Use:   "expire <prefix>",
+	Short: "Expire the results with keys starting with the prefix, so they are generated again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix := args[0]
+
+		// Expired manifests and markers would break serving the collection
+		result := false
+		for _, p := range v1alpha.RESULT_PREFIXES {
+			if strings.HasPrefix(prefix, p) {
+				result = true
+			}
+		}
+		if !result {
+			logrus.Fatalf("Only results can be expired, the prefix has to start with one of %s", strings.Join(v1alpha.RESULT_PREFIXES, ", "))
+		}
+
+		r, _ := openResolver(cmd)
+
+		expired, err := resolver.ExpirePrefix(r, prefix)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		logrus.Infof("Expired %d entries", expired)
+	},
+}
+
+var resolverSweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Delete results expired for longer than the retention and purge deleted entries",
+	Long: `Delete results expired for longer than the retention and purge deleted
+entries, the same as the server does periodically. Results of frozen
+collections are kept. The objects of swept results are released for the
+garbage collection of the server.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		r, db := openResolver(cmd)
+
+		retention, _ := cmd.Flags().GetDuration(retention_flag)
+
+		store, err := sqlitepinning.NewStore(db)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		// Only releases refs, unpinning is left to the server which has
+		// the IPFS node
+		manager := pinning.NewManager(store, nil, nil, 0, 0)
+		r = pinning.NewResolver(r, manager, v1alpha.PINNED_PREFIXES)
+
+		sweeper := resolver.NewSweeper(r, v1alpha.NewSweepPolicy(r), retention, 0)
+
+		result, err := sweeper.Sweep(cmd.Context())
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		err = utils.JsonPretty(result)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+func openResolver(cmd *cobra.Command) (resolver.IResolver, *gorm.DB) {
+	path, _ := cmd.Flags().GetString(db_flag)
+
+	// Opening a missing file would create an empty database
+	_, err := os.Stat(path)
+	if err != nil {
+		logrus.Fatal(fmt.Errorf("Failed to open database %s: %s", path, err))
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	r, err := sqliteresolver.NewResolver(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	return r, db
+}
+
+func init() {
+	resolverCmd.PersistentFlags().String(db_flag, db_default, "Path to the server database")
+
+	resolverKeysCmd.Flags().Int(limit_flag, 100, "Maximum number of entries, 0 lists all")
+	resolverKeysCmd.Flags().String(after_flag, "", "Key the listing starts after")
+	resolverKeysCmd.Flags().Bool(expired_flag, false, "Only list entries whose lifetime is over")
+
+	resolverSweepCmd.Flags().Duration(retention_flag, resolver.DEFAULT_SWEEP_RETENTION, "How long expired results are kept")
+
+	resolverCmd.AddCommand(resolverKeysCmd)
+	resolverCmd.AddCommand(resolverStatsCmd)
+	resolverCmd.AddCommand(resolverExpireCmd)
+	resolverCmd.AddCommand(resolverSweepCmd)
+	rootCmd.AddCommand(resolverCmd)
+}
@@ -1,13 +1,20 @@
 package synth
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
 
 	"github.com/metaconflux/backend/internal/chains"
 	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/compute"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/contract"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/local"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/print"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/svg"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/switchcase"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/traits"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -34,7 +41,10 @@ func Execute() {
 		logrus.Fatal(err)
 	}
 
-	err = rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -79,5 +89,34 @@ func PrepTransformers() (*transformers.Transformers, error) {
 		return nil, err
 	}
 
+	computeT := compute.NewTransformer()
+
+	err = tm.Register(compute.GVK, computeT.WithSpec, compute.NewSpecFromPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	traitsT := traits.NewTransformer()
+
+	err = tm.Register(traits.GVK, traitsT.WithSpec, traits.NewSpecFromPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	// No IPFS node in the CLI, SVGs can only be inlined as data URIs
+	svgT := svg.NewTransformer(nil, nil)
+
+	err = tm.Register(svg.GVK, svgT.WithSpec, svg.NewSpecFromPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	switchT := switchcase.NewTransformer(tm)
+
+	err = tm.Register(switchcase.GVK, switchT.WithSpec, switchcase.NewSpecFromPrompt)
+	if err != nil {
+		return nil, err
+	}
+
 	return tm, nil
 }
@@ -42,7 +42,7 @@ var runCmd = &cobra.Command{
 		if TransformerMananager == nil {
 			logrus.Fatal(fmt.Errorf("Failed to load Transformer Manager"))
 		}
-		result, err := TransformerMananager.Execute(m.Transformers, params)
+		result, err := TransformerMananager.Execute(cmd.Context(), m.Transformers, params)
 		if err != nil {
 			logrus.Fatal(err)
 		}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 
 	shell "github.com/ipfs/go-ipfs-api"
 	"github.com/lmittmann/w3"
 	"github.com/metaconflux/backend/internal/api/v1alpha"
+	cache "github.com/metaconflux/backend/internal/cache/ipfs"
+	"github.com/metaconflux/backend/internal/resolver/memory"
 	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/composite"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/compute"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/contract"
 	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/ipfs"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/svg"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/switchcase"
+	"github.com/metaconflux/backend/internal/transformers/core/v1alpha/traits"
 	"github.com/metaconflux/backend/internal/utils"
 )
 
@@ -48,6 +57,43 @@ func main() {
 		log.Fatal(err)
 	}
 
+	computeT := compute.NewTransformer()
+
+	err = tm.Register(compute.GVK, computeT.WithSpec, compute.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	traitsT := traits.NewTransformer()
+
+	err = tm.Register(traits.GVK, traitsT.WithSpec, traits.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := cache.NewIPFSCache(url, shell)
+
+	imageT := composite.NewTransformer(shell, c, memory.NewResolver())
+
+	err = tm.Register(composite.GVK, imageT.WithSpec, composite.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	svgT := svg.NewTransformer(shell, c)
+
+	err = tm.Register(svg.GVK, svgT.WithSpec, svg.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switchT := switchcase.NewTransformer(tm)
+
+	err = tm.Register(switchcase.GVK, switchT.WithSpec, switchcase.NewSpecFromPrompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	data, err := ioutil.ReadFile(os.Args[1])
 	if err != nil {
 		log.Fatal(err)
@@ -59,7 +105,10 @@ func main() {
 		log.Fatal(err)
 	}
 
-	result, err := tm.Execute(manifest.Transformers, map[string]interface{}{"id": 1})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := tm.Execute(ctx, manifest.Transformers, map[string]interface{}{"id": 1})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,284 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/metaconflux/backend/internal/api/users/repository"
+	"github.com/metaconflux/backend/internal/api/v1alpha"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/utils"
+)
+
+const (
+	GROUP = "admin"
+
+	DEFAULT_USERS_LIMIT = 50
+	MAX_USERS_LIMIT     = 500
+)
+
+var ErrNotAdmin = fmt.Errorf("Admin role required")
+var ErrUserNotFound = fmt.Errorf("User not found")
+
+func (a AdminApi) Register(g *echo.Group) {
+	ag := g.Group(fmt.Sprintf("/%s", GROUP), a.requireAdmin)
+	ag.GET("/stats/", a.GetStats)
+	ag.GET("/tiers/", a.ListTiers)
+	ag.GET("/users/", a.ListUsers)
+	ag.GET("/users/:id/", a.GetUser)
+	ag.PUT("/users/:id/tier/", a.SetTier)
+	ag.POST("/users/:id/disable/", a.DisableUser)
+	ag.POST("/users/:id/enable/", a.EnableUser)
+	ag.GET("/manifests/:chainId/:contract/", a.InspectManifest)
+	ag.POST("/manifests/:chainId/:contract/refresh/", a.RefreshManifest)
+}
+
+// requireAdmin lets through the users signed in with an admin address
+func (a AdminApi) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := c.Get("user").(*jwt.StandardClaims)
+		if !ok || !a.isAdmin(user.Subject) {
+			return c.JSON(utils.NewApiError(http.StatusForbidden, ErrNotAdmin))
+		}
+
+		return next(c)
+	}
+}
+
+func (a AdminApi) isAdmin(address string) bool {
+	return a.admins[strings.ToLower(address)]
+}
+
+// GetStats counts the users, manifests, resolver entries and hook deliveries
+func (a AdminApi) GetStats(c echo.Context) error {
+	var stats Stats
+	var err error
+
+	stats.Repository, err = a.repository.Stats(c.Request().Context())
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	stats.Resolver = make(map[string]resolver.Stats)
+	for _, prefix := range v1alpha.NAMESPACES {
+		stats.Resolver[prefix], err = a.resolver.Count(prefix)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+	}
+
+	stats.Deliveries, err = a.outbox.Stats(c.Request().Context())
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+func (a AdminApi) ListTiers(c echo.Context) error {
+	models, err := a.repository.GetTiers(c.Request().Context())
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	tiers := make([]Tier, 0, len(models))
+	for _, m := range models {
+		tiers = append(tiers, Tier{ID: m.ID, Name: m.Name})
+	}
+
+	return c.JSON(http.StatusOK, tiers)
+}
+
+// ListUsers pages through the users, newest first. The search query matches
+// parts of the id, address or email.
+func (a AdminApi) ListUsers(c echo.Context) error {
+	query := repository.UserQuery{
+		Search: c.QueryParam("search"),
+		Limit:  DEFAULT_USERS_LIMIT,
+	}
+
+	var err error
+	if raw := c.QueryParam("limit"); len(raw) > 0 {
+		query.Limit, err = strconv.Atoi(raw)
+		if err != nil || query.Limit < 1 || query.Limit > MAX_USERS_LIMIT {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Limit has to be between 1 and %d", MAX_USERS_LIMIT)))
+		}
+	}
+
+	if raw := c.QueryParam("offset"); len(raw) > 0 {
+		query.Offset, err = strconv.Atoi(raw)
+		if err != nil || query.Offset < 0 {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Invalid offset %s", raw)))
+		}
+	}
+
+	models, total, err := a.repository.ListUsers(c.Request().Context(), query)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	page := UserPage{Users: make([]User, 0, len(models)), Total: total}
+	for _, m := range models {
+		page.Users = append(page.Users, a.newUser(m))
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetUser returns the user with the id or address and their manifests
+func (a AdminApi) GetUser(c echo.Context) error {
+	um, status, err := a.getUser(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	return a.respondUser(c, um)
+}
+
+func (a AdminApi) SetTier(c echo.Context) error {
+	var data TierRequest
+	err := c.Bind(&data)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	um, status, err := a.getUser(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	err = a.repository.SetTier(c.Request().Context(), um.ID, data.TierID)
+	if err != nil {
+		if err == repository.ErrUnknownTier {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+		}
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+	um.TierID = data.TierID
+
+	return a.respondUser(c, um)
+}
+
+// DisableUser blocks the sign in and the API for the user, their collections
+// are still served
+func (a AdminApi) DisableUser(c echo.Context) error {
+	return a.setDisabled(c, true)
+}
+
+func (a AdminApi) EnableUser(c echo.Context) error {
+	return a.setDisabled(c, false)
+}
+
+func (a AdminApi) setDisabled(c echo.Context, disabled bool) error {
+	um, status, err := a.getUser(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	// Admins are only removed from the config, so nobody locks themselves
+	// out by accident
+	if disabled && a.isAdmin(um.Address) {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Admins cannot be disabled")))
+	}
+
+	err = a.repository.SetDisabled(c.Request().Context(), um.ID, disabled)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+	um.Disabled = disabled
+
+	return a.respondUser(c, um)
+}
+
+// InspectManifest returns any manifest with its owner and resolver entries
+func (a AdminApi) InspectManifest(c echo.Context) error {
+	inspection, err := a.manifests.Inspect(c.Request().Context(), c.Param("chainId"), c.Param("contract"))
+	if err != nil {
+		return c.JSON(utils.NewApiError(manifestStatus(err), err))
+	}
+
+	return c.JSON(http.StatusOK, inspection)
+}
+
+// RefreshManifest expires the results of any manifest and regenerates its
+// collection metadata
+func (a AdminApi) RefreshManifest(c echo.Context) error {
+	result, err := a.manifests.ForceRefresh(c.Request().Context(), c.Param("chainId"), c.Param("contract"))
+	if err != nil {
+		return c.JSON(utils.NewApiError(manifestStatus(err), err))
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// getUser loads the user the id route parameter refers to, either by id or
+// by address
+func (a AdminApi) getUser(c echo.Context) (repository.UserModel, int, error) {
+	id := c.Param("id")
+
+	um, err := a.repository.Get(c.Request().Context(), id)
+	if err != nil {
+		return um, http.StatusInternalServerError, err
+	}
+
+	if len(um.ID) == 0 && common.IsHexAddress(id) {
+		users, _, err := a.repository.ListUsers(c.Request().Context(), repository.UserQuery{Address: id, Limit: 1})
+		if err != nil {
+			return um, http.StatusInternalServerError, err
+		}
+		if len(users) > 0 {
+			um = users[0]
+		}
+	}
+
+	if len(um.ID) == 0 {
+		return um, http.StatusNotFound, ErrUserNotFound
+	}
+
+	return um, http.StatusOK, nil
+}
+
+func (a AdminApi) respondUser(c echo.Context, um repository.UserModel) error {
+	manifests, err := a.repository.GetManifests(c.Request().Context(), um.ID)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	detail := UserDetail{User: a.newUser(um), Manifests: make([]Manifest, 0, len(manifests))}
+	for _, m := range manifests {
+		detail.Manifests = append(detail.Manifests, Manifest{
+			ChainId:   m.ChainId,
+			Address:   m.Address,
+			CreatedAt: m.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, detail)
+}
+
+func (a AdminApi) newUser(m repository.UserModel) User {
+	return User{
+		ID:        m.ID,
+		Email:     m.Email,
+		Address:   m.Address,
+		TierID:    m.TierID,
+		Disabled:  m.Disabled,
+		Admin:     a.isAdmin(m.Address),
+		LastLogin: m.LastLogin,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+func manifestStatus(err error) int {
+	if err == resolver.ErrNotFound || err == v1alpha.ErrNoDeployment || errors.Is(err, v1alpha.ErrUnknownContract) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"strings"
+	"time"
+
+	"github.com/metaconflux/backend/internal/api/users/repository"
+	"github.com/metaconflux/backend/internal/api/v1alpha"
+	"github.com/metaconflux/backend/internal/hooks/outbox"
+	"github.com/metaconflux/backend/internal/resolver"
+)
+
+type AdminApi struct {
+	admins     map[string]bool
+	repository repository.UserRepository
+	resolver   resolver.IResolver
+	manifests  v1alpha.API
+	outbox     *outbox.Dispatcher
+}
+
+// NewAdminAPI creates the operator API, only the users signed in with one of
+// the admin addresses can use it
+func NewAdminAPI(admins []string, repository repository.UserRepository, resolver resolver.IResolver, manifests v1alpha.API, outbox *outbox.Dispatcher) AdminApi {
+	a := AdminApi{
+		admins:     make(map[string]bool),
+		repository: repository,
+		resolver:   resolver,
+		manifests:  manifests,
+		outbox:     outbox,
+	}
+
+	for _, address := range admins {
+		a.admins[strings.ToLower(address)] = true
+	}
+
+	return a
+}
+
+// User is a user as operators see it, without the login nonce
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Address   string    `json:"address"`
+	TierID    uint      `json:"tierId"`
+	Disabled  bool      `json:"disabled"`
+	Admin     bool      `json:"admin"`
+	LastLogin time.Time `json:"lastLogin"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type UserPage struct {
+	Users []User `json:"users"`
+	Total int64  `json:"total"`
+}
+
+type Manifest struct {
+	ChainId   int64     `json:"chainId"`
+	Address   string    `json:"address"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type UserDetail struct {
+	User
+	Manifests []Manifest `json:"manifests"`
+}
+
+type Tier struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type TierRequest struct {
+	TierID uint `json:"tierId"`
+}
+
+type Stats struct {
+	Repository repository.Stats `json:"repository"`
+	// Resolver counts the entries per namespace
+	Resolver map[string]resolver.Stats `json:"resolver"`
+	// Deliveries counts the hook deliveries per status
+	Deliveries map[string]int64 `json:"deliveries"`
+}
@@ -20,6 +20,8 @@ const (
 
 const DEFAULT_LIFETIME = 730 * time.Hour
 
+var ErrDisabled = fmt.Errorf("Account is disabled")
+
 func (a UserApi) Register(g *echo.Group) {
 	ug := g.Group(fmt.Sprintf("/auth/%s", VERSION))
 	ug.POST("/", a.SignIn)
@@ -52,6 +54,10 @@ func (a UserApi) SignIn(c echo.Context) error {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
+	if um.Disabled {
+		return c.JSON(utils.NewApiError(http.StatusForbidden, ErrDisabled))
+	}
+
 	err = a.repository.NewLogin(c.Request().Context(), um.ID, utils.RandStringBytes(8))
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
@@ -2,13 +2,40 @@ package sqliterepo
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/metaconflux/backend/internal/api/users/repository"
+	"github.com/metaconflux/backend/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (r *Sqlite) Migrate() error {
-	return r.db.AutoMigrate(&repository.TierModel{}, &repository.UserModel{}, &repository.ManifestModel{})
+	err := r.db.AutoMigrate(&repository.TierModel{}, &repository.UserModel{}, &repository.ManifestModel{}, &repository.DomainModel{})
+	if err != nil {
+		return err
+	}
+
+	// Manifests used to be unique per address only, which prevented
+	// deployments of the same address on several chains
+	if r.db.Migrator().HasIndex(&repository.ManifestModel{}, "idx_manifest_models_address") {
+		err = r.db.Migrator().DropIndex(&repository.ManifestModel{}, "idx_manifest_models_address")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Domains used to be unique across collections from the first claim on
+	if r.db.Migrator().HasIndex(&repository.DomainModel{}, "idx_domain_models_domain") {
+		err = r.db.Migrator().DropIndex(&repository.DomainModel{}, "idx_domain_models_domain")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (r *Sqlite) Create(c context.Context, user repository.UserModel) error {
@@ -71,3 +98,309 @@ func (r *Sqlite) GetManifests(c context.Context, userId string) ([]repository.Ma
 
 	return manifests, nil
 }
+
+func (r *Sqlite) GetManifest(c context.Context, chainId int64, address string) (repository.ManifestModel, error) {
+	var manifest repository.ManifestModel
+	result := r.db.First(&manifest, "chain_id = ? AND lower(address) = lower(?)", chainId, address)
+	if result.Error != nil {
+		return manifest, result.Error
+	}
+
+	return manifest, nil
+}
+
+func (r *Sqlite) DeleteManifest(c context.Context, chainId int64, address string) error {
+	// Unscoped so the pair can be created again
+	result := r.db.Unscoped().Delete(&repository.ManifestModel{}, "chain_id = ? AND lower(address) = lower(?)", chainId, address)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (r *Sqlite) SetHookSecret(c context.Context, chainId int64, address string, secret string) error {
+	result := r.db.Model(&repository.ManifestModel{}).Where("chain_id = ? AND lower(address) = lower(?)", chainId, address).Update("hook_secret", secret)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (r *Sqlite) CreateDomain(c context.Context, domain repository.DomainModel) error {
+	result := r.db.Create(&domain)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (r *Sqlite) GetDomain(c context.Context, domain string) (repository.DomainModel, error) {
+	var model repository.DomainModel
+	result := r.db.First(&model, "domain = ? AND verified = ?", domain, true)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return model, repository.ErrNotFound
+		}
+		return model, result.Error
+	}
+
+	return model, nil
+}
+
+func (r *Sqlite) GetDomainClaim(c context.Context, domain string, chainId int64, address string) (repository.DomainModel, error) {
+	var model repository.DomainModel
+	result := r.db.First(&model, "domain = ? AND chain_id = ? AND lower(address) = lower(?)", domain, chainId, address)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return model, repository.ErrNotFound
+		}
+		return model, result.Error
+	}
+
+	return model, nil
+}
+
+func (r *Sqlite) GetDomains(c context.Context, chainId int64, address string) ([]repository.DomainModel, error) {
+	var domains []repository.DomainModel
+	result := r.db.Find(&domains, "chain_id = ? AND lower(address) = lower(?)", chainId, address)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return domains, nil
+}
+
+func (r *Sqlite) VerifyDomain(c context.Context, domain string, chainId int64, address string, method string) error {
+	return r.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		claim := "domain = ? AND chain_id = ? AND lower(address) = lower(?)"
+
+		var verified int64
+		result := tx.Model(&repository.DomainModel{}).Where("domain = ? AND verified = ?", domain, true).Not(claim, domain, chainId, address).Count(&verified)
+		if result.Error != nil {
+			return result.Error
+		}
+		if verified > 0 {
+			return repository.ErrDomainClaimed
+		}
+
+		now := time.Now()
+		result = tx.Model(&repository.DomainModel{}).Where(claim, domain, chainId, address).Updates(&repository.DomainModel{Verified: true, Method: method, VerifiedAt: &now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repository.ErrNotFound
+		}
+
+		// Unscoped so the other collections can claim the domain again once
+		// it is released
+		return tx.Unscoped().Where("domain = ?", domain).Not(claim, domain, chainId, address).Delete(&repository.DomainModel{}).Error
+	})
+}
+
+func (r *Sqlite) DeleteDomain(c context.Context, domain string, chainId int64, address string) error {
+	// Unscoped so the domain can be claimed again
+	result := r.db.Unscoped().Delete(&repository.DomainModel{}, "domain = ? AND chain_id = ? AND lower(address) = lower(?)", domain, chainId, address)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (r *Sqlite) Dump(c context.Context) (repository.Dump, error) {
+	var dump repository.Dump
+
+	err := r.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		result := tx.Order("id").Find(&dump.Tiers)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Order("created_at").Find(&dump.Users)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Order("id").Find(&dump.Manifests)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Order("id").Find(&dump.Domains)
+		return result.Error
+	})
+
+	return dump, err
+}
+
+func (r *Sqlite) Load(c context.Context, dump repository.Dump) error {
+	return r.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var users int64
+		result := tx.Model(&repository.UserModel{}).Count(&users)
+		if result.Error != nil {
+			return result.Error
+		}
+		if users > 0 {
+			return repository.ErrNotEmpty
+		}
+
+		// Users refer to tiers by id, so those are kept
+		for _, tier := range dump.Tiers {
+			result = tx.Create(&tier)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		// Postgres does not advance the sequence for explicit ids, the next
+		// tier would collide with a restored one
+		if tx.Dialector.Name() == database.DRIVER_POSTGRES && len(dump.Tiers) > 0 {
+			result = tx.Exec("SELECT setval(pg_get_serial_sequence('tier_models', 'id'), (SELECT MAX(id) FROM tier_models))")
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		for _, user := range dump.Users {
+			result = tx.Omit(clause.Associations).Create(&user)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		// Nothing refers to manifests and domains by id, new ones keep
+		// the sequences of other databases in line
+		for _, manifest := range dump.Manifests {
+			manifest.Model.ID = 0
+			result = tx.Omit(clause.Associations).Create(&manifest)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		for _, domain := range dump.Domains {
+			domain.Model.ID = 0
+			result = tx.Create(&domain)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *Sqlite) ListUsers(c context.Context, query repository.UserQuery) ([]repository.UserModel, int64, error) {
+	var users []repository.UserModel
+	var total int64
+
+	tx := r.db.WithContext(c).Model(&repository.UserModel{})
+	if len(query.Search) > 0 {
+		pattern := "%" + strings.ToLower(query.Search) + "%"
+		tx = tx.Where("lower(id) LIKE ? OR lower(address) LIKE ? OR lower(email) LIKE ?", pattern, pattern, pattern)
+	}
+	if len(query.Address) > 0 {
+		tx = tx.Where("lower(address) = lower(?)", query.Address)
+	}
+
+	result := tx.Count(&total)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+
+	result = tx.Offset(query.Offset).Order("created_at desc").Find(&users)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	return users, total, nil
+}
+
+func (r *Sqlite) GetTiers(c context.Context) ([]repository.TierModel, error) {
+	var tiers []repository.TierModel
+	result := r.db.WithContext(c).Order("id").Find(&tiers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tiers, nil
+}
+
+func (r *Sqlite) SetTier(c context.Context, id string, tierId uint) error {
+	// Tier 0 is the default one without a row
+	if tierId != 0 {
+		var tier repository.TierModel
+		result := r.db.WithContext(c).First(&tier, tierId)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return repository.ErrUnknownTier
+			}
+			return result.Error
+		}
+	}
+
+	return r.updateUser(c, id, "tier_id", tierId)
+}
+
+func (r *Sqlite) SetDisabled(c context.Context, id string, disabled bool) error {
+	return r.updateUser(c, id, "disabled", disabled)
+}
+
+func (r *Sqlite) updateUser(c context.Context, id string, column string, value interface{}) error {
+	result := r.db.WithContext(c).Model(&repository.UserModel{}).Where("id = ?", id).Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *Sqlite) Stats(c context.Context) (repository.Stats, error) {
+	stats := repository.Stats{Tiers: make(map[uint]int64)}
+
+	err := r.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var tiers []struct {
+			TierID uint
+			Count  int64
+		}
+		result := tx.Model(&repository.UserModel{}).Select("tier_id, count(*) as count").Group("tier_id").Scan(&tiers)
+		if result.Error != nil {
+			return result.Error
+		}
+		for _, t := range tiers {
+			stats.Tiers[t.TierID] = t.Count
+			stats.Users += t.Count
+		}
+
+		result = tx.Model(&repository.UserModel{}).Where("disabled = ?", true).Count(&stats.Disabled)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&repository.ManifestModel{}).Count(&stats.Manifests)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&repository.DomainModel{}).Count(&stats.Domains)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&repository.DomainModel{}).Where("verified = ?", true).Count(&stats.VerifiedDomains)
+		return result.Error
+	})
+
+	return stats, err
+}
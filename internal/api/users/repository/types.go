@@ -2,11 +2,17 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+var ErrNotFound = fmt.Errorf("Not found")
+var ErrNotEmpty = fmt.Errorf("Repository is not empty")
+var ErrUnknownTier = fmt.Errorf("Unknown tier")
+var ErrDomainClaimed = fmt.Errorf("Domain already claimed")
+
 type UserRepository interface {
 	Migrate() error
 	Create(c context.Context, user UserModel) error
@@ -16,6 +22,58 @@ type UserRepository interface {
 	NewLogin(c context.Context, id string, nonce string) error
 	CreateManifest(c context.Context, manifest ManifestModel) error
 	GetManifests(c context.Context, userId string) ([]ManifestModel, error)
+	GetManifest(c context.Context, chainId int64, address string) (ManifestModel, error)
+	DeleteManifest(c context.Context, chainId int64, address string) error
+	SetHookSecret(c context.Context, chainId int64, address string, secret string) error
+	CreateDomain(c context.Context, domain DomainModel) error
+	// GetDomain returns the verified claim of the domain
+	GetDomain(c context.Context, domain string) (DomainModel, error)
+	GetDomainClaim(c context.Context, domain string, chainId int64, address string) (DomainModel, error)
+	GetDomains(c context.Context, chainId int64, address string) ([]DomainModel, error)
+	// VerifyDomain marks the claim verified and drops the claims of other
+	// collections, it fails with ErrDomainClaimed if one was verified first
+	VerifyDomain(c context.Context, domain string, chainId int64, address string, method string) error
+	DeleteDomain(c context.Context, domain string, chainId int64, address string) error
+	// Dump returns every row, to back up the repository or move it to
+	// another backend
+	Dump(c context.Context) (Dump, error)
+	// Load inserts the rows of a dump into an empty repository
+	Load(c context.Context, dump Dump) error
+	// ListUsers returns a page of the users matching the query, newest
+	// first, and how many match in total
+	ListUsers(c context.Context, query UserQuery) ([]UserModel, int64, error)
+	GetTiers(c context.Context) ([]TierModel, error)
+	SetTier(c context.Context, id string, tierId uint) error
+	SetDisabled(c context.Context, id string, disabled bool) error
+	Stats(c context.Context) (Stats, error)
+}
+
+// UserQuery filters the users, Search matches parts of the id, address or
+// email and Address the whole address, both ignoring the case
+type UserQuery struct {
+	Search  string
+	Address string
+	Offset  int
+	Limit   int
+}
+
+// Stats counts the rows of the repository
+type Stats struct {
+	Users    int64 `json:"users"`
+	Disabled int64 `json:"disabled"`
+	// Tiers counts the users per tier id
+	Tiers           map[uint]int64 `json:"tiers"`
+	Manifests       int64          `json:"manifests"`
+	Domains         int64          `json:"domains"`
+	VerifiedDomains int64          `json:"verifiedDomains"`
+}
+
+// Dump holds every row of the repository
+type Dump struct {
+	Tiers     []TierModel
+	Users     []UserModel
+	Manifests []ManifestModel
+	Domains   []DomainModel
 }
 
 type UserModel struct {
@@ -27,6 +85,9 @@ type UserModel struct {
 	Nonce     string    `json:"nonce"`
 	TierID    uint      `json:"tierId" gorm:"default:0"`
 	Tier      TierModel `json:"tier"`
+	// Disabled users cannot sign in or use the API, their collections are
+	// still served
+	Disabled bool `json:"disabled"`
 }
 
 type TierModel struct {
@@ -36,8 +97,26 @@ type TierModel struct {
 
 type ManifestModel struct {
 	gorm.Model
-	Address string    `json:"address" gorm:"uniqueindex"`
-	ChainId int64     `json:"chainId"`
+	// The same address can be deployed on several chains
+	Address string    `json:"address" gorm:"uniqueIndex:idx_manifest_models_chain_address"`
+	ChainId int64     `json:"chainId" gorm:"uniqueIndex:idx_manifest_models_chain_address"`
 	User    UserModel `json:"user"`
 	UserID  string    `json:"user_id"`
+	// HookSecret signs hook payloads, it must never end up in the manifest
+	// as that is pushed to IPFS
+	HookSecret string `json:"-"`
+}
+
+// DomainModel maps a custom domain to a collection, it is only routed once
+// verified. Several collections may claim a domain, the first one verifying
+// it keeps it, so unverified claims cannot block the owner of the domain.
+type DomainModel struct {
+	gorm.Model
+	Domain     string     `json:"domain" gorm:"uniqueindex:idx_domain_claim"`
+	ChainId    int64      `json:"chainId" gorm:"uniqueindex:idx_domain_claim"`
+	Address    string     `json:"address" gorm:"uniqueindex:idx_domain_claim"`
+	Token      string     `json:"token"`
+	Verified   bool       `json:"verified"`
+	Method     string     `json:"method,omitempty"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
 }
@@ -0,0 +1,68 @@
+package v1alpha
+
+import (
+	"context"
+	"strings"
+)
+
+// Inspect returns the manifest serving the contract or alias on the chain
+// together with its owner and resolver entries, whoever owns it
+func (a API) Inspect(ctx context.Context, chainId string, contractOrAlias string) (Inspection, error) {
+	var inspection Inspection
+
+	contract, err := a.resolveContract(chainId, strings.ToLower(contractOrAlias))
+	if err != nil {
+		return inspection, err
+	}
+
+	contract = strings.ToLower(contract)
+
+	inspection.Manifest, inspection.CID, err = a.getDeployment(chainId, contract)
+	if err != nil {
+		return inspection, err
+	}
+
+	um, err := a.repository.GetByAddress(ctx, inspection.Manifest.Owner)
+	if err != nil {
+		return inspection, err
+	}
+	if len(um.ID) > 0 {
+		inspection.Owner = &um
+	}
+
+	inspection.Keys, err = a.keys(inspection.Manifest, chainId, contract)
+
+	return inspection, err
+}
+
+// ForceRefresh expires every result of the contract on the chain and
+// generates the collection metadata again right away. Token results are
+// generated on their next request, those of frozen collections are renewed
+// as usual.
+func (a API) ForceRefresh(ctx context.Context, chainId string, contractOrAlias string) (RefreshResult, error) {
+	var result RefreshResult
+
+	contract, err := a.resolveContract(chainId, strings.ToLower(contractOrAlias))
+	if err != nil {
+		return result, err
+	}
+
+	contract = strings.ToLower(contract)
+
+	_, _, err = a.getDeployment(chainId, contract)
+	if err != nil {
+		return result, err
+	}
+
+	result.Expired, err = a.expireResults(chainId, contract)
+	if err != nil {
+		return result, err
+	}
+
+	result.Collection, err = a.generateCollection(ctx, chainId, contract)
+	if err == ErrNoCollection {
+		err = nil
+	}
+
+	return result, err
+}
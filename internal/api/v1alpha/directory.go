@@ -0,0 +1,311 @@
+package v1alpha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/metaconflux/backend/internal/directory"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// DIRECTORY_WORKERS is how many tokens of a directory are rendered at a time
+const DIRECTORY_WORKERS = 8
+
+const (
+	DIRECTORY_RUNNING   = "running"
+	DIRECTORY_PUBLISHED = "published"
+	DIRECTORY_FAILED    = "failed"
+)
+
+var ErrDirectoryDisabled = fmt.Errorf("Directory publishing is not enabled")
+
+type directoryJobKey struct{}
+
+func (a API) PublishDirectory(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	if a.directories == nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, ErrDirectoryDisabled))
+	}
+
+	if manifest.Config.Directory == nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Manifest has no directory configuration")))
+	}
+
+	started := a.directories.Start(directory.Name(manifest.ChainID, contract), func(ctx context.Context) {
+		a.publishDirectory(ctx, chainId, contract)
+	})
+	if !started {
+		return c.JSON(utils.NewApiError(http.StatusConflict, fmt.Errorf("Directory is already being published")))
+	}
+
+	return c.JSON(http.StatusAccepted, DirectoryStatus{Status: DIRECTORY_RUNNING})
+}
+
+func (a API) GetDirectory(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	status, err := a.getDirectoryStatus(chainId, contract)
+	if err != nil {
+		if err == resolver.ErrNotFound {
+			return c.JSON(utils.NewApiError(http.StatusNotFound, fmt.Errorf("Directory was not published yet")))
+		}
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// publishDirectory renders every token of the directory range, copies the
+// results into the collection directory and points its IPNS key to it
+func (a API) publishDirectory(ctx context.Context, chainId string, contract string) {
+	ctx = context.WithValue(ctx, directoryJobKey{}, true)
+
+	previous, _ := a.getDirectoryStatus(chainId, contract)
+
+	started := time.Now()
+	status := DirectoryStatus{
+		Status:    DIRECTORY_RUNNING,
+		CID:       previous.CID,
+		IPNS:      previous.IPNS,
+		StartedAt: &started,
+	}
+	a.setDirectoryStatus(chainId, contract, status)
+
+	err := a.buildDirectory(ctx, chainId, contract, &status)
+	finished := time.Now()
+	status.FinishedAt = &finished
+	if err != nil {
+		logrus.Errorf("Failed to publish directory of %s/%s: %s", chainId, contract, err)
+		status.Status = DIRECTORY_FAILED
+		status.Error = err.Error()
+	} else {
+		status.Status = DIRECTORY_PUBLISHED
+	}
+
+	a.setDirectoryStatus(chainId, contract, status)
+}
+
+func (a API) buildDirectory(ctx context.Context, chainId string, contract string, status *DirectoryStatus) error {
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return err
+	}
+
+	dir := manifest.Config.Directory
+	if dir == nil {
+		return fmt.Errorf("Manifest has no directory configuration")
+	}
+
+	files, skipped, err := a.renderDirectory(ctx, dir, chainId, contract)
+	if err != nil {
+		return err
+	}
+	status.Skipped = skipped
+
+	name := directory.Name(manifest.ChainID, contract)
+
+	cid, err := a.directories.Build(ctx, name, files)
+	if err != nil {
+		return err
+	}
+
+	ipns, err := a.directories.Publish(ctx, name, cid)
+	if err != nil {
+		return err
+	}
+
+	status.Tokens = len(files)
+	status.CID = cid
+	status.IPNS = ipns
+
+	return nil
+}
+
+type directoryResult struct {
+	tokenId TokenID
+	cid     string
+	err     error
+}
+
+// renderDirectory renders the tokens of the range with DIRECTORY_WORKERS at a
+// time and returns their CIDs by token id. The first failure stops the rest.
+func (a API) renderDirectory(ctx context.Context, dir *Directory, chainId string, contract string) (map[string]string, int, error) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ids := make(chan uint64)
+	results := make(chan directoryResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < DIRECTORY_WORKERS; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				result := directoryResult{}
+				result.tokenId, result.err = ParseTokenID(strconv.FormatUint(id, 10))
+				if result.err == nil {
+					result.cid, result.err = a.tokenCID(jobCtx, result.tokenId, chainId, contract)
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		defer close(ids)
+		// id <= End alone never ends for End == MaxUint64
+		for id := dir.Start; id <= dir.End; id++ {
+			select {
+			case ids <- id:
+			case <-jobCtx.Done():
+				return
+			}
+
+			if id == dir.End {
+				break
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	files := make(map[string]string)
+	skipped := 0
+	var err error
+	for result := range results {
+		switch {
+		case err != nil:
+		case result.err == ErrTokenNotFound:
+			skipped++
+		case result.err != nil:
+			err = fmt.Errorf("Failed to render token %s: %s", result.tokenId.Decimal, result.err)
+			cancel()
+		default:
+			files[result.tokenId.Decimal] = result.cid
+		}
+	}
+
+	// Stopped by the shutdown of the server
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return files, skipped, err
+}
+
+// tokenCID returns the CID of the published token result, rendering it if it
+// is missing or its lifetime is over
+func (a API) tokenCID(ctx context.Context, tokenId TokenID, chainId string, contract string) (string, error) {
+	cacheId := a.formatTokenCacheKey(chainId, contract, tokenId.Decimal)
+
+	cid, err := a.resolver.Get(cacheId)
+	if err == nil {
+		return cid, nil
+	} else if err != resolver.ErrNotFound && err != resolver.ErrLifetime {
+		return "", err
+	}
+
+	result, err := a.generate(ctx, tokenId, chainId, contract)
+	if err != nil {
+		return "", err
+	}
+
+	cid, err = a.resolver.Get(cacheId)
+	if err == nil {
+		return cid, nil
+	}
+
+	// Placeholders are not published by generate
+	return a.cache.Push(result)
+}
+
+// scheduleDirectory republishes the directory after token results changed,
+// once it was published before
+func (a API) scheduleDirectory(ctx context.Context, manifest Manifest, chainId string, contract string) {
+	if a.directories == nil || manifest.Config.Directory == nil {
+		return
+	}
+
+	// Tokens rendered by the job itself are part of it
+	if ctx.Value(directoryJobKey{}) != nil {
+		return
+	}
+
+	status, err := a.getDirectoryStatus(chainId, contract)
+	if err != nil || len(status.CID) == 0 {
+		return
+	}
+
+	a.directories.Schedule(directory.Name(manifest.ChainID, contract), func(ctx context.Context) {
+		a.publishDirectory(ctx, chainId, contract)
+	})
+}
+
+func (a API) getDirectoryStatus(chainId string, contract string) (DirectoryStatus, error) {
+	var status DirectoryStatus
+
+	data, err := a.resolver.Get(a.formatDirectoryKey(chainId, contract))
+	if err != nil {
+		return status, err
+	}
+
+	err = json.Unmarshal([]byte(data), &status)
+	if err != nil {
+		return status, err
+	}
+
+	return status, nil
+}
+
+func (a API) setDirectoryStatus(chainId string, contract string, status DirectoryStatus) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		logrus.Errorf("Failed to encode directory status: %s", err)
+		return
+	}
+
+	err = a.resolver.Set(a.formatDirectoryKey(chainId, contract), string(data), 0)
+	if err != nil {
+		logrus.Errorf("Failed to store directory status: %s", err)
+	}
+}
+
+func (a API) formatDirectoryKey(chainId string, contract string) string {
+	return fmt.Sprintf("directory#%s#%s", chainId, contract)
+}
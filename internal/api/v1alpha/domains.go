@@ -0,0 +1,218 @@
+package v1alpha
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/metaconflux/backend/internal/api/users/repository"
+	"github.com/metaconflux/backend/internal/domains"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func (a API) newDomain(model repository.DomainModel) Domain {
+	return Domain{
+		Domain:       model.Domain,
+		ChainId:      model.ChainId,
+		Contract:     model.Address,
+		Verified:     model.Verified,
+		Method:       model.Method,
+		VerifiedAt:   model.VerifiedAt,
+		TxtRecord:    domains.TXT_PREFIX + model.Domain,
+		TxtValue:     domains.TXT_VALUE_PREFIX + model.Token,
+		WellKnownUrl: a.domains.WellKnownUrl(model.Domain),
+		Token:        model.Token,
+	}
+}
+
+func (a API) AddDomain(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	var data DomainRequest
+	err := c.Bind(&data)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	domain := domains.Normalize(data.Domain)
+	err = domains.Validate(domain)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	// Only a verified claim blocks other collections, anyone can claim a
+	// domain but only its owner can verify it
+	_, err = a.repository.GetDomain(c.Request().Context(), domain)
+	if err == nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Domain %s already claimed", domain)))
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.repository.GetDomainClaim(c.Request().Context(), domain, manifest.ChainID, manifest.Contract)
+	if err == nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Domain %s already added", domain)))
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	token, err := domains.NewToken()
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	model := repository.DomainModel{
+		Domain:  domain,
+		ChainId: manifest.ChainID,
+		Address: strings.ToLower(manifest.Contract),
+		Token:   token,
+	}
+
+	err = a.repository.CreateDomain(c.Request().Context(), model)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusCreated, a.newDomain(model))
+}
+
+func (a API) ListDomains(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	models, err := a.repository.GetDomains(c.Request().Context(), manifest.ChainID, manifest.Contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	result := make([]Domain, 0, len(models))
+	for _, model := range models {
+		result = append(result, a.newDomain(model))
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// getDomain loads the domain and makes sure it belongs to the manifest owned
+// by the caller
+func (a API) getDomain(c echo.Context) (repository.DomainModel, int, error) {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return repository.DomainModel{}, http.StatusInternalServerError, err
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return repository.DomainModel{}, http.StatusUnauthorized, err
+	}
+
+	model, err := a.repository.GetDomainClaim(c.Request().Context(), domains.Normalize(c.Param("domain")), manifest.ChainID, manifest.Contract)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model, http.StatusNotFound, err
+		}
+		return model, http.StatusInternalServerError, err
+	}
+
+	return model, http.StatusOK, nil
+}
+
+func (a API) VerifyDomain(c echo.Context) error {
+	model, status, err := a.getDomain(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	method, err := a.domains.Verify(c.Request().Context(), model.Domain, model.Token, c.QueryParam("method"))
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	err = a.repository.VerifyDomain(c.Request().Context(), model.Domain, model.ChainId, model.Address, method)
+	if err != nil {
+		if err == repository.ErrDomainClaimed {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+		}
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	model, err = a.repository.GetDomainClaim(c.Request().Context(), model.Domain, model.ChainId, model.Address)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, a.newDomain(model))
+}
+
+func (a API) DeleteDomain(c echo.Context) error {
+	model, status, err := a.getDomain(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	err = a.repository.DeleteDomain(c.Request().Context(), model.Domain, model.ChainId, model.Address)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DomainRouter rewrites requests on verified custom domains to the public
+// metadata routes of the collection, e.g. meta.example.xyz/42 is served as
+// /api/v1alpha/metadata/<chainId>/<contract>/42/. It has to be registered as
+// a Pre middleware so the rewrite happens before routing.
+func (a API) DomainRouter() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if strings.HasPrefix(req.URL.Path, "/api/") {
+				return next(c)
+			}
+
+			model, err := a.repository.GetDomain(req.Context(), domains.Normalize(req.Host))
+			if err != nil {
+				if !errors.Is(err, repository.ErrNotFound) {
+					logrus.Errorf("Failed to load domain %s: %s", req.Host, err)
+				}
+				return next(c)
+			}
+
+			if !model.Verified {
+				return next(c)
+			}
+
+			req.URL.Path = fmt.Sprintf("/api/%s/%s/%d/%s/%s", VERSION, PUBLIC_GROUP, model.ChainId, model.Address, strings.TrimPrefix(req.URL.Path, "/"))
+			req.URL.RawPath = ""
+
+			return next(c)
+		}
+	}
+}
@@ -1,16 +1,26 @@
 package v1alpha
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
+	"github.com/lmittmann/w3"
 	"github.com/metaconflux/backend/internal/api/users/repository"
 	"github.com/metaconflux/backend/internal/cache"
+	"github.com/metaconflux/backend/internal/diff"
+	"github.com/metaconflux/backend/internal/directory"
+	"github.com/metaconflux/backend/internal/domains"
 	"github.com/metaconflux/backend/internal/hooks"
+	"github.com/metaconflux/backend/internal/hooks/outbox"
 	"github.com/metaconflux/backend/internal/resolver"
 	"github.com/metaconflux/backend/internal/transformers"
 	"github.com/metaconflux/backend/internal/utils"
@@ -29,6 +39,10 @@ type API struct {
 	repository   repository.UserRepository
 	transformers *transformers.Transformers
 	hooks        hooks.HookManager
+	outbox       *outbox.Dispatcher
+	clients      map[uint64]*w3.Client
+	domains      *domains.Verifier
+	directories  *directory.Publisher
 }
 
 func NewAPI(
@@ -37,6 +51,10 @@ func NewAPI(
 	transformers *transformers.Transformers,
 	repository repository.UserRepository,
 	hooks hooks.HookManager,
+	outbox *outbox.Dispatcher,
+	clients map[uint64]*w3.Client,
+	domains *domains.Verifier,
+	directories *directory.Publisher,
 ) API {
 	return API{
 		cache:        cache,
@@ -44,6 +62,10 @@ func NewAPI(
 		transformers: transformers,
 		repository:   repository,
 		hooks:        hooks,
+		outbox:       outbox,
+		clients:      clients,
+		domains:      domains,
+		directories:  directories,
 	}
 }
 
@@ -53,9 +75,25 @@ func (a API) Register(g *echo.Group) {
 	ag.GET("/", a.List)
 	ag.PUT("/:chainId/:contract/", a.Update)
 	ag.GET("/:chainId/:contract/", a.Get)
+	ag.GET("/:chainId/:contract/refresh/collection/", a.RefreshCollection)
 	ag.GET("/:chainId/:contract/refresh/:tokenId/", a.Refresh)
+	ag.GET("/:chainId/:contract/hooks/secret/", a.GetHookSecret)
+	ag.POST("/:chainId/:contract/hooks/secret/", a.RotateHookSecret)
+	ag.GET("/:chainId/:contract/hooks/deliveries/", a.ListDeliveries)
+	ag.GET("/:chainId/:contract/hooks/deliveries/:id/", a.GetDelivery)
+	ag.POST("/:chainId/:contract/hooks/deliveries/:id/redeliver/", a.Redeliver)
+	ag.GET("/:chainId/:contract/domains/", a.ListDomains)
+	ag.POST("/:chainId/:contract/domains/", a.AddDomain)
+	ag.POST("/:chainId/:contract/domains/:domain/verify/", a.VerifyDomain)
+	ag.DELETE("/:chainId/:contract/domains/:domain/", a.DeleteDomain)
+	ag.GET("/:chainId/:contract/directory/", a.GetDirectory)
+	ag.POST("/:chainId/:contract/directory/", a.PublishDirectory)
+	ag.GET("/:chainId/:contract/keys/", a.ListKeys)
+	ag.GET("/:chainId/:contract/keys/tokens/", a.ListTokenKeys)
+	ag.POST("/:chainId/:contract/keys/expire/", a.ExpireKeys)
 
 	publicG := g.Group(fmt.Sprintf("/%s/%s", VERSION, PUBLIC_GROUP))
+	publicG.GET("/:chainId/:contract/", a.GetCollection)
 	publicG.GET("/:chainId/:contract/:tokenId/", a.GetMetadata)
 }
 
@@ -72,23 +110,46 @@ func (a API) Create(c echo.Context) error {
 	}
 
 	chainId := fmt.Sprintf("%d", data.ChainID)
-	manifest, _, err := a.getMetadata(a.formatChainContractKey(chainId, normalizedContract))
+
+	err = data.ValidateDeployments(a.transformers)
 	if err != nil {
-		if err != resolver.ErrNotFound && err != resolver.ErrLifetime {
-			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
-		}
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
 	}
 
-	log.Println(manifest)
+	for _, target := range data.Targets() {
+		manifest, _, err := a.getMetadata(a.formatTargetKey(target))
+		if err != nil {
+			if err != resolver.ErrNotFound && err != resolver.ErrLifetime {
+				return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+			}
+		}
 
-	if len(manifest.Owner) > 0 && len(manifest.Contract) > 0 {
-		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Resource Already Exists")))
+		if len(manifest.Owner) > 0 && len(manifest.Contract) > 0 {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Resource Already Exists")))
+		}
 	}
 
 	err = a.transformers.Validate(data.Transformers)
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
 	}
+
+	err = a.hooks.Validate(data.Hooks)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	if data.Collection != nil {
+		err = data.Collection.Validate(a.transformers)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+		}
+	}
+
+	err = data.Config.Validate()
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
 	user, err := a.getUser(c)
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
@@ -96,23 +157,34 @@ func (a API) Create(c echo.Context) error {
 
 	data.Owner = user.Subject
 
-	id, err := a.cache.Push(data)
-	if err != nil {
-		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	// Aliases are public routes, an existing one must not be taken over
+	for _, aliasKey := range a.aliasKeys(data) {
+		_, err := a.resolver.Get(aliasKey)
+		if err == nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Alias already used")))
+		} else if err != resolver.ErrNotFound {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
 	}
 
-	err = a.resolver.Set(a.formatChainContractKey(chainId, normalizedContract), id, 0)
+	id, err := a.cache.Push(data)
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
-	if len(data.Config.Alias) > 0 {
-		aliasKey := a.formatChainContractKey(chainId, data.Config.Alias)
-		_, err := a.resolver.Get(aliasKey)
-		if err != nil && err != resolver.ErrNotFound {
+	for _, target := range data.Targets() {
+		err = a.resolver.Set(a.formatTargetKey(target), id, 0)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+
+		err = a.setMarkers(target, data)
+		if err != nil {
 			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 		}
+	}
 
+	for _, aliasKey := range a.aliasKeys(data) {
 		err = a.resolver.Set(aliasKey, id, 0)
 		if err != nil {
 			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
@@ -124,14 +196,11 @@ func (a API) Create(c echo.Context) error {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
-	mm := repository.ManifestModel{
-		Address: data.Contract,
-		ChainId: data.ChainID,
-		User:    um,
-	}
-	err = a.repository.CreateManifest(c.Request().Context(), mm)
-	if err != nil {
-		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	for _, target := range data.Targets() {
+		err = a.createManifestModel(c.Request().Context(), target, um)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
 	}
 
 	return c.JSON(http.StatusCreated, MetadataResult{Url: fmt.Sprintf("/api/v1alpha/manifest/%s/%s/", chainId, normalizedContract)})
@@ -159,7 +228,7 @@ func (a API) Update(c echo.Context) error {
 		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("ChainId parameter does not match the payload")))
 	}
 
-	manifest, _, err := a.getMetadata(a.formatChainContractKey(chainId, normalizedContract))
+	manifest, previousId, err := a.getMetadata(a.formatChainContractKey(chainId, normalizedContract))
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
@@ -169,16 +238,92 @@ func (a API) Update(c echo.Context) error {
 		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
 	}
 
+	if manifest.ChainID != data.ChainID || strings.ToLower(manifest.Contract) != normalizedContract {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Manifest has to be updated through its primary contract %d/%s", manifest.ChainID, strings.ToLower(manifest.Contract))))
+	}
+
 	err = a.transformers.Validate(data.Transformers)
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
 	}
 
+	err = a.hooks.Validate(data.Hooks)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	if data.Collection != nil {
+		err = data.Collection.Validate(a.transformers)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+		}
+	}
+
+	err = data.Config.Validate()
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	// Frozen results are cached as immutable, so freezing cannot be undone
+	if manifest.Config.Freeze && !data.Config.Freeze {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, ErrFrozen))
+	}
+
+	err = data.ValidateDeployments(a.transformers)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
 	um, err := a.repository.GetByAddress(c.Request().Context(), user.Subject)
 	if err != nil {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
+	if len(data.Config.Alias) > 0 {
+		err := a.validateTier(data, um.TierID)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+		}
+	}
+
+	oldTargets := make(map[Target]bool)
+	for _, target := range manifest.Targets() {
+		oldTargets[target] = true
+	}
+	newTargets := make(map[Target]bool)
+	for _, target := range data.Targets() {
+		newTargets[target] = true
+		if oldTargets[target] {
+			continue
+		}
+
+		_, _, err := a.getMetadata(a.formatTargetKey(target))
+		if err == nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Contract %s on chain %d already has a manifest", target.Contract, target.ChainID)))
+		} else if err != resolver.ErrNotFound && err != resolver.ErrLifetime {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+	}
+
+	oldAliasKeys := make(map[string]bool)
+	for _, aliasKey := range a.aliasKeys(manifest) {
+		oldAliasKeys[aliasKey] = true
+	}
+	newAliasKeys := make(map[string]bool)
+	for _, aliasKey := range a.aliasKeys(data) {
+		newAliasKeys[aliasKey] = true
+		if oldAliasKeys[aliasKey] {
+			continue
+		}
+
+		_, err := a.resolver.Get(aliasKey)
+		if err == nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Failed to update alias - already used")))
+		} else if err != resolver.ErrNotFound {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+	}
+
 	data.Owner = user.Subject
 
 	id, err := a.cache.Push(data)
@@ -186,41 +331,85 @@ func (a API) Update(c echo.Context) error {
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
-	if len(data.Config.Alias) > 0 || data.Config.Alias != manifest.Config.Alias {
-		err := a.validateTier(data, um.TierID)
+	for aliasKey := range newAliasKeys {
+		err = a.resolver.Set(aliasKey, id, 0)
 		if err != nil {
-			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 		}
+	}
 
-		aliasKey := a.formatChainContractKey(chainId, data.Config.Alias)
+	for aliasKey := range oldAliasKeys {
+		if newAliasKeys[aliasKey] {
+			continue
+		}
 
-		if data.Config.Alias != manifest.Config.Alias && len(data.Config.Alias) > 0 {
-			_, err := a.resolver.Get(aliasKey)
-			if err == nil {
-				return c.JSON(utils.NewApiError(http.StatusInternalServerError, fmt.Errorf("Failed to update alias - already used")))
-			} else if err != resolver.ErrNotFound {
-				return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
-			}
+		log.Println("Deleting cache key")
+		err = a.resolver.Delete(aliasKey)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 		}
+	}
 
-		err = a.resolver.Set(aliasKey, id, 0)
+	for target := range newTargets {
+		err = a.resolver.Set(a.formatTargetKey(target), id, 0)
 		if err != nil {
 			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 		}
 
-		if data.Config.Alias != manifest.Config.Alias {
-			log.Println("Deleting cache key")
-			oldKey := a.formatChainContractKey(chainId, manifest.Config.Alias)
-			err = a.resolver.Delete(oldKey)
-			if err != nil {
-				return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
-			}
+		err = a.setMarkers(target, data)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+
+		if oldTargets[target] {
+			continue
+		}
+
+		err = a.createManifestModel(c.Request().Context(), target, um)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 		}
 	}
 
-	err = a.resolver.Set(a.formatChainContractKey(chainId, normalizedContract), id, 0)
-	if err != nil {
-		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	for target := range oldTargets {
+		if newTargets[target] {
+			continue
+		}
+
+		err = a.resolver.Delete(a.formatTargetKey(target))
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+
+		err = a.setMarkers(target, Manifest{})
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+
+		err = a.repository.DeleteManifest(c.Request().Context(), target.ChainID, target.Contract)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+	}
+
+	params := map[string]interface{}{
+		"chainId":     chainId,
+		"contract":    normalizedContract,
+		"manifestCID": id,
+	}
+
+	event := hooks.Event{
+		Type:        hooks.EVENT_MANIFEST_UPDATED,
+		ChainID:     chainId,
+		Contract:    normalizedContract,
+		CID:         id,
+		PreviousCID: previousId,
+	}
+	a.dispatch(data, event, params)
+
+	if data.Config.Freeze && !manifest.Config.Freeze {
+		event.Type = hooks.EVENT_MANIFEST_FROZEN
+		a.dispatch(data, event, params)
 	}
 
 	return c.JSON(http.StatusOK, MetadataResult{Url: fmt.Sprintf("/api/v1alpha/metadata/%s/", normalizedContract)})
@@ -281,10 +470,39 @@ func (a API) List(c echo.Context) error {
 	return c.JSON(http.StatusOK, result)
 }
 
+func (a API) RefreshCollection(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getMetadata(a.formatChainContractKey(chainId, contract))
+	if err != nil {
+		logrus.Errorf("Failed to load manifest: %e", err)
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	result, err := a.generateCollection(c.Request().Context(), chainId, contract)
+	if err != nil {
+		if err == ErrNoCollection {
+			return c.JSON(utils.NewApiError(http.StatusNotFound, err))
+		}
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
 func (a API) Refresh(c echo.Context) error {
 	contract := strings.ToLower(c.Param("contract"))
 	chainId := c.Param("chainId")
-	tokenId := c.Param("tokenId")
+	tokenId, err := ParseTokenID(c.Param("tokenId"))
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
 
 	manifest, _, err := a.getMetadata(a.formatChainContractKey(chainId, contract))
 	if err != nil {
@@ -297,36 +515,206 @@ func (a API) Refresh(c echo.Context) error {
 		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
 	}
 
-	result, err := a.generate(tokenId, chainId, contract)
+	result, err := a.generate(c.Request().Context(), tokenId, chainId, contract)
 	if err != nil {
+		if err == ErrTokenNotFound {
+			return c.JSON(utils.NewApiError(http.StatusNotFound, err))
+		}
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
 	return c.JSON(http.StatusOK, result)
 }
 
-func (a API) GetMetadata(c echo.Context) error {
+// GetHookSecret returns the secret hook payloads are signed with, generating
+// it for manifests created before hooks were signed
+func (a API) GetHookSecret(c echo.Context) error {
 	contract := strings.ToLower(c.Param("contract"))
 	chainId := c.Param("chainId")
-	tokenId := c.Param("tokenId")
 
-	cacheId := fmt.Sprintf("%s/%s", contract, tokenId) //FIXME
-	log.Printf("Trying cache for %s", cacheId)
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
 
-	cacheKey, err := a.resolver.Get(cacheId)
-	if err == nil {
-		log.Printf("Got cache key %s", cacheKey)
-		var data interface{}
-		err = a.cache.Get(cacheKey, &data)
-		if err != nil {
-			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	mm, err := a.repository.GetManifest(c.Request().Context(), manifest.ChainID, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	if len(mm.HookSecret) == 0 {
+		return a.RotateHookSecret(c)
+	}
+
+	return c.JSON(http.StatusOK, HookSecret{Secret: mm.HookSecret})
+}
+
+func (a API) RotateHookSecret(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	secret, err := hooks.NewSecret()
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	err = a.repository.SetHookSecret(c.Request().Context(), manifest.ChainID, contract, secret)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, HookSecret{Secret: secret})
+}
+
+func (a API) ListDeliveries(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+	status := c.QueryParam("status")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	switch status {
+	case "", outbox.STATUS_PENDING, outbox.STATUS_DELIVERING, outbox.STATUS_DELIVERED, outbox.STATUS_DEAD:
+	default:
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Unknown status %s", status)))
+	}
+
+	deliveries, err := a.outbox.List(c.Request().Context(), manifest.ChainID, contract, status)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+func (a API) GetDelivery(c echo.Context) error {
+	delivery, status, err := a.getDelivery(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+func (a API) Redeliver(c echo.Context) error {
+	delivery, status, err := a.getDelivery(c)
+	if err != nil {
+		return c.JSON(utils.NewApiError(status, err))
+	}
+
+	delivery, err = a.outbox.Redeliver(c.Request().Context(), delivery.ID)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusConflict, err))
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// getDelivery loads the delivery from the id parameter and makes sure it
+// belongs to a manifest of the user
+func (a API) getDelivery(c echo.Context) (outbox.Delivery, int, error) {
+	var delivery outbox.Delivery
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return delivery, http.StatusBadRequest, fmt.Errorf("Invalid delivery id")
+	}
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return delivery, http.StatusInternalServerError, err
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return delivery, http.StatusUnauthorized, err
+	}
+
+	delivery, err = a.outbox.Get(c.Request().Context(), uint(id))
+	if err != nil {
+		if err == outbox.ErrNotFound {
+			return delivery, http.StatusNotFound, err
 		}
+		return delivery, http.StatusInternalServerError, err
+	}
+
+	if delivery.ChainID != manifest.ChainID || delivery.Contract != contract {
+		return delivery, http.StatusNotFound, outbox.ErrNotFound
+	}
+
+	return delivery, http.StatusOK, nil
+}
+
+func (a API) GetMetadata(c echo.Context) error {
+	chainId := c.Param("chainId")
+	tokenId, err := ParseTokenID(c.Param("tokenId"))
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	contract, err := a.resolveContract(chainId, strings.ToLower(c.Param("contract")))
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusNotFound, err))
+	}
+
+	return a.serve(c, chainId, contract, a.formatTokenCacheKey(chainId, contract, tokenId.Decimal), func(ctx context.Context) (map[string]interface{}, error) {
+		return a.generate(ctx, tokenId, chainId, contract)
+	})
+}
+
+// GetCollection serves the collection level metadata (contractURI)
+func (a API) GetCollection(c echo.Context) error {
+	chainId := c.Param("chainId")
+
+	contract, err := a.resolveContract(chainId, strings.ToLower(c.Param("contract")))
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusNotFound, err))
+	}
+
+	return a.serve(c, chainId, contract, a.formatCollectionCacheKey(chainId, contract), func(ctx context.Context) (map[string]interface{}, error) {
+		return a.generateCollection(ctx, chainId, contract)
+	})
+}
 
+// serve responds with the cached result under cacheId, or with a freshly
+// generated one when there is none or its lifetime is over. Results of frozen
+// manifests are never regenerated. Cached results are answered with 304 when
+// the client already has them, without loading them.
+func (a API) serve(c echo.Context, chainId string, contract string, cacheId string, generate func(ctx context.Context) (map[string]interface{}, error)) error {
+	log.Printf("Trying cache for %s", cacheId)
+
+	cacheKey, lifetime, err := a.resolver.GetWithLifetime(cacheId)
+	if err == nil {
 		log.Printf("Using cache for %s (%s)", cacheId, cacheKey)
-		return c.JSON(http.StatusOK, data)
+		return a.respond(c, chainId, contract, cacheKey, lifetime)
 	} else {
 		if err == resolver.ErrLifetime {
-			manifest, _, err := a.getMetadata(a.formatChainContractKey(chainId, contract))
+			manifest, _, err := a.getDeployment(chainId, contract)
 			if err != nil {
 				return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 			}
@@ -337,34 +725,81 @@ func (a API) GetMetadata(c echo.Context) error {
 					return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 				}
 
-				var data interface{}
-				err = a.cache.Get(cacheKey, &data)
+				// Manifests frozen before the marker existed
+				err = a.setFrozen(Target{ChainID: manifest.ChainID, Contract: contract}, true)
 				if err != nil {
 					return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 				}
 
 				log.Printf("Frozen: renewing and using cache for %s (%s)", cacheId, cacheKey)
-				return c.JSON(http.StatusOK, data)
+				return a.respond(c, chainId, contract, cacheKey, time.Time{})
 			}
 		} else if err != resolver.ErrNotFound {
 			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
 		}
 	}
 
-	//var result map[string]interface{}
-
 	if contract == "" {
 		return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Contract address parameter empty")))
 	}
 
-	result, err := a.generate(tokenId, chainId, contract)
+	result, err := generate(c.Request().Context())
 	if err != nil {
+		if err == ErrNoCollection || err == ErrTokenNotFound {
+			return c.JSON(utils.NewApiError(http.StatusNotFound, err))
+		}
 		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
 	}
 
+	// Placeholders are not published, so there is nothing to validate
+	// them against
+	cacheKey, lifetime, err = a.resolver.GetWithLifetime(cacheId)
+	if err == nil {
+		a.setCacheHeaders(c, chainId, contract, cacheKey, lifetime)
+
+		gateway, err := a.redirectGateway(chainId, contract)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+		if len(gateway) > 0 {
+			return c.Redirect(http.StatusFound, gateway+cacheKey)
+		}
+	} else {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	}
+	setLastModified(c, result)
+
 	return c.JSON(http.StatusOK, result)
 }
 
+// respond serves the published result with its caching headers, or redirects
+// to it on the gateway in redirect mode
+func (a API) respond(c echo.Context, chainId string, contract string, cacheKey string, lifetime time.Time) error {
+	a.setCacheHeaders(c, chainId, contract, cacheKey, lifetime)
+
+	if etagMatch(c.Request().Header.Get("If-None-Match"), formatETag(cacheKey)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	gateway, err := a.redirectGateway(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+	if len(gateway) > 0 {
+		return c.Redirect(http.StatusFound, gateway+cacheKey)
+	}
+
+	var data interface{}
+	err = a.cache.Get(cacheKey, &data)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	setLastModified(c, data)
+
+	return c.JSON(http.StatusOK, data)
+}
+
 func (a API) getMetadata(manifestKey string) (Manifest, string, error) {
 	var metadata Manifest
 
@@ -404,10 +839,100 @@ func (a API) ensureOwner(c echo.Context, metadata Manifest) (*jwt.StandardClaims
 
 }
 
+// resolveContract returns the contract address for the contract route
+// parameter, which is either the address itself or an alias
+func (a API) resolveContract(chainId string, contractOrAlias string) (string, error) {
+	if common.IsHexAddress(contractOrAlias) {
+		return contractOrAlias, nil
+	}
+
+	manifest, _, err := a.getMetadata(a.formatChainContractKey(chainId, contractOrAlias))
+	if err != nil {
+		return "", fmt.Errorf("%w %s", ErrUnknownContract, contractOrAlias)
+	}
+
+	id, err := strconv.ParseInt(chainId, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid chain id %s", chainId)
+	}
+
+	// The alias points to the manifest, which can target another contract
+	// on this chain than its primary one
+	manifest, err = manifest.For(id)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(manifest.Contract), nil
+}
+
+// getDeployment loads the manifest serving the contract on the chain, with
+// the overrides for that chain applied
+func (a API) getDeployment(chainId string, contract string) (Manifest, string, error) {
+	manifest, manifestCID, err := a.getMetadata(a.formatChainContractKey(chainId, contract))
+	if err != nil {
+		return manifest, "", err
+	}
+
+	id, err := strconv.ParseInt(chainId, 10, 64)
+	if err != nil {
+		return manifest, "", fmt.Errorf("Invalid chain id %s", chainId)
+	}
+
+	manifest, err = manifest.For(id)
+	if err != nil {
+		return manifest, "", err
+	}
+
+	if strings.ToLower(manifest.Contract) != contract {
+		return manifest, "", ErrNoDeployment
+	}
+
+	return manifest, manifestCID, nil
+}
+
+// PINNED_PREFIXES are the resolver keys pointing to manifests and results,
+// objects stay pinned as long as such a key points to them. Frozen results
+// have no prefix of their own, they stay pinned only because the sweep
+// policy keeps their expired token# and collection# keys.
+var PINNED_PREFIXES = []string{"manifest#", "token#", "collection#"}
+
 func (a API) formatChainContractKey(chainId string, contract string) string {
 	return fmt.Sprintf("manifest#%s#%s", chainId, contract)
 }
 
+func (a API) formatTargetKey(target Target) string {
+	return a.formatChainContractKey(strconv.FormatInt(target.ChainID, 10), target.Contract)
+}
+
+// aliasKeys returns the keys of the alias on every chain the manifest targets
+func (a API) aliasKeys(manifest Manifest) []string {
+	if len(manifest.Config.Alias) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0)
+	for _, target := range manifest.Targets() {
+		keys = append(keys, a.formatChainContractKey(strconv.FormatInt(target.ChainID, 10), manifest.Config.Alias))
+	}
+
+	return keys
+}
+
+func (a API) createManifestModel(ctx context.Context, target Target, user repository.UserModel) error {
+	secret, err := hooks.NewSecret()
+	if err != nil {
+		return err
+	}
+
+	return a.repository.CreateManifest(ctx, repository.ManifestModel{
+		Address:    target.Contract,
+		ChainId:    target.ChainID,
+		User:       user,
+		HookSecret: secret,
+	})
+}
+
 func (a API) validateTier(manifest Manifest, tierId uint) error {
 	var errs []string
 
@@ -425,29 +950,153 @@ func (a API) validateTier(manifest Manifest, tierId uint) error {
 	return nil
 }
 
-func (a API) generate(tokenId string, chainId string, contract string) (map[string]interface{}, error) {
-	cacheId := fmt.Sprintf("%s/%s", contract, tokenId)
+// failed dispatches token.failed for the error and returns it
+func (a API) failed(manifest Manifest, event hooks.Event, params map[string]interface{}, err error) error {
+	event.Type = hooks.EVENT_TOKEN_FAILED
+	event.Error = err.Error()
+	a.dispatch(manifest, event, params)
 
-	manifest, manifestCID, err := a.getMetadata(a.formatChainContractKey(chainId, contract))
+	return err
+}
+
+func (a API) generate(ctx context.Context, tokenId TokenID, chainId string, contract string) (map[string]interface{}, error) {
+	cacheId := a.formatTokenCacheKey(chainId, contract, tokenId.Decimal)
+
+	manifest, manifestCID, err := a.getDeployment(chainId, contract)
 	if err != nil {
 		return nil, err
 	}
 
 	params := make(map[string]interface{})
 	params["chainId"] = chainId
-	params["id"] = tokenId
+	params["id"] = tokenId.Decimal
+	params["idHex"] = tokenId.Hex
 	params["contract"] = contract
 	params["manifestCID"] = manifestCID
 
-	log.Println("Credits:", a.transformers.CalculateCredits(manifest.Transformers))
+	if manifest.Config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, manifest.Config.Budget())
+		defer cancel()
+	}
+
+	event := hooks.Event{
+		ChainID:  chainId,
+		Contract: contract,
+		TokenID:  tokenId.Decimal,
+	}
+
+	// The checks call the chain before the transformers get their budget, so
+	// they are bounded even if the manifest has no deadline
+	checkCtx, cancelCheck := context.WithTimeout(ctx, transformers.DEFAULT_DEADLINE)
+	defer cancelCheck()
+
+	exists, err := a.exists(checkCtx, manifest, tokenId)
+	if err != nil {
+		logrus.Errorf("Failed to check the token exists: %s", err)
+		return nil, a.failed(manifest, event, params, err)
+	}
+	if !exists {
+		return nil, ErrTokenNotFound
+	}
 
-	result, err := a.transformers.Execute(manifest.Transformers, params)
+	// Unrevealed metadata is never generated, so it cannot end up cached
+	revealed, err := a.revealed(checkCtx, manifest)
+	if err != nil {
+		logrus.Errorf("Failed to check the collection is revealed: %s", err)
+		return nil, a.failed(manifest, event, params, err)
+	}
+	if !revealed {
+		return a.placeholder(manifest, params)
+	}
+
+	result, err := a.transformers.Execute(ctx, manifest.Transformers, params)
 	if err != nil {
 		logrus.Errorf("Failed while executing transformers: %s", err)
+		return nil, a.failed(manifest, event, params, err)
+	}
+
+	// Credits of the steps which ran, not of every possible branch
+	if info, ok := result[transformers.MANIFEST_INFO].(transformers.ManifestInfo); ok {
+		log.Println("Credits:", info.Credits)
+	}
+
+	result = manifest.Config.Output.rewrite(result)
+
+	// The previous value is returned even if its lifetime is over
+	previousId, _ := a.resolver.Get(cacheId)
+
+	id, result, changes, err := a.publish(cacheId, previousId, result)
+	if err != nil {
 		return nil, err
 	}
 
-	id, err := a.cache.Push(result)
+	err = a.resolver.Set(cacheId, id, manifest.Config.RefreshAfter.ToMinute())
+	if err != nil {
+		return nil, err
+	}
+
+	event.CID = id
+	event.PreviousCID = previousId
+	event.Result = result
+	event.Diff = changes
+
+	event.Type = hooks.EVENT_TOKEN_GENERATED
+	a.dispatch(manifest, event, params)
+
+	if previousId != id {
+		// A first result is generated, not changed
+		if len(previousId) > 0 {
+			event.Type = hooks.EVENT_TOKEN_CHANGED
+			a.dispatch(manifest, event, params)
+		}
+		a.scheduleDirectory(ctx, manifest, chainId, contract)
+	}
+
+	return result, nil
+}
+
+// generateCollection runs the collection transformers on top of the static
+// collection metadata of the manifest
+func (a API) generateCollection(ctx context.Context, chainId string, contract string) (map[string]interface{}, error) {
+	cacheId := a.formatCollectionCacheKey(chainId, contract)
+
+	manifest, manifestCID, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.Collection == nil {
+		return nil, ErrNoCollection
+	}
+
+	params := make(map[string]interface{})
+	params["chainId"] = chainId
+	params["contract"] = contract
+	params["manifestCID"] = manifestCID
+
+	if manifest.Config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, manifest.Config.Budget())
+		defer cancel()
+	}
+
+	base, err := manifest.Collection.Base()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.transformers.ExecuteOn(ctx, manifest.Collection.Transformers, base, params)
+	if err != nil {
+		logrus.Errorf("Failed while executing collection transformers: %s", err)
+		return nil, err
+	}
+
+	result = manifest.Config.Output.rewrite(result)
+
+	previousId, _ := a.resolver.Get(cacheId)
+
+	id, result, _, err := a.publish(cacheId, previousId, result)
 	if err != nil {
 		return nil, err
 	}
@@ -457,27 +1106,105 @@ func (a API) generate(tokenId string, chainId string, contract string) (map[stri
 		return nil, err
 	}
 
-	for _, h := range manifest.Hooks {
-		log.Println("Hooking", h)
-		hook, err := a.hooks.Get(h.Type)
+	return result, nil
+}
+
+func (a API) formatTokenCacheKey(chainId string, contract string, tokenId string) string {
+	return fmt.Sprintf("token#%s#%s#%s", chainId, contract, tokenId)
+}
+
+func (a API) formatCollectionCacheKey(chainId string, contract string) string {
+	return fmt.Sprintf("collection#%s#%s", chainId, contract)
+}
+
+// publish pushes the result to the cache unless its content (everything but
+// the manifest info) is the same as of the previously published result, in
+// which case the previous CID and object are reused. It returns the published
+// object, so responses match the ETag and Last-Modified of the CID. The
+// changes against the previous result are returned when it was published
+// again.
+func (a API) publish(cacheId string, previousId string, result map[string]interface{}) (string, map[string]interface{}, []diff.Change, error) {
+	hash, err := diff.Hash(result, transformers.MANIFEST_INFO)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	hashKey := a.formatContentHashKey(cacheId)
+	previousHash, _ := a.resolver.Get(hashKey)
+
+	if len(previousId) > 0 && previousHash == hash {
+		var stored map[string]interface{}
+		err = a.cache.Get(previousId, &stored)
+		if err == nil {
+			logrus.Debugf("Content of %s unchanged, reusing %s", cacheId, previousId)
+			return previousId, stored, nil, nil
+		}
+		logrus.Warnf("Failed to load unchanged result %s of %s, publishing again: %s", previousId, cacheId, err)
+	}
+
+	id, err := a.cache.Push(result)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	err = a.resolver.Set(hashKey, hash, 0)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	changes := make([]diff.Change, 0)
+	if len(previousId) > 0 {
+		// Raw JSON keeps big numbers intact for the comparison
+		var previous json.RawMessage
+		err = a.cache.Get(previousId, &previous)
 		if err != nil {
-			log.Println("Hook", h.Type, " failed", err)
-			break
+			logrus.Warnf("Failed to load previous result %s of %s: %s", previousId, cacheId, err)
+			return id, result, changes, nil
 		}
 
-		hook, err = hook.WithSpec(h.Spec, params)
+		old, err := diff.Normalize(previous, transformers.MANIFEST_INFO)
 		if err != nil {
-			log.Println("Hook", h.Type, " failed", err)
-			break
+			return "", nil, nil, err
 		}
 
-		go func() {
-			err = hook.Execute()
-			if err != nil {
-				logrus.Errorf("Hook %s failed: %s", h.Type, err)
-			}
-		}()
+		new, err := diff.Normalize(result, transformers.MANIFEST_INFO)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		changes = diff.Compare(old, new)
 	}
 
-	return result, nil
+	return id, result, changes, nil
+}
+
+func (a API) formatContentHashKey(cacheId string) string {
+	return fmt.Sprintf("hash#%s", cacheId)
+}
+
+// dispatch stores a delivery for every hook of the manifest subscribed to the
+// event in the outbox, the dispatcher takes care of sending them
+func (a API) dispatch(manifest Manifest, event hooks.Event, params map[string]interface{}) {
+	for _, h := range a.hooks.Subscribers(manifest.Hooks, event.Type) {
+		payload, err := hooks.NewPayload(event)
+		if err != nil {
+			logrus.Errorf("Failed to create hook payload: %s", err)
+			continue
+		}
+
+		delivery, err := a.outbox.Enqueue(context.Background(), outbox.Delivery{
+			ChainID:  manifest.ChainID,
+			Contract: strings.ToLower(manifest.Contract),
+			Type:     h.Type,
+			Spec:     h.Spec,
+			Params:   params,
+			Payload:  payload,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to enqueue hook %s for %s: %s", h.Type, event.Type, err)
+			continue
+		}
+
+		logrus.Debugf("Enqueued hook %s for %s as delivery %d", h.Type, event.Type, delivery.ID)
+	}
 }
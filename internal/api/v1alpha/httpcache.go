@@ -0,0 +1,124 @@
+package v1alpha
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/transformers"
+)
+
+// IMMUTABLE_MAX_AGE is the max-age of frozen results (one year)
+const IMMUTABLE_MAX_AGE = 365 * 24 * 60 * 60
+
+// setCacheHeaders sets the ETag from the CID of the result and the
+// Cache-Control from its remaining lifetime. Frozen results never change, as
+// Update refuses to unfreeze a manifest.
+func (a API) setCacheHeaders(c echo.Context, chainId string, contract string, cid string, lifetime time.Time) {
+	header := c.Response().Header()
+	header.Set(echo.HeaderCacheControl, a.cacheControl(chainId, contract, lifetime))
+	header.Set("ETag", formatETag(cid))
+}
+
+func (a API) cacheControl(chainId string, contract string, lifetime time.Time) string {
+	if a.frozen(chainId, contract) {
+		return fmt.Sprintf("public, max-age=%d, immutable", IMMUTABLE_MAX_AGE)
+	}
+
+	// Results without lifetime only change with the manifest, so they are
+	// revalidated every time
+	if lifetime.IsZero() {
+		return "public, no-cache"
+	}
+
+	remaining := int64(time.Until(lifetime).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return fmt.Sprintf("public, max-age=%d", remaining)
+}
+
+// setLastModified sets Last-Modified from the generatedAt of the manifest info
+// of the result, if any
+func setLastModified(c echo.Context, result interface{}) {
+	data, ok := result.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	var generatedAt time.Time
+	switch info := data[transformers.MANIFEST_INFO].(type) {
+	case transformers.ManifestInfo:
+		generatedAt = info.GeneratedAt
+	case map[string]interface{}:
+		value, ok := info["generatedAt"].(string)
+		if !ok {
+			return
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return
+		}
+		generatedAt = t
+	default:
+		return
+	}
+
+	c.Response().Header().Set(echo.HeaderLastModified, generatedAt.UTC().Format(http.TimeFormat))
+}
+
+func formatETag(cid string) string {
+	return strconv.Quote(cid)
+}
+
+// etagMatch checks the If-None-Match header against the ETag, weak
+// comparison as in RFC 7232
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// frozen checks the freeze marker of the contract, which is kept in the
+// resolver so requests can be answered without loading the manifest
+func (a API) frozen(chainId string, contract string) bool {
+	_, err := a.resolver.Get(a.formatFrozenKey(chainId, contract))
+	return err == nil
+}
+
+func (a API) setFrozen(target Target, frozen bool) error {
+	key := a.formatFrozenKey(strconv.FormatInt(target.ChainID, 10), target.Contract)
+	if frozen {
+		return a.resolver.Set(key, "true", 0)
+	}
+
+	_, err := a.resolver.Get(key)
+	if err == resolver.ErrNotFound {
+		return nil
+	}
+
+	return a.resolver.Delete(key)
+}
+
+func (a API) formatFrozenKey(chainId string, contract string) string {
+	return fmt.Sprintf("frozen#%s#%s", chainId, contract)
+}
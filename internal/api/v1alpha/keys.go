@@ -0,0 +1,232 @@
+package v1alpha
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/utils"
+)
+
+const (
+	DEFAULT_KEYS_LIMIT = 100
+	MAX_KEYS_LIMIT     = 1000
+)
+
+// RESULT_PREFIXES are the resolver keys of generated results, expiring them
+// forces the results to be generated again on the next request
+var RESULT_PREFIXES = []string{"token#", "collection#"}
+
+// NAMESPACES are the key prefixes used by the server
+var NAMESPACES = []string{"manifest#", "token#", "collection#", "hash#", "image#", "revealed#", "frozen#", "redirect#", "directory#"}
+
+// ListKeys returns the resolver entries of the collection on the chain
+// together with the counts of the token results
+func (a API) ListKeys(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	keys, err := a.keys(manifest, chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// ListTokenKeys pages through the token results of the collection on the
+// chain, ordered by key
+func (a API) ListTokenKeys(c echo.Context) error {
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	limit := DEFAULT_KEYS_LIMIT
+	if raw := c.QueryParam("limit"); len(raw) > 0 {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MAX_KEYS_LIMIT {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, fmt.Errorf("Limit has to be between 1 and %d", MAX_KEYS_LIMIT)))
+		}
+	}
+
+	entries, err := a.resolver.List(resolver.Query{
+		Prefix: a.formatTokenCacheKey(chainId, contract, ""),
+		After:  c.QueryParam("after"),
+		Limit:  limit,
+	})
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	page := KeyPage{Keys: entries}
+	if len(entries) == limit {
+		page.Next = entries[len(entries)-1].Key
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// ExpireKeys ends the lifetime of token results, so they are generated again
+// on the next request. Results of frozen collections are renewed instead.
+func (a API) ExpireKeys(c echo.Context) error {
+	var data ExpireRequest
+	err := c.Bind(&data)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+	}
+
+	contract := strings.ToLower(c.Param("contract"))
+	chainId := c.Param("chainId")
+
+	manifest, _, err := a.getDeployment(chainId, contract)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	_, err = a.ensureOwner(c, manifest)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusUnauthorized, err))
+	}
+
+	var result ExpireResult
+	if len(data.Tokens) == 0 {
+		result.Expired, err = a.expireResults(chainId, contract)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+		}
+
+		return c.JSON(http.StatusOK, result)
+	}
+
+	keys := make([]string, 0, len(data.Tokens))
+	for _, raw := range data.Tokens {
+		tokenId, err := ParseTokenID(raw)
+		if err != nil {
+			return c.JSON(utils.NewApiError(http.StatusBadRequest, err))
+		}
+
+		keys = append(keys, a.formatTokenCacheKey(chainId, contract, tokenId.Decimal))
+	}
+
+	result.Expired, err = a.resolver.Expire(keys)
+	if err != nil {
+		return c.JSON(utils.NewApiError(http.StatusInternalServerError, err))
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// keys returns the resolver entries of the collection on the chain and the
+// counts of the token results
+func (a API) keys(manifest Manifest, chainId string, contract string) (CollectionKeys, error) {
+	keys := CollectionKeys{Keys: make([]resolver.Entry, 0)}
+	for _, key := range a.collectionKeys(manifest, chainId, contract) {
+		value, lifetime, err := a.resolver.GetWithLifetime(key)
+		if err == resolver.ErrNotFound {
+			continue
+		} else if err != nil && err != resolver.ErrLifetime {
+			return keys, err
+		}
+
+		keys.Keys = append(keys.Keys, resolver.Entry{Key: key, Value: value, Lifetime: lifetime})
+	}
+
+	tokenPrefix := a.formatTokenCacheKey(chainId, contract, "")
+
+	var err error
+	keys.Tokens, err = a.resolver.Count(tokenPrefix)
+	if err != nil {
+		return keys, err
+	}
+
+	keys.Hashes, err = a.resolver.Count(a.formatContentHashKey(tokenPrefix))
+	if err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// expireResults expires the results of every token and of the collection
+func (a API) expireResults(chainId string, contract string) (int64, error) {
+	expired, err := resolver.ExpirePrefix(a.resolver, a.formatTokenCacheKey(chainId, contract, ""))
+	if err != nil {
+		return expired, err
+	}
+
+	collection, err := a.resolver.Expire([]string{a.formatCollectionCacheKey(chainId, contract)})
+
+	return expired + collection, err
+}
+
+// collectionKeys returns the keys of the collection on the chain besides the
+// token results and their content hashes
+func (a API) collectionKeys(manifest Manifest, chainId string, contract string) []string {
+	keys := []string{a.formatChainContractKey(chainId, contract)}
+	if len(manifest.Config.Alias) > 0 {
+		keys = append(keys, a.formatChainContractKey(chainId, manifest.Config.Alias))
+	}
+
+	collectionKey := a.formatCollectionCacheKey(chainId, contract)
+
+	return append(keys,
+		collectionKey,
+		a.formatContentHashKey(collectionKey),
+		a.formatRevealedKey(manifest),
+		a.formatFrozenKey(chainId, contract),
+		a.formatRedirectKey(chainId, contract),
+		a.formatDirectoryKey(chainId, contract),
+	)
+}
+
+// NewSweepPolicy keeps the expired results of frozen collections, which are
+// renewed instead of generated again, and sweeps the content hashes along
+// with the results. Keeping the keys is also what keeps the frozen objects
+// pinned, see PINNED_PREFIXES.
+func NewSweepPolicy(r resolver.IResolver) resolver.SweepPolicy {
+	return sweepPolicy{a: API{resolver: r}}
+}
+
+type sweepPolicy struct {
+	a API
+}
+
+func (p sweepPolicy) Keep(key string) bool {
+	parts := strings.Split(key, "#")
+	if len(parts) < 3 {
+		return false
+	}
+
+	switch parts[0] {
+	case "token", "collection":
+		return p.a.frozen(parts[1], parts[2])
+	}
+
+	return false
+}
+
+func (p sweepPolicy) Related(key string) []string {
+	return []string{p.a.formatContentHashKey(key)}
+}
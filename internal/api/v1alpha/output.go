@@ -0,0 +1,139 @@
+package v1alpha
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/metaconflux/backend/internal/resolver"
+)
+
+const (
+	OUTPUT_MODE_JSON     = "json"
+	OUTPUT_MODE_REDIRECT = "redirect"
+
+	DEFAULT_IPFS_GATEWAY    = "https://ipfs.io/ipfs/"
+	DEFAULT_ARWEAVE_GATEWAY = "https://arweave.net/"
+)
+
+// Output configures how results are served. Rewrite replaces ipfs:// and ar://
+// URIs in the results with gateway URLs, the redirect mode answers with a
+// redirect to the gateway URL of the published result instead of its body.
+type Output struct {
+	Mode           string `json:"mode,omitempty"`
+	Rewrite        bool   `json:"rewrite,omitempty"`
+	Gateway        string `json:"gateway,omitempty"`
+	ArweaveGateway string `json:"arweaveGateway,omitempty"`
+}
+
+func (o Output) Validate() error {
+	switch o.Mode {
+	case "", OUTPUT_MODE_JSON, OUTPUT_MODE_REDIRECT:
+	default:
+		return fmt.Errorf("Unknown output mode %s", o.Mode)
+	}
+
+	for _, gateway := range []string{o.Gateway, o.ArweaveGateway} {
+		if len(gateway) == 0 {
+			continue
+		}
+
+		u, err := url.Parse(gateway)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+			return fmt.Errorf("Invalid gateway %s", gateway)
+		}
+	}
+
+	return nil
+}
+
+// IPFSGateway returns the gateway prefix, always ending with a slash
+func (o Output) IPFSGateway() string {
+	if len(o.Gateway) == 0 {
+		return DEFAULT_IPFS_GATEWAY
+	}
+
+	return strings.TrimSuffix(o.Gateway, "/") + "/"
+}
+
+func (o Output) ArGateway() string {
+	if len(o.ArweaveGateway) == 0 {
+		return DEFAULT_ARWEAVE_GATEWAY
+	}
+
+	return strings.TrimSuffix(o.ArweaveGateway, "/") + "/"
+}
+
+// rewrite replaces the ipfs:// and ar:// URIs in the result, if enabled
+func (o *Output) rewrite(result map[string]interface{}) map[string]interface{} {
+	if o == nil || !o.Rewrite {
+		return result
+	}
+
+	return rewriteURIs(result, o.IPFSGateway(), o.ArGateway()).(map[string]interface{})
+}
+
+func rewriteURIs(v interface{}, ipfsGateway string, arGateway string) interface{} {
+	switch val := v.(type) {
+	case string:
+		if strings.HasPrefix(val, "ipfs://") {
+			// ipfs://ipfs/<cid> is a common mistake
+			return ipfsGateway + strings.TrimPrefix(strings.TrimPrefix(val, "ipfs://"), "ipfs/")
+		}
+		if strings.HasPrefix(val, "ar://") {
+			return arGateway + strings.TrimPrefix(val, "ar://")
+		}
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			result[k] = rewriteURIs(item, ipfsGateway, arGateway)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for i, item := range val {
+			result[i] = rewriteURIs(item, ipfsGateway, arGateway)
+		}
+		return result
+	}
+
+	return v
+}
+
+// redirectGateway returns the gateway to redirect to when the contract is
+// served in redirect mode, empty otherwise
+func (a API) redirectGateway(chainId string, contract string) (string, error) {
+	gateway, err := a.resolver.Get(a.formatRedirectKey(chainId, contract))
+	if err == resolver.ErrNotFound {
+		return "", nil
+	}
+
+	return gateway, err
+}
+
+// setMarkers keeps the settings needed to answer requests without loading the
+// manifest in the resolver
+func (a API) setMarkers(target Target, manifest Manifest) error {
+	err := a.setFrozen(target, manifest.Config.Freeze)
+	if err != nil {
+		return err
+	}
+
+	key := a.formatRedirectKey(strconv.FormatInt(target.ChainID, 10), target.Contract)
+	output := manifest.Config.Output
+	if output != nil && output.Mode == OUTPUT_MODE_REDIRECT {
+		return a.resolver.Set(key, output.IPFSGateway(), 0)
+	}
+
+	_, err = a.resolver.Get(key)
+	if err == resolver.ErrNotFound {
+		return nil
+	}
+
+	return a.resolver.Delete(key)
+}
+
+func (a API) formatRedirectKey(chainId string, contract string) string {
+	return fmt.Sprintf("redirect#%s#%s", chainId, contract)
+}
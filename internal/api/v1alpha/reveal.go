@@ -0,0 +1,189 @@
+package v1alpha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lmittmann/w3"
+	"github.com/lmittmann/w3/module/eth"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/utils"
+)
+
+var ErrTokenNotFound = fmt.Errorf("Token does not exist")
+
+// exists runs the existence check of the manifest, if any
+func (a API) exists(ctx context.Context, manifest Manifest, tokenId TokenID) (bool, error) {
+	check := manifest.Config.Exists
+	if check == nil {
+		return true, nil
+	}
+
+	client, err := a.client(manifest)
+	if err != nil {
+		return false, err
+	}
+
+	id, _ := new(big.Int).SetString(tokenId.Decimal, 10)
+	contract := common.HexToAddress(manifest.Contract)
+
+	switch check.Check {
+	case EXISTS_OWNER_OF:
+		var owner common.Address
+		err = client.CallCtx(ctx, eth.CallFunc(w3.MustNewFunc("ownerOf(uint256)", "address"), contract, id).Returns(&owner))
+		if isRevert(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return owner != common.Address{}, nil
+
+	case EXISTS_TOTAL_SUPPLY:
+		supply := new(big.Int)
+		err = client.CallCtx(ctx, eth.CallFunc(w3.MustNewFunc("totalSupply()", "uint256"), contract).Returns(supply))
+		if err != nil {
+			return false, err
+		}
+		offset := big.NewInt(check.Offset)
+		return id.Cmp(offset) >= 0 && id.Cmp(new(big.Int).Add(supply, offset)) < 0, nil
+
+	case EXISTS_CALL:
+		fn, err := w3.NewFunc(check.Function, "bool")
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		err = client.CallCtx(ctx, eth.CallFunc(fn, contract, id).Returns(&ok))
+		if isRevert(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return ok, nil
+	}
+
+	return false, fmt.Errorf("Unknown existence check %s", check.Check)
+}
+
+// revealed checks the reveal policy of the manifest. A positive on-chain
+// result is remembered, collections do not get unrevealed.
+func (a API) revealed(ctx context.Context, manifest Manifest) (bool, error) {
+	reveal := manifest.Config.Reveal
+	if reveal == nil {
+		return true, nil
+	}
+
+	if reveal.At != nil {
+		return !time.Now().Before(*reveal.At), nil
+	}
+
+	key := a.formatRevealedKey(manifest)
+	_, err := a.resolver.Get(key)
+	if err == nil {
+		return true, nil
+	}
+	if err != resolver.ErrNotFound {
+		return false, err
+	}
+
+	client, err := a.client(manifest)
+	if err != nil {
+		return false, err
+	}
+
+	revealed := false
+	if reveal.Block > 0 {
+		block := new(big.Int)
+		err = client.CallCtx(ctx, eth.BlockNumber().Returns(block))
+		if err != nil {
+			return false, err
+		}
+		revealed = block.Cmp(new(big.Int).SetUint64(reveal.Block)) >= 0
+	} else {
+		fn, err := w3.NewFunc(reveal.Function, "bool")
+		if err != nil {
+			return false, err
+		}
+		err = client.CallCtx(ctx, eth.CallFunc(fn, common.HexToAddress(manifest.Contract)).Returns(&revealed))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if revealed {
+		err = a.resolver.Set(key, "true", 0)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return revealed, nil
+}
+
+// placeholder returns the placeholder metadata with its strings templated
+func (a API) placeholder(manifest Manifest, params map[string]interface{}) (map[string]interface{}, error) {
+	result, err := templateValues(manifest.Config.Reveal.Placeholder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest.Config.Output.rewrite(result.(map[string]interface{})), nil
+}
+
+func templateValues(v interface{}, params map[string]interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return utils.Template(val, params)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			templated, err := templateValues(item, params)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = templated
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for i, item := range val {
+			templated, err := templateValues(item, params)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = templated
+		}
+		return result, nil
+	}
+
+	return v, nil
+}
+
+func (a API) client(manifest Manifest) (*w3.Client, error) {
+	client, ok := a.clients[uint64(manifest.ChainID)]
+	if !ok {
+		return nil, fmt.Errorf("Chain %d is not supported", manifest.ChainID)
+	}
+
+	return client, nil
+}
+
+func (a API) formatRevealedKey(manifest Manifest) string {
+	return fmt.Sprintf("revealed#%s#%s", strconv.FormatInt(manifest.ChainID, 10), strings.ToLower(manifest.Contract))
+}
+
+func isRevert(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return errors.Is(err, w3.ErrEvmRevert) || strings.Contains(strings.ToLower(err.Error()), "revert")
+}
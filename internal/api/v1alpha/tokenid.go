@@ -0,0 +1,52 @@
+package v1alpha
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// maxTokenId is the largest uint256
+var maxTokenId = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// TokenID holds the forms a token id is exposed in to templates
+type TokenID struct {
+	// Decimal is the canonical form used for caching
+	Decimal string
+	// Hex is the 64 character lowercase hex form ERC-1155 clients substitute
+	// for {id}
+	Hex string
+}
+
+// ParseTokenID accepts decimal, 0x prefixed hex and 64 character hex ids
+// (the ERC-1155 {id} form), each optionally followed by .json
+func ParseTokenID(raw string) (TokenID, error) {
+	var result TokenID
+
+	s := strings.TrimSuffix(raw, ".json")
+
+	var base int
+	switch {
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		s = s[2:]
+		base = 16
+	case len(s) == 64:
+		base = 16
+	default:
+		base = 10
+	}
+
+	if len(s) == 0 {
+		return result, fmt.Errorf("Invalid token id %s", raw)
+	}
+
+	id, ok := new(big.Int).SetString(s, base)
+	if !ok || id.Sign() < 0 || id.Cmp(maxTokenId) > 0 {
+		return result, fmt.Errorf("Invalid token id %s", raw)
+	}
+
+	result.Decimal = id.String()
+	result.Hex = fmt.Sprintf("%064x", id)
+
+	return result, nil
+}
@@ -1,7 +1,16 @@
 package v1alpha
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lmittmann/w3"
+	"github.com/metaconflux/backend/internal/api/users/repository"
 	"github.com/metaconflux/backend/internal/hooks"
+	"github.com/metaconflux/backend/internal/resolver"
 	"github.com/metaconflux/backend/internal/transformers"
 	"github.com/metaconflux/backend/internal/utils"
 )
@@ -14,16 +23,351 @@ type Manifest struct {
 	Transformers []transformers.BaseTransformer `json:"transformers"`
 	Config       Config                         `json:"config"`
 	Hooks        []hooks.Hook                   `json:"hooks"`
+	Collection   *Collection                    `json:"collection,omitempty"`
+	Deployments  []Deployment                   `json:"deployments,omitempty"`
 }
 
 func (m Manifest) ValidVersion(version string) bool {
 	return m.Version == version
 }
 
+var ErrNoDeployment = fmt.Errorf("Manifest has no deployment on the chain")
+var ErrUnknownContract = fmt.Errorf("Unknown contract or alias")
+var ErrFrozen = fmt.Errorf("Frozen manifests cannot be unfrozen")
+
+// Deployment is an additional (chainId, contract) pair served by the manifest,
+// e.g. the same CREATE2 address on another chain. The fields which are set
+// override the ones of the manifest on that chain.
+type Deployment struct {
+	ChainID      int64                          `json:"chainId"`
+	Contract     string                         `json:"contract"`
+	Transformers []transformers.BaseTransformer `json:"transformers,omitempty"`
+	Collection   *Collection                    `json:"collection,omitempty"`
+	Exists       *Existence                     `json:"exists,omitempty"`
+	Reveal       *Reveal                        `json:"reveal,omitempty"`
+}
+
+type Target struct {
+	ChainID  int64
+	Contract string
+}
+
+// Targets returns every (chainId, contract) pair served by the manifest, the
+// primary one first. Contracts are lowercased.
+func (m Manifest) Targets() []Target {
+	targets := []Target{{ChainID: m.ChainID, Contract: strings.ToLower(m.Contract)}}
+	for _, d := range m.Deployments {
+		targets = append(targets, Target{ChainID: d.ChainID, Contract: strings.ToLower(d.Contract)})
+	}
+
+	return targets
+}
+
+// For returns the manifest as served on the chain, with the overrides of the
+// deployment applied
+func (m Manifest) For(chainId int64) (Manifest, error) {
+	if chainId == m.ChainID {
+		return m, nil
+	}
+
+	for _, d := range m.Deployments {
+		if d.ChainID != chainId {
+			continue
+		}
+
+		result := m
+		result.ChainID = d.ChainID
+		result.Contract = d.Contract
+		result.Deployments = nil
+
+		if d.Transformers != nil {
+			result.Transformers = d.Transformers
+		}
+		if d.Collection != nil {
+			result.Collection = d.Collection
+		}
+		if d.Exists != nil {
+			result.Config.Exists = d.Exists
+		}
+		if d.Reveal != nil {
+			result.Config.Reveal = d.Reveal
+		}
+
+		return result, nil
+	}
+
+	return Manifest{}, ErrNoDeployment
+}
+
+// ValidateDeployments makes sure every chain is targeted only once, so aliases
+// and overrides are unambiguous
+func (m Manifest) ValidateDeployments(t *transformers.Transformers) error {
+	chains := map[int64]bool{m.ChainID: true}
+	for _, d := range m.Deployments {
+		if d.ChainID <= 0 {
+			return fmt.Errorf("Invalid deployment chain id %d", d.ChainID)
+		}
+
+		if !common.IsHexAddress(d.Contract) {
+			return fmt.Errorf("Invalid deployment contract %s", d.Contract)
+		}
+
+		if chains[d.ChainID] {
+			return fmt.Errorf("Chain %d is targeted more than once", d.ChainID)
+		}
+		chains[d.ChainID] = true
+
+		if d.Transformers != nil {
+			err := t.Validate(d.Transformers)
+			if err != nil {
+				return err
+			}
+		}
+
+		if d.Collection != nil {
+			err := d.Collection.Validate(t)
+			if err != nil {
+				return err
+			}
+		}
+
+		if d.Exists != nil {
+			err := d.Exists.Validate()
+			if err != nil {
+				return err
+			}
+		}
+
+		if d.Reveal != nil {
+			err := d.Reveal.Validate()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var ErrNoCollection = fmt.Errorf("Manifest has no collection metadata")
+
+// MAX_FEE_BASIS_POINTS is 100%
+const MAX_FEE_BASIS_POINTS = 10000
+
+// Collection describes the collection level metadata served as contractURI.
+// The transformers run on top of the static OpenSea style fields.
+type Collection struct {
+	Name                 string                         `json:"name,omitempty"`
+	Description          string                         `json:"description,omitempty"`
+	Image                string                         `json:"image,omitempty"`
+	BannerImage          string                         `json:"banner_image,omitempty"`
+	FeaturedImage        string                         `json:"featured_image,omitempty"`
+	ExternalLink         string                         `json:"external_link,omitempty"`
+	SellerFeeBasisPoints int                            `json:"seller_fee_basis_points,omitempty"`
+	FeeRecipient         string                         `json:"fee_recipient,omitempty"`
+	Collaborators        []string                       `json:"collaborators,omitempty"`
+	Transformers         []transformers.BaseTransformer `json:"transformers,omitempty"`
+}
+
+// Base returns the static fields as the base the transformers run on
+func (c Collection) Base() (map[string]interface{}, error) {
+	static := c
+	static.Transformers = nil
+
+	var base map[string]interface{}
+	err := utils.Remarshal(static, &base)
+	if err != nil {
+		return nil, err
+	}
+
+	return base, nil
+}
+
+func (c Collection) Validate(t *transformers.Transformers) error {
+	if c.SellerFeeBasisPoints < 0 || c.SellerFeeBasisPoints > MAX_FEE_BASIS_POINTS {
+		return fmt.Errorf("Seller fee basis points have to be between 0 and %d", MAX_FEE_BASIS_POINTS)
+	}
+
+	if c.SellerFeeBasisPoints > 0 && !common.IsHexAddress(c.FeeRecipient) {
+		return fmt.Errorf("Fee recipient has to be an address when a seller fee is set")
+	}
+
+	for _, collaborator := range c.Collaborators {
+		if !common.IsHexAddress(collaborator) {
+			return fmt.Errorf("Invalid collaborator address %s", collaborator)
+		}
+	}
+
+	return t.Validate(c.Transformers)
+}
+
 type Config struct {
 	Freeze       bool           `json:"freeze"`
 	RefreshAfter utils.Duration `json:"refreshAfter"`
 	Alias        string         `json:"alias"`
+	Deadline     utils.Duration `json:"deadline,omitempty"`
+	Exists       *Existence     `json:"exists,omitempty"`
+	Reveal       *Reveal        `json:"reveal,omitempty"`
+	Output       *Output        `json:"output,omitempty"`
+	Directory    *Directory     `json:"directory,omitempty"`
+}
+
+// Budget returns the configured deadline clamped to transformers.MAX_DEADLINE,
+// manifests stored before the limit was introduced may exceed it
+func (c Config) Budget() time.Duration {
+	if time.Duration(c.Deadline) > transformers.MAX_DEADLINE {
+		return transformers.MAX_DEADLINE
+	}
+
+	return time.Duration(c.Deadline)
+}
+
+var aliasRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+func (c Config) Validate() error {
+	if c.Deadline < 0 || time.Duration(c.Deadline) > transformers.MAX_DEADLINE {
+		return fmt.Errorf("Deadline has to be between 0 and %s", transformers.MAX_DEADLINE)
+	}
+
+	// Aliases share the public route with contract addresses
+	if len(c.Alias) > 0 && (!aliasRe.MatchString(c.Alias) || common.IsHexAddress(c.Alias)) {
+		return fmt.Errorf("Alias has to be 2-63 lowercase letters, digits or dashes and cannot be an address")
+	}
+
+	if c.Exists != nil {
+		err := c.Exists.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Reveal != nil {
+		err := c.Reveal.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Output != nil {
+		err := c.Output.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Directory != nil {
+		err := c.Directory.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	EXISTS_OWNER_OF     = "ownerOf"
+	EXISTS_TOTAL_SUPPLY = "totalSupply"
+	EXISTS_CALL         = "call"
+)
+
+// Existence declares how to check a token was minted before its metadata is
+// generated
+type Existence struct {
+	Check string `json:"check"`
+	// Offset is the first token id for totalSupply checks
+	Offset int64 `json:"offset,omitempty"`
+	// Function is used by call checks, it takes the token id and returns a
+	// bool, e.g. exists(uint256)
+	Function string `json:"function,omitempty"`
+}
+
+func (e Existence) Validate() error {
+	switch e.Check {
+	case EXISTS_OWNER_OF, EXISTS_TOTAL_SUPPLY:
+	case EXISTS_CALL:
+		_, err := w3.NewFunc(e.Function, "bool")
+		if err != nil {
+			return fmt.Errorf("Invalid existence check function %s: %s", e.Function, err)
+		}
+	default:
+		return fmt.Errorf("Unknown existence check %s", e.Check)
+	}
+
+	if e.Offset < 0 {
+		return fmt.Errorf("Existence check offset cannot be negative")
+	}
+
+	return nil
+}
+
+// Reveal serves the placeholder until the collection is revealed, either at a
+// time, at a block or once the function (e.g. revealed()) returns true
+type Reveal struct {
+	At          *time.Time             `json:"at,omitempty"`
+	Block       uint64                 `json:"block,omitempty"`
+	Function    string                 `json:"function,omitempty"`
+	Placeholder map[string]interface{} `json:"placeholder"`
+}
+
+func (r Reveal) Validate() error {
+	conditions := 0
+	if r.At != nil {
+		conditions++
+	}
+	if r.Block > 0 {
+		conditions++
+	}
+	if len(r.Function) > 0 {
+		_, err := w3.NewFunc(r.Function, "bool")
+		if err != nil {
+			return fmt.Errorf("Invalid reveal function %s: %s", r.Function, err)
+		}
+		conditions++
+	}
+
+	if conditions != 1 {
+		return fmt.Errorf("Reveal needs exactly one of at, block and function")
+	}
+
+	if len(r.Placeholder) == 0 {
+		return fmt.Errorf("Reveal needs placeholder metadata")
+	}
+
+	return nil
+}
+
+// MAX_DIRECTORY_TOKENS limits the tokens rendered by a directory job
+const MAX_DIRECTORY_TOKENS = 10000
+
+// Directory is the inclusive token id range published as an IPFS directory,
+// so the collection can be served from ipfs://<cid>/<id> as well
+type Directory struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+func (d Directory) Validate() error {
+	if d.End < d.Start {
+		return fmt.Errorf("Directory end has to be greater or equal to its start")
+	}
+
+	if d.End-d.Start >= MAX_DIRECTORY_TOKENS {
+		return fmt.Errorf("Directory cannot hold more than %d tokens", MAX_DIRECTORY_TOKENS)
+	}
+
+	return nil
+}
+
+type DirectoryStatus struct {
+	Status     string     `json:"status"`
+	CID        string     `json:"cid,omitempty"`
+	IPNS       string     `json:"ipns,omitempty"`
+	Tokens     int        `json:"tokens"`
+	Skipped    int        `json:"skipped"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
 }
 
 type DynamicItem struct {
@@ -36,6 +380,67 @@ type MetadataResult struct {
 	Url string `json:"url"`
 }
 
+type HookSecret struct {
+	Secret string `json:"secret"`
+}
+
+type DomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// Domain is a custom domain of a collection together with the values needed
+// to verify it
+type Domain struct {
+	Domain       string     `json:"domain"`
+	ChainId      int64      `json:"chainId"`
+	Contract     string     `json:"contract"`
+	Verified     bool       `json:"verified"`
+	Method       string     `json:"method,omitempty"`
+	VerifiedAt   *time.Time `json:"verifiedAt,omitempty"`
+	Token        string     `json:"token"`
+	TxtRecord    string     `json:"txtRecord"`
+	TxtValue     string     `json:"txtValue"`
+	WellKnownUrl string     `json:"wellKnownUrl"`
+}
+
+// CollectionKeys are the resolver entries of a collection on a chain, the
+// token results are only counted as there can be many
+type CollectionKeys struct {
+	Keys   []resolver.Entry `json:"keys"`
+	Tokens resolver.Stats   `json:"tokens"`
+	Hashes resolver.Stats   `json:"hashes"`
+}
+
+type KeyPage struct {
+	Keys []resolver.Entry `json:"keys"`
+	// Next is passed as after to get the next page
+	Next string `json:"next,omitempty"`
+}
+
+type ExpireRequest struct {
+	// Tokens to expire, all tokens and the collection when empty
+	Tokens []string `json:"tokens"`
+}
+
+type ExpireResult struct {
+	Expired int64 `json:"expired"`
+}
+
+// Inspection is a manifest as operators see it, with its owner and its
+// resolver entries
+type Inspection struct {
+	Manifest Manifest              `json:"manifest"`
+	CID      string                `json:"cid"`
+	Owner    *repository.UserModel `json:"owner,omitempty"`
+	Keys     CollectionKeys        `json:"keys"`
+}
+
+type RefreshResult struct {
+	Expired int64 `json:"expired"`
+	// Collection is the regenerated collection metadata, if there is any
+	Collection map[string]interface{} `json:"collection,omitempty"`
+}
+
 type ManifestList struct {
 	Address string `json:"address"`
 	ChainId int64  `json:"chainId"`
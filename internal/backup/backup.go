@@ -0,0 +1,302 @@
+package backup
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/metaconflux/backend/internal/api/users/repository"
+	"github.com/metaconflux/backend/internal/car"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/sirupsen/logrus"
+)
+
+// MAX_ENTRY_SIZE limits a line of the resolver file
+const MAX_ENTRY_SIZE = 16 * 1024 * 1024
+
+var ErrResolverNotEmpty = fmt.Errorf("Resolver is not empty")
+
+// Options select what goes into the archive besides the repository and the
+// resolver
+type Options struct {
+	// Blocks is set to include the objects the resolver points to
+	Blocks Blocks
+	// Prefixes of the resolver keys whose values are objects
+	Prefixes []string
+}
+
+// Write writes the archive, a zip file holding the meta data, the rows of the
+// repository, the resolver entries and optionally the objects as a CAR file
+func Write(ctx context.Context, w io.Writer, repo repository.UserRepository, r resolver.IResolver, options Options) (Meta, error) {
+	meta := Meta{
+		Version:   VERSION,
+		CreatedAt: time.Now(),
+	}
+
+	zw := zip.NewWriter(w)
+
+	dump, err := repo.Dump(ctx)
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Tiers = len(dump.Tiers)
+	meta.Users = len(dump.Users)
+	meta.Manifests = len(dump.Manifests)
+	meta.Domains = len(dump.Domains)
+
+	err = writeJSON(zw, REPOSITORY_FILE, newRepository(dump))
+	if err != nil {
+		return meta, err
+	}
+
+	f, err := zw.Create(RESOLVER_FILE)
+	if err != nil {
+		return meta, err
+	}
+
+	roots := make(map[cid.Cid]bool)
+	enc := json.NewEncoder(f)
+	err = resolver.Each(r, "", func(entry resolver.Entry) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		meta.Entries++
+
+		if options.Blocks != nil && hasPrefix(entry.Key, options.Prefixes) {
+			c, err := cid.Decode(entry.Value)
+			if err == nil {
+				roots[c] = true
+			}
+		}
+
+		return enc.Encode(entry)
+	})
+	if err != nil {
+		return meta, err
+	}
+
+	if options.Blocks != nil {
+		f, err := zw.Create(OBJECTS_FILE)
+		if err != nil {
+			return meta, err
+		}
+
+		err = writeObjects(ctx, f, options.Blocks, roots, &meta)
+		if err != nil {
+			return meta, err
+		}
+	}
+
+	err = writeJSON(zw, META_FILE, meta)
+	if err != nil {
+		return meta, err
+	}
+
+	return meta, zw.Close()
+}
+
+// writeObjects writes the roots with every block they link to as a CAR file.
+// Roots missing on the node are skipped, e.g. objects collected after being
+// orphaned.
+func writeObjects(ctx context.Context, w io.Writer, blocks Blocks, roots map[cid.Cid]bool, meta *Meta) error {
+	sorted := make([]cid.Cid, 0, len(roots))
+	for c := range roots {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].KeyString() < sorted[j].KeyString()
+	})
+
+	cw, err := car.NewWriter(w, sorted)
+	if err != nil {
+		return err
+	}
+
+	for _, root := range sorted {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if cw.Has(root) {
+			meta.Objects++
+			continue
+		}
+
+		data, err := blocks.BlockGet(root.String())
+		if err != nil {
+			logrus.Warnf("Skipping object %s: %s", root, err)
+			meta.Missing++
+			continue
+		}
+
+		err = cw.WriteBlock(root, data)
+		if err != nil {
+			return err
+		}
+		meta.Objects++
+		meta.Blocks++
+
+		refs, err := blocks.Refs(root.String(), true)
+		if err != nil {
+			return err
+		}
+
+		for ref := range refs {
+			c, err := cid.Decode(ref)
+			if err != nil {
+				return err
+			}
+
+			if cw.Has(c) {
+				continue
+			}
+
+			data, err := blocks.BlockGet(ref)
+			if err != nil {
+				return fmt.Errorf("Failed to read block %s of %s: %s", ref, root, err)
+			}
+
+			err = cw.WriteBlock(c, data)
+			if err != nil {
+				return err
+			}
+			meta.Blocks++
+		}
+	}
+
+	return cw.Flush()
+}
+
+// Read restores the archive into an empty repository and resolver. The
+// objects are imported first when an importer is set, so the entries can be
+// pinned right away.
+func Read(ctx context.Context, path string, repo repository.UserRepository, r resolver.IResolver, importer Importer) (Meta, error) {
+	var meta Meta
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return meta, err
+	}
+	defer zr.Close()
+
+	err = readJSON(&zr.Reader, META_FILE, &meta)
+	if err != nil {
+		return meta, err
+	}
+
+	if meta.Version != VERSION {
+		return meta, fmt.Errorf("Unsupported backup version %d", meta.Version)
+	}
+
+	stats, err := r.Count("")
+	if err != nil {
+		return meta, err
+	}
+	if stats.Keys > 0 {
+		return meta, ErrResolverNotEmpty
+	}
+
+	var data Repository
+	err = readJSON(&zr.Reader, REPOSITORY_FILE, &data)
+	if err != nil {
+		return meta, err
+	}
+
+	err = repo.Load(ctx, data.dump())
+	if err != nil {
+		return meta, err
+	}
+
+	if importer != nil {
+		f, err := openFile(&zr.Reader, OBJECTS_FILE)
+		if err == nil {
+			err = importer(f)
+			f.Close()
+			if err != nil {
+				return meta, fmt.Errorf("Failed to import objects: %s", err)
+			}
+		} else if err != errMissingFile {
+			return meta, err
+		}
+	}
+
+	f, err := openFile(&zr.Reader, RESOLVER_FILE)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_ENTRY_SIZE)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return meta, ctx.Err()
+		}
+
+		var entry resolver.Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return meta, err
+		}
+
+		err = resolver.Restore(r, entry)
+		if err != nil {
+			return meta, err
+		}
+	}
+
+	return meta, scanner.Err()
+}
+
+var errMissingFile = fmt.Errorf("File missing in the archive")
+
+func openFile(zr *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+
+	return nil, errMissingFile
+}
+
+func readJSON(zr *zip.Reader, name string, target interface{}) error {
+	f, err := openFile(zr, name)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s", name, err)
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(target)
+}
+
+func writeJSON(zw *zip.Writer, name string, data interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(data)
+}
+
+func hasPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
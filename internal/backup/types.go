@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"io"
+	"time"
+
+	"github.com/metaconflux/backend/internal/api/users/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	VERSION = 1
+
+	META_FILE       = "backup.json"
+	REPOSITORY_FILE = "repository.json"
+	RESOLVER_FILE   = "resolver.jsonl"
+	OBJECTS_FILE    = "objects.car"
+)
+
+// Meta describes the archive
+type Meta struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Tiers     int       `json:"tiers"`
+	Users     int       `json:"users"`
+	Manifests int       `json:"manifests"`
+	Domains   int       `json:"domains"`
+	Entries   int64     `json:"entries"`
+	// Objects are the root CIDs in the CAR file, Missing the ones which
+	// could not be read from the node
+	Objects int `json:"objects"`
+	Blocks  int `json:"blocks"`
+	Missing int `json:"missing"`
+}
+
+// Blocks reads the objects from the node, *shell.Shell implements it
+type Blocks interface {
+	Refs(hash string, recursive bool) (<-chan string, error)
+	BlockGet(path string) ([]byte, error)
+}
+
+// Importer imports the objects of a CAR file into the node
+type Importer func(car io.Reader) error
+
+// Repository holds the rows of the repository independently of the models
+// and their JSON, which leaves out e.g. the hook secrets
+type Repository struct {
+	Tiers     []Tier     `json:"tiers"`
+	Users     []User     `json:"users"`
+	Manifests []Manifest `json:"manifests"`
+	Domains   []Domain   `json:"domains"`
+}
+
+type Tier struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Address   string    `json:"address"`
+	LastLogin time.Time `json:"lastLogin"`
+	Nonce     string    `json:"nonce"`
+	TierID    uint      `json:"tierId"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Manifest struct {
+	ChainID    int64     `json:"chainId"`
+	Address    string    `json:"address"`
+	UserID     string    `json:"userId"`
+	HookSecret string    `json:"hookSecret"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type Domain struct {
+	Domain     string     `json:"domain"`
+	ChainID    int64      `json:"chainId"`
+	Address    string     `json:"address"`
+	Token      string     `json:"token"`
+	Verified   bool       `json:"verified"`
+	Method     string     `json:"method,omitempty"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+func newRepository(dump repository.Dump) Repository {
+	r := Repository{
+		Tiers:     make([]Tier, 0, len(dump.Tiers)),
+		Users:     make([]User, 0, len(dump.Users)),
+		Manifests: make([]Manifest, 0, len(dump.Manifests)),
+		Domains:   make([]Domain, 0, len(dump.Domains)),
+	}
+
+	for _, t := range dump.Tiers {
+		r.Tiers = append(r.Tiers, Tier{
+			ID:        t.ID,
+			Name:      t.Name,
+			CreatedAt: t.CreatedAt,
+			UpdatedAt: t.UpdatedAt,
+		})
+	}
+
+	for _, u := range dump.Users {
+		r.Users = append(r.Users, User{
+			ID:        u.ID,
+			Email:     u.Email,
+			Address:   u.Address,
+			LastLogin: u.LastLogin,
+			Nonce:     u.Nonce,
+			TierID:    u.TierID,
+			Disabled:  u.Disabled,
+			CreatedAt: u.CreatedAt,
+			UpdatedAt: u.UpdatedAt,
+		})
+	}
+
+	for _, m := range dump.Manifests {
+		r.Manifests = append(r.Manifests, Manifest{
+			ChainID:    m.ChainId,
+			Address:    m.Address,
+			UserID:     m.UserID,
+			HookSecret: m.HookSecret,
+			CreatedAt:  m.CreatedAt,
+			UpdatedAt:  m.UpdatedAt,
+		})
+	}
+
+	for _, d := range dump.Domains {
+		r.Domains = append(r.Domains, Domain{
+			Domain:     d.Domain,
+			ChainID:    d.ChainId,
+			Address:    d.Address,
+			Token:      d.Token,
+			Verified:   d.Verified,
+			Method:     d.Method,
+			VerifiedAt: d.VerifiedAt,
+			CreatedAt:  d.CreatedAt,
+			UpdatedAt:  d.UpdatedAt,
+		})
+	}
+
+	return r
+}
+
+func (r Repository) dump() repository.Dump {
+	var dump repository.Dump
+
+	for _, t := range r.Tiers {
+		dump.Tiers = append(dump.Tiers, repository.TierModel{
+			Model: gorm.Model{ID: t.ID, CreatedAt: t.CreatedAt, UpdatedAt: t.UpdatedAt},
+			Name:  t.Name,
+		})
+	}
+
+	for _, u := range r.Users {
+		user := repository.UserModel{
+			ID:        u.ID,
+			Email:     u.Email,
+			Address:   u.Address,
+			LastLogin: u.LastLogin,
+			Nonce:     u.Nonce,
+			TierID:    u.TierID,
+			Disabled:  u.Disabled,
+		}
+		user.CreatedAt = u.CreatedAt
+		user.UpdatedAt = u.UpdatedAt
+		dump.Users = append(dump.Users, user)
+	}
+
+	for _, m := range r.Manifests {
+		dump.Manifests = append(dump.Manifests, repository.ManifestModel{
+			Model:      gorm.Model{CreatedAt: m.CreatedAt, UpdatedAt: m.UpdatedAt},
+			ChainId:    m.ChainID,
+			Address:    m.Address,
+			UserID:     m.UserID,
+			HookSecret: m.HookSecret,
+		})
+	}
+
+	for _, d := range r.Domains {
+		dump.Domains = append(dump.Domains, repository.DomainModel{
+			Model:      gorm.Model{CreatedAt: d.CreatedAt, UpdatedAt: d.UpdatedAt},
+			Domain:     d.Domain,
+			ChainId:    d.ChainID,
+			Address:    d.Address,
+			Token:      d.Token,
+			Verified:   d.Verified,
+			Method:     d.Method,
+			VerifiedAt: d.VerifiedAt,
+		})
+	}
+
+	return dump
+}
@@ -3,6 +3,7 @@ package ipfs
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 
 	shell "github.com/ipfs/go-ipfs-api"
@@ -42,13 +43,23 @@ func (c IPFSCache) Get(id string, target interface{}) error {
 	return nil
 }
 
+// Push stores data as JSON, except for []byte and io.Reader which are stored
+// as they are (e.g. rendered images)
 func (c IPFSCache) Push(data interface{}) (string, error) {
-	b, err := json.Marshal(data)
-	if err != nil {
-		return "", err
-	}
+	var r io.Reader
+	switch d := data.(type) {
+	case []byte:
+		r = bytes.NewReader(d)
+	case io.Reader:
+		r = d
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
 
-	r := bytes.NewReader(b)
+		r = bytes.NewReader(b)
+	}
 
 	cid, err := c.client.Add(r)
 	if err != nil {
@@ -0,0 +1,337 @@
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// CHUNK_SIZE matches the default chunker of ipfs add
+	CHUNK_SIZE = 256 * 1024
+	// MAX_LINKS matches the default link count per node of ipfs add
+	MAX_LINKS = 174
+	// SHARDING_SIZE is where ipfs add switches to a HAMT sharded directory,
+	// measured as the sum of the name and CID lengths of the entries
+	SHARDING_SIZE = 256 * 1024
+
+	unixfsDirectory = 1
+	unixfsFile      = 2
+)
+
+type block struct {
+	cid  cid.Cid
+	data []byte
+}
+
+type entry struct {
+	name  string
+	cid   cid.Cid
+	tsize uint64
+}
+
+// Builder assembles files into a flat UnixFS directory, laid out as
+// `ipfs add --cid-version=1` does (raw leaves, 256KiB chunks, balanced files
+// of up to MAX_LINKS chunks), and writes it as a CARv1 archive. Directories
+// ipfs add would shard are not supported, files are rejected once the
+// directory reaches SHARDING_SIZE.
+type Builder struct {
+	entries []entry
+	names   map[string]bool
+	blocks  []block
+	seen    map[cid.Cid]bool
+	size    int
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		names: make(map[string]bool),
+		seen:  make(map[cid.Cid]bool),
+	}
+}
+
+// AddFile adds the file to the directory and returns its CID
+func (b *Builder) AddFile(name string, data []byte) (cid.Cid, error) {
+	if b.names[name] {
+		return cid.Undef, fmt.Errorf("Duplicate file %s", name)
+	}
+
+	var c cid.Cid
+	var tsize uint64
+	var err error
+	if len(data) <= CHUNK_SIZE {
+		c, err = b.add(cid.Raw, data)
+		tsize = uint64(len(data))
+	} else {
+		c, tsize, err = b.addChunked(data)
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	size := b.size + len(name) + len(c.Bytes())
+	if size >= SHARDING_SIZE {
+		return cid.Undef, fmt.Errorf("Directory of %d files reached %d bytes of links and would be sharded, add fewer files", len(b.entries)+1, size)
+	}
+
+	b.size = size
+	b.names[name] = true
+	b.entries = append(b.entries, entry{name: name, cid: c, tsize: tsize})
+
+	return c, nil
+}
+
+func (b *Builder) addChunked(data []byte) (cid.Cid, uint64, error) {
+	var links []entry
+	var sizes []uint64
+	for offset := 0; offset < len(data); offset += CHUNK_SIZE {
+		end := offset + CHUNK_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+
+		c, err := b.add(cid.Raw, data[offset:end])
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+
+		links = append(links, entry{cid: c, tsize: uint64(end - offset)})
+		sizes = append(sizes, uint64(end-offset))
+	}
+
+	if len(links) > MAX_LINKS {
+		return cid.Undef, 0, fmt.Errorf("File of %d bytes is too large", len(data))
+	}
+
+	node := encodeNode(links, encodeUnixFS(unixfsFile, uint64(len(data)), sizes))
+	c, err := b.add(cid.DagProtobuf, node)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	tsize := uint64(len(node))
+	for _, link := range links {
+		tsize += link.tsize
+	}
+
+	return c, tsize, nil
+}
+
+// Root returns the CID of the directory
+func (b *Builder) Root() (cid.Cid, error) {
+	root, err := b.root()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return root.cid, nil
+}
+
+func (b *Builder) root() (block, error) {
+	entries := make([]entry, len(b.entries))
+	copy(entries, b.entries)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	node := encodeNode(entries, encodeUnixFS(unixfsDirectory, 0, nil))
+
+	c, err := newCid(cid.DagProtobuf, node)
+	if err != nil {
+		return block{}, err
+	}
+
+	return block{cid: c, data: node}, nil
+}
+
+// Write writes the CARv1 archive with the directory as its root and returns
+// the root CID
+func (b *Builder) Write(w io.Writer) (cid.Cid, error) {
+	root, err := b.root()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	err = writeSection(bw, encodeHeader([]cid.Cid{root.cid}))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// The root first, then the blocks in the order they were added
+	for _, blk := range append([]block{root}, b.blocks...) {
+		err = writeSection(bw, append(blk.cid.Bytes(), blk.data...))
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	return root.cid, bw.Flush()
+}
+
+// Writer writes existing blocks, e.g. fetched from a node, as a CARv1
+// archive
+type Writer struct {
+	w    *bufio.Writer
+	seen map[cid.Cid]bool
+}
+
+// NewWriter writes the header with the roots, the blocks of the roots have
+// to be written by the caller
+func NewWriter(w io.Writer, roots []cid.Cid) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+
+	err := writeSection(bw, encodeHeader(roots))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:    bw,
+		seen: make(map[cid.Cid]bool),
+	}, nil
+}
+
+// Has reports whether the block was written already
+func (w *Writer) Has(c cid.Cid) bool {
+	return w.seen[c]
+}
+
+// WriteBlock writes the block unless it was written already. The data is
+// checked against the CID.
+func (w *Writer) WriteBlock(c cid.Cid, data []byte) error {
+	if w.seen[c] {
+		return nil
+	}
+
+	check, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !check.Equals(c) {
+		return fmt.Errorf("Block data does not match %s", c)
+	}
+
+	w.seen[c] = true
+
+	return writeSection(w.w, append(c.Bytes(), data...))
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func newCid(codec uint64, data []byte) (cid.Cid, error) {
+	hash, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return cid.NewCidV1(codec, hash), nil
+}
+
+func (b *Builder) add(codec uint64, data []byte) (cid.Cid, error) {
+	c, err := newCid(codec, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if b.seen[c] {
+		return c, nil
+	}
+
+	b.seen[c] = true
+	b.blocks = append(b.blocks, block{cid: c, data: data})
+
+	return c, nil
+}
+
+func writeSection(w io.Writer, data []byte) error {
+	_, err := w.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// encodeHeader encodes {roots: [...roots], version: 1} as DAG-CBOR
+func encodeHeader(roots []cid.Cid) []byte {
+	buf := []byte{0xa2}
+	buf = appendCBORString(buf, "roots")
+	buf = appendCBORHead(buf, 4, uint64(len(roots)))
+	for _, root := range roots {
+		// tag 42 with the CID prefixed by the identity multibase
+		buf = append(buf, 0xd8, 0x2a)
+		buf = appendCBORHead(buf, 2, uint64(len(root.Bytes())+1))
+		buf = append(buf, 0x00)
+		buf = append(buf, root.Bytes()...)
+	}
+	buf = appendCBORString(buf, "version")
+	buf = append(buf, 0x01)
+
+	return buf
+}
+
+func appendCBORString(buf []byte, s string) []byte {
+	buf = appendCBORHead(buf, 3, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendCBORHead(buf []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(buf, major<<5|byte(n))
+	case n < 1<<8:
+		return append(buf, major<<5|24, byte(n))
+	case n < 1<<16:
+		return append(buf, major<<5|25, byte(n>>8), byte(n))
+	default:
+		return append(buf, major<<5|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// encodeNode encodes a DAG-PB node, links come before data
+func encodeNode(links []entry, data []byte) []byte {
+	var buf []byte
+	for _, link := range links {
+		var l []byte
+		// The name is written even when empty, as ipfs add does
+		l = appendBytes(l, 1, link.cid.Bytes())
+		l = appendBytes(l, 2, []byte(link.name))
+		l = appendVarint(l, 3, link.tsize)
+
+		buf = appendBytes(buf, 2, l)
+	}
+
+	return appendBytes(buf, 1, data)
+}
+
+// encodeUnixFS encodes the UnixFS data of a node
+func encodeUnixFS(kind uint64, filesize uint64, blocksizes []uint64) []byte {
+	buf := appendVarint(nil, 1, kind)
+	if kind == unixfsFile {
+		buf = appendVarint(buf, 3, filesize)
+		for _, size := range blocksizes {
+			buf = appendVarint(buf, 4, size)
+		}
+	}
+
+	return buf
+}
+
+func appendVarint(buf []byte, field int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3))
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendBytes(buf []byte, field int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3|2))
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
@@ -0,0 +1,82 @@
+package car
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The expected CIDs were produced with the UnixFS importer of
+// github.com/ipfs/boxo v0.12.0, which ipfs add uses, with the settings of
+// ipfs add --cid-version=1: raw leaves, 256KiB chunks, balanced layout with
+// 174 links per node and HAMT sharding from 256KiB of links on.
+
+func pattern(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+
+	return data
+}
+
+func TestBuilderMatchesIpfsAdd(t *testing.T) {
+	files := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"1", []byte(`{"name":"Token #1"}`), "bafkreic3o423al7el5ifnbykll7zynjoew7lzp4pobv6gnuyifk466e36y"},
+		{"2", pattern(CHUNK_SIZE), "bafkreidtpmdhdmgsrvgltsrlzrfs5ragszmg3o3legvfagtwldoeiucayu"},
+		{"3", pattern(3*CHUNK_SIZE + 123), "bafybeihgamak6w7kqctx3lnvuqv7intuc3d3k5tckgulaatgfwqqn3wtvi"},
+	}
+
+	b := NewBuilder()
+	for _, f := range files {
+		c, err := b.AddFile(f.name, f.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.String() != f.expected {
+			t.Errorf("Expected file %s to be %s, got %s", f.name, f.expected, c)
+		}
+	}
+
+	root, err := b.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "bafybeihk5izlocbg43mq7el7axjycnfl7hbkgrq7y6uupgag3avw3l4dmi"
+	if root.String() != expected {
+		t.Errorf("Expected root %s, got %s", expected, root)
+	}
+}
+
+func TestBuilderRejectsShardedDirectory(t *testing.T) {
+	// ipfs add shards the directory from the 5851st of these files on
+	const flat = 5850
+
+	b := NewBuilder()
+	for i := 1; i <= flat; i++ {
+		_, err := b.AddFile(fmt.Sprintf("%d.json", i), []byte(fmt.Sprintf(`{"id":%d}`, i)))
+		if err != nil {
+			t.Fatalf("File %d: %s", i, err)
+		}
+	}
+
+	root, err := b.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "bafybeigwiqi37qfsfcjmttpupsugccotfa3x7ljx7vn6a544357te7jhb4"
+	if root.String() != expected {
+		t.Errorf("Expected root %s, got %s", expected, root)
+	}
+
+	_, err = b.AddFile(fmt.Sprintf("%d.json", flat+1), []byte(fmt.Sprintf(`{"id":%d}`, flat+1)))
+	if err == nil {
+		t.Fatal("Expected the file to be rejected, ipfs add would shard the directory")
+	}
+}
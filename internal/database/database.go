@@ -0,0 +1,45 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	DRIVER_SQLITE   = "sqlite"
+	DRIVER_POSTGRES = "postgres"
+
+	DEFAULT_DRIVER = DRIVER_SQLITE
+	DEFAULT_DSN    = "./gorm.db"
+)
+
+// Open connects to the database the repository, resolver and the other gorm
+// stores live in. The dsn is a file path for sqlite and a connection string
+// (e.g. host=localhost user=metaconflux dbname=metaconflux) for postgres.
+func Open(driver string, dsn string) (*gorm.DB, error) {
+	if len(driver) == 0 {
+		driver = DEFAULT_DRIVER
+	}
+
+	if len(dsn) == 0 {
+		if driver != DRIVER_SQLITE {
+			return nil, fmt.Errorf("Database %s needs a dsn", driver)
+		}
+		dsn = DEFAULT_DSN
+	}
+
+	var dialector gorm.Dialector
+	switch driver {
+	case DRIVER_SQLITE:
+		dialector = sqlite.Open(dsn)
+	case DRIVER_POSTGRES:
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("Unknown database driver %s", driver)
+	}
+
+	return gorm.Open(dialector, &gorm.Config{})
+}
@@ -0,0 +1,144 @@
+// Package diff compares generated metadata documents
+package diff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+const (
+	OP_ADD     = "add"
+	OP_REMOVE  = "remove"
+	OP_REPLACE = "replace"
+)
+
+// Change is a single difference, Path uses the dotted notation of the
+// transformer targets (e.g. attributes.0.value)
+type Change struct {
+	Op   string      `json:"op"`
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Normalize converts v to plain JSON values with the top level keys in
+// exclude removed
+func Normalize(v interface{}, exclude ...string) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err = d.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := generic.(map[string]interface{}); ok && len(exclude) > 0 {
+		for _, key := range exclude {
+			delete(m, key)
+		}
+	}
+
+	return generic, nil
+}
+
+// Hash returns a content hash of v ignoring the top level keys in exclude.
+// Map keys are sorted by encoding/json, so equal documents hash equally.
+func Hash(v interface{}, exclude ...string) (string, error) {
+	n, err := Normalize(v, exclude...)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Compare returns the changes needed to get from old to new. Both have to be
+// normalized.
+func Compare(old interface{}, new interface{}) []Change {
+	changes := make([]Change, 0)
+	compare("", old, new, &changes)
+	return changes
+}
+
+func compare(path string, old interface{}, new interface{}, changes *[]Change) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		for k := range n {
+			if _, ok := o[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			p := join(path, k)
+			switch {
+			case !inOld:
+				*changes = append(*changes, Change{Op: OP_ADD, Path: p, New: nv})
+			case !inNew:
+				*changes = append(*changes, Change{Op: OP_REMOVE, Path: p, Old: ov})
+			default:
+				compare(p, ov, nv, changes)
+			}
+		}
+		return
+
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(o) || i < len(n); i++ {
+			p := join(path, fmt.Sprintf("%d", i))
+			switch {
+			case i >= len(o):
+				*changes = append(*changes, Change{Op: OP_ADD, Path: p, New: n[i]})
+			case i >= len(n):
+				*changes = append(*changes, Change{Op: OP_REMOVE, Path: p, Old: o[i]})
+			default:
+				compare(p, o[i], n[i], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, Change{Op: OP_REPLACE, Path: path, Old: old, New: new})
+	}
+}
+
+func join(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+
+	return fmt.Sprintf("%s.%s", path, key)
+}
@@ -0,0 +1,191 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_ROOT     = "/metaconflux"
+	DEFAULT_DEBOUNCE = time.Minute
+	// IPNS_LIFETIME is how long the published record stays valid, it is
+	// republished by the node
+	IPNS_LIFETIME = 7 * 24 * time.Hour
+	IPNS_TTL      = 5 * time.Minute
+)
+
+// Publisher assembles token metadata into UnixFS directories in MFS and
+// points an IPNS key per collection to them. It also makes sure only one job
+// runs per collection at a time.
+type Publisher struct {
+	ctx      context.Context
+	shell    *shell.Shell
+	root     string
+	debounce time.Duration
+
+	lock    sync.Mutex
+	running map[string]bool
+	pending map[string]*time.Timer
+}
+
+// NewPublisher creates a publisher, its jobs are canceled once ctx is done
+func NewPublisher(ctx context.Context, shell *shell.Shell, root string, debounce time.Duration) *Publisher {
+	if len(root) == 0 {
+		root = DEFAULT_ROOT
+	}
+
+	if debounce <= 0 {
+		debounce = DEFAULT_DEBOUNCE
+	}
+
+	return &Publisher{
+		ctx:      ctx,
+		shell:    shell,
+		root:     path.Clean("/" + root),
+		debounce: debounce,
+		running:  make(map[string]bool),
+		pending:  make(map[string]*time.Timer),
+	}
+}
+
+// Name returns the directory and IPNS key name of the collection
+func Name(chainId int64, contract string) string {
+	return fmt.Sprintf("metaconflux-%d-%s", chainId, strings.ToLower(contract))
+}
+
+// Build copies the files (name to CID) into the MFS directory of the
+// collection and returns the CID of the directory. The directory is assembled
+// next to the previous one and swapped once complete.
+func (p *Publisher) Build(ctx context.Context, name string, files map[string]string) (string, error) {
+	dir := path.Join(p.root, name)
+	tmp := dir + ".tmp"
+
+	// Leftovers of a failed build
+	_ = p.shell.FilesRm(ctx, tmp, true)
+
+	err := p.shell.FilesMkdir(ctx, tmp, shell.FilesMkdir.Parents(true))
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err = p.shell.FilesCp(ctx, fmt.Sprintf("/ipfs/%s", files[name]), path.Join(tmp, name))
+		if err != nil {
+			return "", fmt.Errorf("Failed to add %s: %s", name, err)
+		}
+	}
+
+	_ = p.shell.FilesRm(ctx, dir, true)
+
+	err = p.shell.FilesMv(ctx, tmp, dir)
+	if err != nil {
+		return "", err
+	}
+
+	stat, err := p.shell.FilesStat(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+
+	return stat.Hash, nil
+}
+
+// Publish points the IPNS key of the collection to the directory, the key is
+// created on first use. It returns the IPNS name.
+func (p *Publisher) Publish(ctx context.Context, name string, cid string) (string, error) {
+	keys, err := p.shell.KeyList(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	exists := false
+	for _, key := range keys {
+		if key.Name == name {
+			exists = true
+			break
+		}
+	}
+
+	if !exists {
+		_, err = p.shell.KeyGen(ctx, name, shell.KeyGen.Type("ed25519"))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resp, err := p.shell.PublishWithDetails(fmt.Sprintf("/ipfs/%s", cid), name, IPNS_LIFETIME, IPNS_TTL, false)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Name, nil
+}
+
+// Start runs the job in the background unless one is already running for
+// the key
+func (p *Publisher) Start(key string, job func(ctx context.Context)) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.running[key] {
+		return false
+	}
+	p.running[key] = true
+
+	go func() {
+		defer func() {
+			p.lock.Lock()
+			delete(p.running, key)
+			p.lock.Unlock()
+		}()
+
+		job(p.ctx)
+	}()
+
+	return true
+}
+
+// Schedule starts the job once no further changes were scheduled for the
+// debounce period, so a burst of refreshes results in a single job
+func (p *Publisher) Schedule(key string, job func(ctx context.Context)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if timer, ok := p.pending[key]; ok {
+		timer.Reset(p.debounce)
+		return
+	}
+
+	var fire func()
+	fire = func() {
+		p.lock.Lock()
+		delete(p.pending, key)
+		p.lock.Unlock()
+
+		if !p.Start(key, job) {
+			// Changes made during the running job are picked up by the next one
+			logrus.Debugf("Directory job for %s running, rescheduling", key)
+			p.lock.Lock()
+			if _, ok := p.pending[key]; !ok {
+				p.pending[key] = time.AfterFunc(p.debounce, fire)
+			}
+			p.lock.Unlock()
+		}
+	}
+
+	p.pending[key] = time.AfterFunc(p.debounce, fire)
+}
@@ -0,0 +1,179 @@
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	METHOD_DNS        = "dns"
+	METHOD_WELL_KNOWN = "well-known"
+
+	// TXT_PREFIX is prepended to the domain for the TXT record lookup
+	TXT_PREFIX = "_metaconflux."
+	// TXT_VALUE_PREFIX is prepended to the token in the TXT record value
+	TXT_VALUE_PREFIX = "metaconflux-verification="
+	// DEFAULT_WELL_KNOWN_URL is formatted with the domain
+	DEFAULT_WELL_KNOWN_URL = "https://%s/.well-known/metaconflux-verification"
+
+	MAX_WELL_KNOWN_SIZE = 1024
+)
+
+var ErrNotVerified = fmt.Errorf("Domain verification token not found")
+
+// Verifier checks a domain is controlled by the manifest owner, either by a
+// TXT record or by a file served from the domain. Both the DNS server and the
+// well-known url can point to a local stand-in.
+type Verifier struct {
+	resolver     *net.Resolver
+	client       *http.Client
+	wellKnownUrl string
+}
+
+// NewVerifier creates a verifier, dnsServer (host:port) and wellKnownUrl
+// fall back to the system resolver and DEFAULT_WELL_KNOWN_URL when empty
+func NewVerifier(dnsServer string, wellKnownUrl string) *Verifier {
+	resolver := net.DefaultResolver
+	if len(dnsServer) > 0 {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{Timeout: 5 * time.Second}
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+
+	if len(wellKnownUrl) == 0 {
+		wellKnownUrl = DEFAULT_WELL_KNOWN_URL
+	}
+
+	return &Verifier{
+		resolver: resolver,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wellKnownUrl: wellKnownUrl,
+	}
+}
+
+func NewToken() (string, error) {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// Normalize lowercases the domain and strips the port and a trailing dot
+func Normalize(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
+
+func Validate(domain string) error {
+	if len(domain) == 0 || len(domain) > 253 || !strings.Contains(domain, ".") {
+		return fmt.Errorf("Invalid domain %s", domain)
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("Invalid domain %s", domain)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("Invalid domain %s", domain)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *Verifier) WellKnownUrl(domain string) string {
+	return fmt.Sprintf(v.wellKnownUrl, domain)
+}
+
+// Verify checks the token with the given method, or with either when the
+// method is empty, and returns the method that succeeded
+func (v *Verifier) Verify(ctx context.Context, domain string, token string, method string) (string, error) {
+	switch method {
+	case METHOD_DNS:
+		return method, v.VerifyDNS(ctx, domain, token)
+	case METHOD_WELL_KNOWN:
+		return method, v.VerifyWellKnown(ctx, domain, token)
+	case "":
+		dnsErr := v.VerifyDNS(ctx, domain, token)
+		if dnsErr == nil {
+			return METHOD_DNS, nil
+		}
+
+		err := v.VerifyWellKnown(ctx, domain, token)
+		if err != nil {
+			return "", fmt.Errorf("%s: dns: %s, well-known: %s", ErrNotVerified, dnsErr, err)
+		}
+
+		return METHOD_WELL_KNOWN, nil
+	default:
+		return "", fmt.Errorf("Unknown verification method %s", method)
+	}
+}
+
+func (v *Verifier) VerifyDNS(ctx context.Context, domain string, token string) error {
+	records, err := v.resolver.LookupTXT(ctx, TXT_PREFIX+domain)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == TXT_VALUE_PREFIX+token {
+			return nil
+		}
+	}
+
+	return ErrNotVerified
+}
+
+func (v *Verifier) VerifyWellKnown(ctx context.Context, domain string, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.WellKnownUrl(domain), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MAX_WELL_KNOWN_SIZE))
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(data)) != token {
+		return ErrNotVerified
+	}
+
+	return nil
+}
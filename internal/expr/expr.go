@@ -0,0 +1,216 @@
+// Package expr implements a small, side effect free expression language used
+// by the transformers, e.g. `id >= 1000 && steps.owner.level > 3`.
+//
+// Integers are arbitrary precision (*big.Int), decimals are float64. Missing
+// names and paths evaluate to null instead of failing.
+package expr
+
+import (
+	"fmt"
+)
+
+type Expr struct {
+	src  string
+	root node
+}
+
+func Compile(src string) (*Expr, error) {
+	root, err := parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse expression '%s': %s", src, err)
+	}
+
+	return &Expr{
+		src:  src,
+		root: root,
+	}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against env and returns plain values (nil,
+// bool, string, *big.Int, float64, []interface{}, map[string]interface{})
+func (e *Expr) Eval(env map[string]interface{}) (interface{}, error) {
+	val, err := eval(e.root, env)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to evaluate '%s': %s", e.src, err)
+	}
+
+	return val, nil
+}
+
+func (e *Expr) EvalBool(env map[string]interface{}) (bool, error) {
+	val, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	return Truthy(val), nil
+}
+
+// EvalBool compiles and evaluates src in one go
+func EvalBool(src string, env map[string]interface{}) (bool, error) {
+	e, err := Compile(src)
+	if err != nil {
+		return false, err
+	}
+
+	return e.EvalBool(env)
+}
+
+func eval(n node, env map[string]interface{}) (interface{}, error) {
+	switch n := n.(type) {
+	case literalNode:
+		return n.val, nil
+
+	case identNode:
+		val, ok := env[n.name]
+		if !ok {
+			return nil, nil
+		}
+		return normalize(val), nil
+
+	case memberNode:
+		target, err := eval(n.target, env)
+		if err != nil {
+			return nil, err
+		}
+		key, err := eval(n.key, env)
+		if err != nil {
+			return nil, err
+		}
+		return member(target, key)
+
+	case callNode:
+		fn, ok := functions[n.name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown function %s", n.name)
+		}
+		args := make([]interface{}, len(n.args))
+		for i, arg := range n.args {
+			val, err := eval(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = val
+		}
+		val, err := fn(args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): %s", n.name, err)
+		}
+		return val, nil
+
+	case unaryNode:
+		val, err := eval(n.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "!":
+			return !Truthy(val), nil
+		case "-":
+			return negate(val)
+		}
+
+	case binaryNode:
+		left, err := eval(n.left, env)
+		if err != nil {
+			return nil, err
+		}
+
+		// Short circuit so guards like `x != null && x.y > 1` work
+		switch n.op {
+		case "&&":
+			if !Truthy(left) {
+				return false, nil
+			}
+			right, err := eval(n.right, env)
+			if err != nil {
+				return nil, err
+			}
+			return Truthy(right), nil
+		case "||":
+			if Truthy(left) {
+				return true, nil
+			}
+			right, err := eval(n.right, env)
+			if err != nil {
+				return nil, err
+			}
+			return Truthy(right), nil
+		}
+
+		right, err := eval(n.right, env)
+		if err != nil {
+			return nil, err
+		}
+		return binary(n.op, left, right)
+
+	case ternaryNode:
+		cond, err := eval(n.cond, env)
+		if err != nil {
+			return nil, err
+		}
+		if Truthy(cond) {
+			return eval(n.then, env)
+		}
+		return eval(n.els, env)
+
+	case listNode:
+		result := make([]interface{}, len(n.items))
+		for i, item := range n.items {
+			val, err := eval(item, env)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = val
+		}
+		return result, nil
+
+	case mapNode:
+		result := make(map[string]interface{}, len(n.keys))
+		for i := range n.keys {
+			key, err := eval(n.keys[i], env)
+			if err != nil {
+				return nil, err
+			}
+			val, err := eval(n.values[i], env)
+			if err != nil {
+				return nil, err
+			}
+			result[ToString(key)] = val
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported expression")
+}
+
+func member(target interface{}, key interface{}) (interface{}, error) {
+	switch t := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		val, ok := t[ToString(key)]
+		if !ok {
+			return nil, nil
+		}
+		return normalize(val), nil
+	case []interface{}:
+		idx, ok := toInt(key)
+		if !ok {
+			return nil, fmt.Errorf("Invalid list index %v", key)
+		}
+		if idx < 0 {
+			idx += int64(len(t))
+		}
+		if idx < 0 || idx >= int64(len(t)) {
+			return nil, nil
+		}
+		return normalize(t[idx]), nil
+	}
+
+	return nil, fmt.Errorf("Cannot access '%v' of %T", key, target)
+}
@@ -0,0 +1,513 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+type Func func(args ...interface{}) (interface{}, error)
+
+var functions = map[string]Func{
+	"len":      fnLen,
+	"int":      fnInt,
+	"float":    fnFloat,
+	"str":      fnStr,
+	"lower":    fnLower,
+	"upper":    fnUpper,
+	"trim":     fnTrim,
+	"replace":  fnReplace,
+	"contains": fnContains,
+	"concat":   fnConcat,
+	"join":     fnJoin,
+	"format":   fnFormat,
+	"lookup":   fnLookup,
+	"coalesce": fnCoalesce,
+	"min":      fnMin,
+	"max":      fnMax,
+	"clamp":    fnClamp,
+	"abs":      fnAbs,
+	"floor":    fnFloor,
+	"ceil":     fnCeil,
+	"round":    fnRound,
+	"pow":      fnPow,
+	"trait":    fnTrait,
+}
+
+// maxIntBits keeps pow() and * from allocating huge integers, checking the
+// exponent alone lets nested pow() calls grow the result without bound
+const maxIntBits = 4096
+
+func argCount(args []interface{}, min int, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		if min == max {
+			return fmt.Errorf("Expected %d arguments, got %d", min, len(args))
+		}
+		return fmt.Errorf("Expected %d to %d arguments, got %d", min, max, len(args))
+	}
+
+	return nil
+}
+
+func fnLen(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := normalize(args[0]).(type) {
+	case nil:
+		return big.NewInt(0), nil
+	case string:
+		return big.NewInt(int64(len([]rune(v)))), nil
+	case []interface{}:
+		return big.NewInt(int64(len(v))), nil
+	case map[string]interface{}:
+		return big.NewInt(int64(len(v))), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported type %s", typeName(args[0]))
+}
+
+func fnInt(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := ToNumber(args[0])
+	if !ok {
+		return nil, fmt.Errorf("Cannot convert %s to number", typeName(args[0]))
+	}
+
+	if f, ok := n.(float64); ok {
+		return floatToInt(f)
+	}
+
+	return n, nil
+}
+
+// floatToInt truncates the float, NaN and infinities have no integer value
+func floatToInt(f float64) (*big.Int, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("Cannot convert %v to integer", f)
+	}
+
+	i, _ := big.NewFloat(f).Int(nil)
+	return i, nil
+}
+
+func fnStr(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ToString(args[0]), nil
+}
+
+func fnLower(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.ToLower(ToString(args[0])), nil
+}
+
+func fnUpper(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.ToUpper(ToString(args[0])), nil
+}
+
+func fnContains(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := normalize(args[0]).(type) {
+	case nil:
+		return false, nil
+	case string:
+		return strings.Contains(v, ToString(args[1])), nil
+	case []interface{}:
+		for _, item := range v {
+			if Equal(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		_, ok := v[ToString(args[1])]
+		return ok, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported type %s", typeName(args[0]))
+}
+
+func fnMin(args ...interface{}) (interface{}, error) {
+	return extreme(args, -1)
+}
+
+func fnMax(args ...interface{}) (interface{}, error) {
+	return extreme(args, 1)
+}
+
+// extreme returns the smallest (sign -1) or the largest (sign 1) argument
+func extreme(args []interface{}, sign int) (interface{}, error) {
+	err := argCount(args, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 1 {
+		if list, ok := normalize(args[0]).([]interface{}); ok {
+			if len(list) == 0 {
+				return nil, nil
+			}
+			args = list
+		}
+	}
+
+	result := normalize(args[0])
+	for _, arg := range args[1:] {
+		c, err := Compare(arg, result)
+		if err != nil {
+			return nil, err
+		}
+		if c*sign > 0 {
+			result = normalize(arg)
+		}
+	}
+
+	return result, nil
+}
+
+func fnFloat(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := ToNumber(args[0])
+	if !ok {
+		return nil, fmt.Errorf("Cannot convert %s to number", typeName(args[0]))
+	}
+
+	return toFloat(n), nil
+}
+
+func fnTrim(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.TrimSpace(ToString(args[0])), nil
+}
+
+func fnReplace(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.ReplaceAll(ToString(args[0]), ToString(args[1]), ToString(args[2])), nil
+}
+
+func fnConcat(args ...interface{}) (interface{}, error) {
+	var sb strings.Builder
+	for _, arg := range args {
+		sb.WriteString(ToString(arg))
+	}
+
+	return sb.String(), nil
+}
+
+func fnJoin(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := normalize(args[0]).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expected list, got %s", typeName(args[0]))
+	}
+
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = ToString(item)
+	}
+
+	return strings.Join(items, ToString(args[1])), nil
+}
+
+// fnFormat is a printf with the values converted to the Go types the verbs
+// expect, e.g. format("%s #%05d", name, id)
+func fnFormat(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	format := ToString(args[0])
+	verbs := formatVerbs(format)
+
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		// Numeric strings (like the token id) are fine for numeric verbs
+		if i < len(verbs) && strings.ContainsRune("bdoxXeEfFgG", verbs[i]) {
+			if n, ok := ToNumber(arg); ok {
+				arg = n
+			}
+		}
+
+		switch v := arg.(type) {
+		case float64:
+			values[i] = v
+			continue
+		}
+
+		switch v := normalize(arg).(type) {
+		case nil:
+			values[i] = ""
+		case *big.Int:
+			values[i] = formattedInt{v}
+		case float64, string, bool:
+			values[i] = v
+		default:
+			values[i] = ToString(v)
+		}
+	}
+
+	result := fmt.Sprintf(format, values...)
+	if strings.Contains(result, "%!") {
+		return nil, fmt.Errorf("Invalid format '%s' for the arguments", format)
+	}
+
+	return result, nil
+}
+
+// formatVerbs lists the verbs of a printf format in the order of arguments
+func formatVerbs(format string) []rune {
+	var verbs []rune
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(runes) && strings.ContainsRune("+-# 0123456789.", runes[i]) {
+			i++
+		}
+		if i < len(runes) && runes[i] != '%' {
+			verbs = append(verbs, runes[i])
+		}
+	}
+
+	return verbs
+}
+
+// formattedInt lets integers be used with float verbs like %.2f
+type formattedInt struct {
+	*big.Int
+}
+
+func (i formattedInt) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		directive := "%"
+		for _, flag := range "+-# 0" {
+			if s.Flag(int(flag)) {
+				directive += string(flag)
+			}
+		}
+		if width, ok := s.Width(); ok {
+			directive += fmt.Sprintf("%d", width)
+		}
+		if prec, ok := s.Precision(); ok {
+			directive += fmt.Sprintf(".%d", prec)
+		}
+		fmt.Fprintf(s, directive+string(verb), toFloat(i.Int))
+	default:
+		i.Int.Format(s, verb)
+	}
+}
+
+// fnLookup returns table[key], the optional default or null if missing
+func fnLookup(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var fallback interface{}
+	if len(args) == 3 {
+		fallback = args[2]
+	}
+
+	switch table := normalize(args[1]).(type) {
+	case nil:
+		return fallback, nil
+	case map[string]interface{}:
+		val, ok := table[ToString(args[0])]
+		if !ok {
+			return fallback, nil
+		}
+		return normalize(val), nil
+	case []interface{}:
+		val, err := member(table, args[0])
+		if err != nil || val == nil {
+			return fallback, nil
+		}
+		return val, nil
+	}
+
+	return nil, fmt.Errorf("Expected map or list, got %s", typeName(args[1]))
+}
+
+func fnCoalesce(args ...interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func fnClamp(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := extreme([]interface{}{args[0], args[1]}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return extreme([]interface{}{val, args[2]}, -1)
+}
+
+func fnAbs(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := ToNumber(args[0])
+	if !ok {
+		return nil, fmt.Errorf("Expected number, got %s", typeName(args[0]))
+	}
+
+	switch v := n.(type) {
+	case *big.Int:
+		return new(big.Int).Abs(v), nil
+	case float64:
+		return math.Abs(v), nil
+	}
+
+	return nil, fmt.Errorf("Expected number, got %s", typeName(args[0]))
+}
+
+func fnFloor(args ...interface{}) (interface{}, error) {
+	return rounding(args, math.Floor)
+}
+
+func fnCeil(args ...interface{}) (interface{}, error) {
+	return rounding(args, math.Ceil)
+}
+
+func fnRound(args ...interface{}) (interface{}, error) {
+	return rounding(args, math.Round)
+}
+
+func rounding(args []interface{}, fn func(float64) float64) (interface{}, error) {
+	err := argCount(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := ToNumber(args[0])
+	if !ok {
+		return nil, fmt.Errorf("Expected number, got %s", typeName(args[0]))
+	}
+
+	f, ok := n.(float64)
+	if !ok {
+		return n, nil
+	}
+
+	return floatToInt(fn(f))
+}
+
+func fnPow(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	base, ok := ToNumber(args[0])
+	if !ok {
+		return nil, fmt.Errorf("Expected number, got %s", typeName(args[0]))
+	}
+	exp, ok := ToNumber(args[1])
+	if !ok {
+		return nil, fmt.Errorf("Expected number, got %s", typeName(args[1]))
+	}
+
+	bi, bInt := base.(*big.Int)
+	ei, eInt := exp.(*big.Int)
+	if bInt && eInt && ei.Sign() >= 0 {
+		// Powers of 0, 1 and -1 stay small whatever the exponent
+		if bi.BitLen() > 1 && (!ei.IsInt64() || ei.Int64() > maxIntBits || int64(bi.BitLen())*ei.Int64() > maxIntBits) {
+			return nil, fmt.Errorf("Result larger than %d bits", maxIntBits)
+		}
+		return new(big.Int).Exp(bi, ei, nil), nil
+	}
+
+	result := math.Pow(toFloat(base), toFloat(exp))
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return nil, fmt.Errorf("Result %v is not a finite number", result)
+	}
+
+	return result, nil
+}
+
+func fnTrait(args ...interface{}) (interface{}, error) {
+	err := argCount(args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return Trait(args[0], ToString(args[1])), nil
+}
+
+// Trait returns the value of the attribute with the given trait_type from an
+// OpenSea-style attributes list, e.g. trait(result.attributes, "XP")
+func Trait(attributes interface{}, name string) interface{} {
+	list, ok := normalize(attributes).([]interface{})
+	if !ok {
+		return nil
+	}
+
+	for _, item := range list {
+		attr, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if Equal(attr["trait_type"], name) {
+			return normalize(attr["value"])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package expr
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestIntegerSizeLimit(t *testing.T) {
+	rejected := []string{
+		"pow(pow(pow(2, 1024), 1024), 64)",
+		"pow(pow(2, 2048), 2)",
+		"pow(10, 100000)",
+		"pow(2, 2048) * pow(2, 2048) * pow(2, 2048)",
+	}
+
+	for _, src := range rejected {
+		e, err := Compile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		_, err = e.Eval(map[string]interface{}{})
+		if err == nil {
+			t.Errorf("Expected %s to exceed the size limit", src)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("Evaluating %s took %s", src, time.Since(start))
+		}
+	}
+
+	accepted := map[string]*big.Int{
+		"pow(2, 64)":         new(big.Int).Lsh(big.NewInt(1), 64),
+		"pow(1, 1000000)":    big.NewInt(1),
+		"pow(-1, 3)":         big.NewInt(-1),
+		"pow(2, 10) * 1024":  big.NewInt(1 << 20),
+		"pow(pow(2, 8), 16)": new(big.Int).Lsh(big.NewInt(1), 128),
+	}
+
+	for src, expected := range accepted {
+		e, err := Compile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := e.Eval(map[string]interface{}{})
+		if err != nil {
+			t.Errorf("Failed to evaluate %s: %s", src, err)
+			continue
+		}
+
+		if i, ok := result.(*big.Int); !ok || i.Cmp(expected) != 0 {
+			t.Errorf("Expected %s to be %s, got %v", src, expected, result)
+		}
+	}
+}
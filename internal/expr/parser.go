@@ -0,0 +1,374 @@
+package expr
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type node interface{}
+
+type literalNode struct {
+	val interface{}
+}
+
+type identNode struct {
+	name string
+}
+
+type memberNode struct {
+	target node
+	key    node
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+type ternaryNode struct {
+	cond node
+	then node
+	els  node
+}
+
+type listNode struct {
+	items []node
+}
+
+type mapNode struct {
+	keys   []node
+	values []node
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) (node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	n, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("Unexpected '%s' at %d", p.peek().val, p.peek().pos)
+	}
+
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.val == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return fmt.Errorf("Expected '%s' at the end of expression", op)
+		}
+		return fmt.Errorf("Expected '%s' at %d, got '%s'", op, t.pos, t.val)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parseTernary() (node, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.isOp("?") {
+		return cond, nil
+	}
+	p.next()
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expect(":")
+	if err != nil {
+		return nil, err
+	}
+
+	els, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	return ternaryNode{cond: cond, then: then, els: els}, nil
+}
+
+// precedence lists binary operators from the loosest to the tightest binding
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp(precedence[level]...) {
+		op := p.next().val
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!", "-") {
+		op := p.next().val
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: op, operand: operand}, nil
+	}
+
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			t := p.next()
+			if t.kind != tokIdent && t.kind != tokInt {
+				return nil, fmt.Errorf("Expected name after '.' at %d", t.pos)
+			}
+			n = memberNode{target: n, key: literalNode{val: t.val}}
+		case p.isOp("["):
+			p.next()
+			key, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect("]")
+			if err != nil {
+				return nil, err
+			}
+			n = memberNode{target: n, key: key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokInt:
+		base := 10
+		if strings.HasPrefix(t.val, "0x") || strings.HasPrefix(t.val, "0X") {
+			base = 0
+		}
+		val, ok := new(big.Int).SetString(t.val, base)
+		if !ok {
+			return nil, fmt.Errorf("Invalid number '%s' at %d", t.val, t.pos)
+		}
+		return literalNode{val: val}, nil
+
+	case tokFloat:
+		val, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number '%s' at %d", t.val, t.pos)
+		}
+		return literalNode{val: val}, nil
+
+	case tokString:
+		return literalNode{val: t.val}, nil
+
+	case tokIdent:
+		switch t.val {
+		case "true":
+			return literalNode{val: true}, nil
+		case "false":
+			return literalNode{val: false}, nil
+		case "null", "nil":
+			return literalNode{val: nil}, nil
+		}
+
+		if p.isOp("(") {
+			p.next()
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			return callNode{name: t.val, args: args}, nil
+		}
+
+		return identNode{name: t.val}, nil
+
+	case tokOp:
+		switch t.val {
+		case "(":
+			n, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(")")
+			if err != nil {
+				return nil, err
+			}
+			return n, nil
+
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return listNode{items: items}, nil
+
+		case "{":
+			return p.parseMap()
+		}
+	}
+
+	if t.kind == tokEOF {
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+
+	return nil, fmt.Errorf("Unexpected '%s' at %d", t.val, t.pos)
+}
+
+func (p *parser) parseList(end string) ([]node, error) {
+	items := make([]node, 0)
+	if p.isOp(end) {
+		p.next()
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if p.isOp(",") {
+			p.next()
+			continue
+		}
+
+		err = p.expect(end)
+		if err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+}
+
+func (p *parser) parseMap() (node, error) {
+	m := mapNode{}
+	if p.isOp("}") {
+		p.next()
+		return m, nil
+	}
+
+	for {
+		var key node
+		t := p.peek()
+		switch t.kind {
+		case tokIdent, tokInt:
+			p.next()
+			key = literalNode{val: t.val}
+		default:
+			var err error
+			key, err = p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+
+		m.keys = append(m.keys, key)
+		m.values = append(m.values, val)
+
+		if p.isOp(",") {
+			p.next()
+			continue
+		}
+
+		err = p.expect("}")
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
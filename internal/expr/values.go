@@ -0,0 +1,417 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxSafeFloat is the largest integer a float64 represents exactly
+const maxSafeFloat = 1 << 53
+
+// normalize converts values coming from params and results to the types the
+// evaluator works with
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil, bool, string, *big.Int, []interface{}, map[string]interface{}:
+		return v
+	case big.Int:
+		return new(big.Int).Set(&v)
+	case json.Number:
+		if i, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return i
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return normalize(f)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < maxSafeFloat {
+			return big.NewInt(int64(v))
+		}
+		return v
+	case float32:
+		return normalize(float64(v))
+	case int:
+		return big.NewInt(int64(v))
+	case int8:
+		return big.NewInt(int64(v))
+	case int16:
+		return big.NewInt(int64(v))
+	case int32:
+		return big.NewInt(int64(v))
+	case int64:
+		return big.NewInt(v)
+	case uint:
+		return new(big.Int).SetUint64(uint64(v))
+	case uint8:
+		return new(big.Int).SetUint64(uint64(v))
+	case uint16:
+		return new(big.Int).SetUint64(uint64(v))
+	case uint32:
+		return new(big.Int).SetUint64(uint64(v))
+	case uint64:
+		return new(big.Int).SetUint64(v)
+	}
+
+	// Anything else (structs, typed maps and slices) goes through JSON
+	b, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+
+	var generic interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err = d.Decode(&generic)
+	if err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+
+	return normalize(generic)
+}
+
+// Truthy follows the usual scripting rules: null, false, 0, "" and empty
+// collections are false
+func Truthy(val interface{}) bool {
+	switch v := normalize(val).(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return len(v) > 0
+	case *big.Int:
+		return v.Sign() != 0
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+
+	return true
+}
+
+// ToString formats the value the way it would be rendered into metadata
+func ToString(val interface{}) string {
+	switch v := normalize(val).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case *big.Int:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+
+	return string(b)
+}
+
+// ToNumber returns *big.Int or float64 for numbers and numeric strings
+func ToNumber(val interface{}) (interface{}, bool) {
+	// Floats computed by the expression itself stay floats, normalize only
+	// turns integral floats coming from JSON into integers
+	if f, ok := val.(float64); ok {
+		return f, true
+	}
+
+	switch v := normalize(val).(type) {
+	case *big.Int:
+		return v, true
+	case float64:
+		return v, true
+	case string:
+		s := strings.TrimSpace(v)
+		if len(s) == 0 {
+			return nil, false
+		}
+		base := 10
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			base = 0
+		}
+		if i, ok := new(big.Int).SetString(s, base); ok {
+			return i, true
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, false
+		}
+		return f, true
+	}
+
+	return nil, false
+}
+
+func toInt(val interface{}) (int64, bool) {
+	n, ok := ToNumber(val)
+	if !ok {
+		return 0, false
+	}
+
+	switch v := n.(type) {
+	case *big.Int:
+		if !v.IsInt64() {
+			return 0, false
+		}
+		return v.Int64(), true
+	case float64:
+		return int64(v), true
+	}
+
+	return 0, false
+}
+
+func toFloat(val interface{}) float64 {
+	switch v := val.(type) {
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f
+	case float64:
+		return v
+	}
+
+	return 0
+}
+
+func negate(val interface{}) (interface{}, error) {
+	n, ok := ToNumber(val)
+	if !ok {
+		return nil, fmt.Errorf("Cannot negate %s", typeName(val))
+	}
+
+	switch v := n.(type) {
+	case *big.Int:
+		return new(big.Int).Neg(v), nil
+	case float64:
+		return -v, nil
+	}
+
+	return nil, fmt.Errorf("Cannot negate %s", typeName(val))
+}
+
+func binary(op string, left interface{}, right interface{}) (interface{}, error) {
+	switch op {
+	case "==":
+		return Equal(left, right), nil
+	case "!=":
+		return !Equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		c, err := Compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "+":
+		return add(left, right)
+	case "-", "*", "/", "%":
+		return arithmetic(op, left, right)
+	}
+
+	return nil, fmt.Errorf("Unknown operator %s", op)
+}
+
+func add(left interface{}, right interface{}) (interface{}, error) {
+	left = normalize(left)
+	right = normalize(right)
+
+	if l, ok := left.([]interface{}); ok {
+		if r, ok := right.([]interface{}); ok {
+			return append(append(make([]interface{}, 0, len(l)+len(r)), l...), r...), nil
+		}
+	}
+
+	_, lString := left.(string)
+	_, rString := right.(string)
+
+	if lString && rString {
+		return ToString(left) + ToString(right), nil
+	}
+
+	if lString || rString {
+		_, lNum := ToNumber(left)
+		_, rNum := ToNumber(right)
+		if !lNum || !rNum {
+			return ToString(left) + ToString(right), nil
+		}
+	}
+
+	return arithmetic("+", left, right)
+}
+
+func arithmetic(op string, left interface{}, right interface{}) (interface{}, error) {
+	l, ok := ToNumber(left)
+	if !ok {
+		return nil, fmt.Errorf("Operator %s not supported for %s", op, typeName(left))
+	}
+	r, ok := ToNumber(right)
+	if !ok {
+		return nil, fmt.Errorf("Operator %s not supported for %s", op, typeName(right))
+	}
+
+	li, lInt := l.(*big.Int)
+	ri, rInt := r.(*big.Int)
+
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return checkBits(new(big.Int).Add(li, ri))
+		case "-":
+			return checkBits(new(big.Int).Sub(li, ri))
+		case "*":
+			if li.BitLen()+ri.BitLen() > maxIntBits+1 {
+				return nil, fmt.Errorf("Result larger than %d bits", maxIntBits)
+			}
+			return checkBits(new(big.Int).Mul(li, ri))
+		case "/":
+			if ri.Sign() == 0 {
+				return nil, fmt.Errorf("Division by zero")
+			}
+			return new(big.Int).Quo(li, ri), nil
+		case "%":
+			if ri.Sign() == 0 {
+				return nil, fmt.Errorf("Division by zero")
+			}
+			return new(big.Int).Rem(li, ri), nil
+		}
+	}
+
+	lf := toFloat(l)
+	rf := toFloat(r)
+
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+
+	return nil, fmt.Errorf("Unknown operator %s", op)
+}
+
+// checkBits fails for integers larger than maxIntBits
+func checkBits(i *big.Int) (interface{}, error) {
+	if i.BitLen() > maxIntBits {
+		return nil, fmt.Errorf("Result larger than %d bits", maxIntBits)
+	}
+
+	return i, nil
+}
+
+// Compare orders numbers (including numeric strings) numerically and other
+// strings lexicographically
+func Compare(left interface{}, right interface{}) (int, error) {
+	l, lOk := ToNumber(left)
+	r, rOk := ToNumber(right)
+
+	if lOk && rOk {
+		li, lInt := l.(*big.Int)
+		ri, rInt := r.(*big.Int)
+		if lInt && rInt {
+			return li.Cmp(ri), nil
+		}
+
+		lf := toFloat(l)
+		rf := toFloat(r)
+		switch {
+		case lf < rf:
+			return -1, nil
+		case lf > rf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	ls, lString := normalize(left).(string)
+	rs, rString := normalize(right).(string)
+	if lString && rString {
+		return strings.Compare(ls, rs), nil
+	}
+
+	return 0, fmt.Errorf("Cannot compare %s and %s", typeName(left), typeName(right))
+}
+
+func Equal(left interface{}, right interface{}) bool {
+	left = normalize(left)
+	right = normalize(right)
+
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+
+	_, lString := left.(string)
+	_, rString := right.(string)
+
+	// Numeric comparison as long as at least one side is an actual number, so
+	// `id == 1` matches the "1" coming from the URL
+	if !lString || !rString {
+		_, lNum := ToNumber(left)
+		_, rNum := ToNumber(right)
+		if lNum && rNum {
+			c, err := Compare(left, right)
+			return err == nil && c == 0
+		}
+	}
+
+	switch l := left.(type) {
+	case string, bool:
+		return l == right
+	}
+
+	return reflect.DeepEqual(left, right)
+}
+
+func typeName(val interface{}) string {
+	switch normalize(val).(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case *big.Int, float64:
+		return "number"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+
+	return fmt.Sprintf("%T", val)
+}
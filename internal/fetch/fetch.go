@@ -0,0 +1,126 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+var ErrPrivateAddress = fmt.Errorf("Private and loopback addresses cannot be reached")
+
+var client = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: NewTransport(),
+}
+
+// NewTransport returns a transport refusing private and loopback addresses.
+// The address is checked on every connection, so neither redirects nor DNS
+// answers changing between lookups reach the internal network.
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+
+				ip := net.ParseIP(host)
+				if ip == nil || !publicIP(ip) {
+					return ErrPrivateAddress
+				}
+
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// CheckHost fails if the host resolves to a private or loopback address. It
+// only catches mistakes early, connections are checked by NewTransport.
+func CheckHost(ctx context.Context, host string) error {
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !publicIP(ip.IP) {
+			return ErrPrivateAddress
+		}
+	}
+
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// Open returns a reader for an ipfs:// or http(s):// URI. The caller has to
+// close it. HTTP hosts resolving to private or loopback addresses are refused.
+func Open(ctx context.Context, ipfsClient *shell.Shell, uri string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(uri, "ipfs://"):
+		if ipfsClient == nil {
+			return nil, fmt.Errorf("IPFS client not available for %s", uri)
+		}
+		resp, err := ipfsClient.Request("cat", strings.TrimPrefix(uri, "ipfs://")).Send(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			resp.Close()
+			return nil, resp.Error
+		}
+		return resp.Output, nil
+
+	case strings.HasPrefix(uri, "http://"), strings.HasPrefix(uri, "https://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Failed to fetch %s: %s", uri, resp.Status)
+		}
+		return resp.Body, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported URI %s", uri)
+}
+
+// ReadAll reads at most limit bytes from the URI and fails if there is more
+func ReadAll(ctx context.Context, ipfsClient *shell.Shell, uri string, limit int64) ([]byte, error) {
+	r, err := Open(ctx, ipfsClient, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", uri, limit)
+	}
+
+	return data, nil
+}
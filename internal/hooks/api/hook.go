@@ -1,34 +1,102 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/metaconflux/backend/internal/fetch"
 	"github.com/metaconflux/backend/internal/hooks"
 	"github.com/metaconflux/backend/internal/template"
 	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
 )
 
 const TYPE = "api"
 
+const (
+	DEFAULT_METHOD  = http.MethodPost
+	DEFAULT_TIMEOUT = 10 * time.Second
+	DEFAULT_RETRIES = 3
+	DEFAULT_BACKOFF = time.Second
+	MAX_RETRIES     = 10
+	MAX_BACKOFF     = 30 * time.Second
+	MAX_TIMEOUT     = time.Minute
+	LOOKUP_TIMEOUT  = 5 * time.Second
+)
+
 type HookApi struct {
 	hooks.IHook
-	spec Spec
+	spec   Spec
+	client *http.Client
 }
 
 type Spec struct {
 	Method string `json:"method"`
 	Target string `json:"target" template:""`
-	Status int    `json:"status"`
+	// Status is the expected response status, any 2xx is accepted if not set
+	Status  int            `json:"status"`
+	Timeout utils.Duration `json:"timeout,omitempty"`
+	Retries *int           `json:"retries,omitempty"`
+	Backoff utils.Duration `json:"backoff,omitempty"`
 }
 
+// NewHook returns the hook, targets on private or loopback addresses are
+// refused on every connection, so hooks cannot reach internal services
 func NewHook() *HookApi {
-	return &HookApi{}
+	return &HookApi{
+		client: &http.Client{Transport: fetch.NewTransport()},
+	}
+}
+
+// Validate refuses targets which are not http(s) URLs or which resolve to a
+// private or loopback address. Templated hosts are only checked when sending.
+func (h *HookApi) Validate(spec interface{}) error {
+	var s Spec
+	err := utils.Remarshal(spec, &s)
+	if err != nil {
+		return err
+	}
+
+	target, err := url.Parse(s.Target)
+	if err != nil {
+		if strings.Contains(s.Target, "{{") {
+			return nil
+		}
+		return fmt.Errorf("Invalid target %s: %s", s.Target, err)
+	}
+
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("Target %s is not an http(s) URL", s.Target)
+	}
+
+	host := target.Hostname()
+	if strings.Contains(host, "{{") {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LOOKUP_TIMEOUT)
+	defer cancel()
+
+	err = fetch.CheckHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("Target %s: %s", s.Target, err)
+	}
+
+	return nil
 }
 
 func (h *HookApi) WithSpec(spec interface{}, params map[string]interface{}) (hooks.IHook, error) {
 	hook := NewHook()
+	hook.client = h.client
+
 	specTmp := Spec{}
 	err := utils.Remarshal(spec, &specTmp)
 	if err != nil {
@@ -40,26 +108,114 @@ func (h *HookApi) WithSpec(spec interface{}, params map[string]interface{}) (hoo
 		return nil, err
 	}
 
+	if len(hook.spec.Method) == 0 {
+		hook.spec.Method = DEFAULT_METHOD
+	}
+
+	if hook.spec.Timeout <= 0 || time.Duration(hook.spec.Timeout) > MAX_TIMEOUT {
+		hook.spec.Timeout = utils.Duration(DEFAULT_TIMEOUT)
+	}
+
+	if hook.spec.Retries == nil || *hook.spec.Retries < 0 || *hook.spec.Retries > MAX_RETRIES {
+		retries := DEFAULT_RETRIES
+		hook.spec.Retries = &retries
+	}
+
+	if hook.spec.Backoff <= 0 {
+		hook.spec.Backoff = utils.Duration(DEFAULT_BACKOFF)
+	}
+
 	return hook, nil
 }
 
-func (h *HookApi) Execute() error {
-	log.Println("Trying hook ", h.spec)
-	req, err := http.NewRequest(h.spec.Method, h.spec.Target, nil)
+// Execute sends the payload and retries with exponential backoff on network
+// errors, 429 and 5xx responses
+func (h *HookApi) Execute(ctx context.Context, payload hooks.Payload, secret string) ([]hooks.Attempt, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	attempts := make([]hooks.Attempt, 0)
+	backoff := time.Duration(h.spec.Backoff)
+
+	for i := 0; i <= *h.spec.Retries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return attempts, ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > MAX_BACKOFF {
+				backoff = MAX_BACKOFF
+			}
+		}
+
+		attempt, retry := h.send(ctx, payload, body, secret)
+		attempts = append(attempts, attempt)
+
+		logrus.Debugf("Hook %s %s attempt %d: status %d %s", h.spec.Method, h.spec.Target, i+1, attempt.Status, attempt.Error)
+
+		if len(attempt.Error) == 0 {
+			return attempts, nil
+		}
+
+		if !retry {
+			break
+		}
+	}
+
+	return attempts, fmt.Errorf("Hook %s %s failed after %d attempts: %s", h.spec.Method, h.spec.Target, len(attempts), attempts[len(attempts)-1].Error)
+}
+
+func (h *HookApi) send(ctx context.Context, payload hooks.Payload, body []byte, secret string) (hooks.Attempt, bool) {
+	attempt := hooks.Attempt{
+		Time: time.Now(),
 	}
 
-	client := &http.Client{}
-	response, err := client.Do(req)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.spec.Timeout))
+	defer cancel()
+
+	var reader io.Reader
+	if h.spec.Method != http.MethodGet && h.spec.Method != http.MethodHead {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, h.spec.Method, h.spec.Target, reader)
 	if err != nil {
-		return err
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(hooks.HEADER_DELIVERY, payload.ID)
+	req.Header.Set(hooks.HEADER_TIMESTAMP, fmt.Sprintf("%d", payload.Timestamp))
+	if len(secret) > 0 {
+		req.Header.Set(hooks.HEADER_SIGNATURE, hooks.Sign(secret, payload.Timestamp, body))
+	}
+
+	response, err := h.client.Do(req)
+	attempt.Duration = time.Since(attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+		// A refused address stays refused
+		return attempt, !errors.Is(err, fetch.ErrPrivateAddress)
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != h.spec.Status {
-		return fmt.Errorf("Unexpected response status %d", response.StatusCode)
+	attempt.Status = response.StatusCode
+
+	if h.spec.Status > 0 && response.StatusCode == h.spec.Status {
+		return attempt, false
 	}
 
-	return nil
+	if h.spec.Status == 0 && response.StatusCode >= 200 && response.StatusCode < 300 {
+		return attempt, false
+	}
+
+	attempt.Error = fmt.Sprintf("Unexpected response status %d", response.StatusCode)
+
+	return attempt, response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
 }
@@ -0,0 +1,397 @@
+// Package eip4906 implements a hook which asks the collection contract to emit
+// EIP-4906 MetadataUpdate/BatchMetadataUpdate events, so marketplaces refresh
+// the tokens whose metadata changed.
+package eip4906
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lmittmann/w3"
+	"github.com/metaconflux/backend/internal/hooks"
+	"github.com/metaconflux/backend/internal/template"
+	"github.com/metaconflux/backend/internal/utils"
+)
+
+const TYPE = "eip4906"
+
+const (
+	DEFAULT_MAX_BATCH     = 100
+	DEFAULT_WINDOW        = 2 * time.Second
+	MAX_WINDOW            = time.Minute
+	DEFAULT_MAX_GAS_LIMIT = 500000
+	// FLUSH_TIMEOUT plus MAX_WINDOW has to stay below the lease of the
+	// outbox, otherwise deliveries waiting in a batch are claimed again
+	FLUSH_TIMEOUT = 3 * time.Minute
+)
+
+// FUNCTIONS and BATCH_FUNCTIONS are the updater functions a manifest can
+// choose from. Nothing else is ever called with the server key, so a manifest
+// cannot reach privileged functions of contracts the key has a role on.
+var (
+	FUNCTIONS       = []string{"refreshMetadata(uint256)"}
+	BATCH_FUNCTIONS = []string{"emitBatchMetadataUpdate(uint256,uint256)"}
+)
+
+// Hook batches the token ids of the events it receives and calls the updater
+// function of the collection contract, one transaction per contiguous range
+// of ids. The contract and chain always come from the event, so a manifest
+// can only trigger updates on its own collection, and only if the contract
+// trusts the server key. Events without a token, first results and results
+// whose CID did not change are ignored.
+type Hook struct {
+	hooks.IHook
+	spec    Spec
+	senders map[int64]*sender
+	batcher *batcher
+	limiter *limiter
+}
+
+type Spec struct {
+	// Function takes a single token id, one of FUNCTIONS, the first one by
+	// default
+	Function string `json:"function,omitempty"`
+	// BatchFunction takes an inclusive range of token ids, one of
+	// BATCH_FUNCTIONS
+	BatchFunction string         `json:"batchFunction,omitempty"`
+	GasLimit      uint64         `json:"gasLimit,omitempty"`
+	MaxBatch      int            `json:"maxBatch,omitempty"`
+	Window        utils.Duration `json:"window,omitempty"`
+}
+
+func NewHook(key *ecdsa.PrivateKey, backends map[int64]bind.ContractBackend, limits Limits) *Hook {
+	if limits.MaxGasLimit == 0 {
+		limits.MaxGasLimit = DEFAULT_MAX_GAS_LIMIT
+	}
+
+	senders := make(map[int64]*sender)
+	for chainId, backend := range backends {
+		senders[chainId] = newSender(backend, chainId, key, limits.MaxGasLimit)
+	}
+
+	h := &Hook{
+		senders: senders,
+		limiter: newLimiter(limits),
+	}
+	h.batcher = newBatcher(h.flush)
+
+	return h
+}
+
+func (h *Hook) WithSpec(spec interface{}, params map[string]interface{}) (hooks.IHook, error) {
+	hook := &Hook{
+		senders: h.senders,
+		batcher: h.batcher,
+		limiter: h.limiter,
+	}
+
+	specTmp := Spec{}
+	err := utils.Remarshal(spec, &specTmp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = template.Template(&specTmp, &hook.spec, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hook.spec.Function) == 0 {
+		hook.spec.Function = FUNCTIONS[0]
+	}
+
+	if !allowed(hook.spec.Function, FUNCTIONS) {
+		return nil, fmt.Errorf("Function %s is not allowed, use one of %s", hook.spec.Function, strings.Join(FUNCTIONS, ", "))
+	}
+
+	if len(hook.spec.BatchFunction) > 0 && !allowed(hook.spec.BatchFunction, BATCH_FUNCTIONS) {
+		return nil, fmt.Errorf("Batch function %s is not allowed, use one of %s", hook.spec.BatchFunction, strings.Join(BATCH_FUNCTIONS, ", "))
+	}
+
+	if hook.spec.MaxBatch <= 0 {
+		hook.spec.MaxBatch = DEFAULT_MAX_BATCH
+	}
+
+	if hook.spec.Window <= 0 || time.Duration(hook.spec.Window) > MAX_WINDOW {
+		hook.spec.Window = utils.Duration(DEFAULT_WINDOW)
+	}
+
+	return hook, nil
+}
+
+// Execute waits for the batch of the token to be sent, the outbox uses
+// ExecuteAsync instead so its workers are not blocked for the window
+func (h *Hook) Execute(ctx context.Context, payload hooks.Payload, secret string) ([]hooks.Attempt, error) {
+	type result struct {
+		attempts []hooks.Attempt
+		err      error
+	}
+
+	done := make(chan result, 1)
+	h.ExecuteAsync(ctx, payload, secret, func(attempts []hooks.Attempt, err error) {
+		done <- result{attempts: attempts, err: err}
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.attempts, r.err
+	}
+}
+
+// ExecuteAsync adds the token to the pending batch of its collection, done
+// is called once the batch was sent
+func (h *Hook) ExecuteAsync(ctx context.Context, payload hooks.Payload, secret string, done func([]hooks.Attempt, error)) {
+	// A first result is not an update, marketplaces have nothing cached yet
+	if len(payload.TokenID) == 0 || len(payload.PreviousCID) == 0 || payload.PreviousCID == payload.CID {
+		done(nil, nil)
+		return
+	}
+
+	chainId, err := strconv.ParseInt(payload.ChainID, 10, 64)
+	if err != nil {
+		done(nil, fmt.Errorf("Invalid chain id %s", payload.ChainID))
+		return
+	}
+
+	if _, ok := h.senders[chainId]; !ok {
+		done(nil, fmt.Errorf("Chain %d is not configured for %s hooks", chainId, TYPE))
+		return
+	}
+
+	if !common.IsHexAddress(payload.Contract) {
+		done(nil, fmt.Errorf("Invalid contract address %s", payload.Contract))
+		return
+	}
+
+	tokenId, ok := new(big.Int).SetString(payload.TokenID, 0)
+	if !ok {
+		done(nil, fmt.Errorf("Invalid token id %s", payload.TokenID))
+		return
+	}
+
+	key := batchKey{
+		chainId:       chainId,
+		contract:      common.HexToAddress(payload.Contract),
+		function:      h.spec.Function,
+		batchFunction: h.spec.BatchFunction,
+		gasLimit:      h.spec.GasLimit,
+	}
+
+	attempt := hooks.Attempt{
+		Time: time.Now(),
+	}
+
+	h.batcher.add(key, tokenId, h.spec.MaxBatch, time.Duration(h.spec.Window), func(r batchResult) {
+		attempt.Duration = time.Since(attempt.Time)
+		attempt.Reference = strings.Join(r.hashes, ",")
+		if r.err != nil {
+			attempt.Error = r.err.Error()
+		}
+		done([]hooks.Attempt{attempt}, r.err)
+	})
+}
+
+// flush sends the transactions for a batch of token ids
+func (h *Hook) flush(key batchKey, tokenIds []*big.Int) ([]string, error) {
+	// Plenty of time for a batch split into many ranges, but never stuck
+	ctx, cancel := context.WithTimeout(context.Background(), FLUSH_TIMEOUT)
+	defer cancel()
+
+	s := h.senders[key.chainId]
+
+	err := s.trusted(ctx, key.contract)
+	if err != nil {
+		return nil, err
+	}
+
+	reserve := func(gas uint64) error {
+		return h.limiter.reserve(key.chainId, key.contract, gas)
+	}
+
+	single, err := parseFunc(key.function, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch *w3.Func
+	if len(key.batchFunction) > 0 {
+		batch, err = parseFunc(key.batchFunction, 2)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hashes := make([]string, 0)
+	for _, r := range ranges(tokenIds) {
+		calls := make([][]byte, 0)
+		if batch != nil && r[0].Cmp(r[1]) != 0 {
+			data, err := batch.EncodeArgs(r[0], r[1])
+			if err != nil {
+				return hashes, err
+			}
+			calls = append(calls, data)
+		} else {
+			for id := new(big.Int).Set(r[0]); id.Cmp(r[1]) <= 0; id.Add(id, big.NewInt(1)) {
+				data, err := single.EncodeArgs(new(big.Int).Set(id))
+				if err != nil {
+					return hashes, err
+				}
+				calls = append(calls, data)
+			}
+		}
+
+		for _, data := range calls {
+			hash, err := s.send(ctx, key.contract, data, key.gasLimit, reserve)
+			if err != nil {
+				return hashes, err
+			}
+			hashes = append(hashes, hash.Hex())
+		}
+	}
+
+	return hashes, nil
+}
+
+// ranges sorts and deduplicates the ids and groups them into inclusive
+// ranges of consecutive ids
+func ranges(tokenIds []*big.Int) [][2]*big.Int {
+	sorted := make([]*big.Int, len(tokenIds))
+	copy(sorted, tokenIds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+
+	result := make([][2]*big.Int, 0)
+	for _, id := range sorted {
+		if len(result) > 0 {
+			last := &result[len(result)-1]
+			if id.Cmp(last[1]) == 0 {
+				continue
+			}
+			if new(big.Int).Add(last[1], big.NewInt(1)).Cmp(id) == 0 {
+				last[1] = id
+				continue
+			}
+		}
+		result = append(result, [2]*big.Int{id, id})
+	}
+
+	return result
+}
+
+func allowed(signature string, functions []string) bool {
+	for _, f := range functions {
+		if f == signature {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseFunc(signature string, args int) (*w3.Func, error) {
+	if len(signature) == 0 {
+		return nil, fmt.Errorf("Function signature is required")
+	}
+
+	f, err := w3.NewFunc(signature, "")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid function %s: %s", signature, err)
+	}
+
+	if len(f.Args) != args {
+		return nil, fmt.Errorf("Function %s has to take %d uint256 arguments", signature, args)
+	}
+
+	for _, arg := range f.Args {
+		if arg.Type.String() != "uint256" {
+			return nil, fmt.Errorf("Function %s has to take %d uint256 arguments", signature, args)
+		}
+	}
+
+	return f, nil
+}
+
+type batchKey struct {
+	chainId       int64
+	contract      common.Address
+	function      string
+	batchFunction string
+	gasLimit      uint64
+}
+
+type batchResult struct {
+	hashes []string
+	err    error
+}
+
+type pendingBatch struct {
+	tokenIds []*big.Int
+	waiters  []func(batchResult)
+	timer    *time.Timer
+}
+
+// batcher collects token ids per key until the batch is full or the window
+// passes and then flushes them at once, every caller gets the result
+type batcher struct {
+	mu      sync.Mutex
+	pending map[batchKey]*pendingBatch
+	flush   func(key batchKey, tokenIds []*big.Int) ([]string, error)
+}
+
+func newBatcher(flush func(key batchKey, tokenIds []*big.Int) ([]string, error)) *batcher {
+	return &batcher{
+		pending: make(map[batchKey]*pendingBatch),
+		flush:   flush,
+	}
+}
+
+// add queues the token id, done is called with the result of the flush
+func (b *batcher) add(key batchKey, tokenId *big.Int, maxBatch int, window time.Duration, done func(batchResult)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := b.pending[key]
+	if !ok {
+		p = &pendingBatch{}
+		p.timer = time.AfterFunc(window, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.take(key, p)
+		})
+		b.pending[key] = p
+	}
+
+	p.tokenIds = append(p.tokenIds, tokenId)
+	p.waiters = append(p.waiters, done)
+
+	if len(p.tokenIds) >= maxBatch {
+		p.timer.Stop()
+		b.take(key, p)
+	}
+}
+
+// take removes the batch and flushes it in the background, b.mu has to be
+// held
+func (b *batcher) take(key batchKey, p *pendingBatch) {
+	if b.pending[key] != p {
+		return
+	}
+	delete(b.pending, key)
+
+	go func() {
+		hashes, err := b.flush(key, p.tokenIds)
+		for _, done := range p.waiters {
+			done(batchResult{hashes: hashes, err: err})
+		}
+	}()
+}
@@ -0,0 +1,205 @@
+package eip4906
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metaconflux/backend/internal/hooks"
+)
+
+// Runtime code answering every call with a single word, 1 makes hasRole
+// return true, 0 makes both hasRole and owner fail the trust check
+var (
+	trustingCode   = common.FromHex("0x600160005260206000f3")
+	untrustingCode = common.FromHex("0x600060005260206000f3")
+)
+
+var (
+	trustingContract   = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	untrustingContract = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+)
+
+const simulatedChainId = 1337
+
+func newSimulatedHook(t *testing.T, limits Limits) (*Hook, *backends.SimulatedBackend) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)},
+		trustingContract:                      {Code: trustingCode, Balance: big.NewInt(0)},
+		untrustingContract:                    {Code: untrustingCode, Balance: big.NewInt(0)},
+	}, 30000000)
+	t.Cleanup(func() { sim.Close() })
+
+	return NewHook(key, map[int64]bind.ContractBackend{simulatedChainId: sim}, limits), sim
+}
+
+// executeAll executes the hook for the token ids concurrently, the way the
+// outbox does, and returns the error of every delivery
+func executeAll(t *testing.T, h hooks.IHook, contract common.Address, tokenIds []int) []error {
+	return executeEvents(t, h, contract, tokenIds, hooks.EVENT_TOKEN_CHANGED, "old")
+}
+
+func executeEvents(t *testing.T, h hooks.IHook, contract common.Address, tokenIds []int, eventType string, previousCID string) []error {
+	errs := make([]error, len(tokenIds))
+
+	var wg sync.WaitGroup
+	for i, id := range tokenIds {
+		wg.Add(1)
+		payload := hooks.Payload{Event: hooks.Event{
+			Type:        eventType,
+			ChainID:     fmt.Sprintf("%d", simulatedChainId),
+			Contract:    contract.Hex(),
+			TokenID:     fmt.Sprintf("%d", id),
+			CID:         "new",
+			PreviousCID: previousCID,
+		}}
+
+		i := i
+		h.(hooks.IAsyncHook).ExecuteAsync(context.Background(), payload, "", func(attempts []hooks.Attempt, err error) {
+			errs[i] = err
+			wg.Done()
+		})
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func TestBatchSendsSingleTransaction(t *testing.T) {
+	hook, sim := newSimulatedHook(t, Limits{})
+
+	h, err := hook.WithSpec(map[string]interface{}{
+		"batchFunction": BATCH_FUNCTIONS[0],
+		"maxBatch":      10,
+		"window":        "10s",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int{3, 1, 2, 4, 5, 6, 7, 8, 9, 10}
+	for i, err := range executeAll(t, h, trustingContract, ids) {
+		if err != nil {
+			t.Fatalf("Delivery of token %d failed: %s", ids[i], err)
+		}
+	}
+
+	sim.Commit()
+
+	txs := sim.Blockchain().CurrentBlock().Transactions()
+	if len(txs) != 1 {
+		t.Fatalf("Expected a single batch transaction, got %d", len(txs))
+	}
+
+	batch, err := parseFunc(BATCH_FUNCTIONS[0], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := batch.EncodeArgs(big.NewInt(1), big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *txs[0].To() != trustingContract {
+		t.Errorf("Expected the transaction to be sent to %s, got %s", trustingContract, txs[0].To())
+	}
+
+	if common.Bytes2Hex(txs[0].Data()) != common.Bytes2Hex(expected) {
+		t.Errorf("Expected call data %x, got %x", expected, txs[0].Data())
+	}
+}
+
+func TestUnchangedResultsSkipped(t *testing.T) {
+	hook, sim := newSimulatedHook(t, Limits{})
+
+	h, err := hook.WithSpec(map[string]interface{}{"maxBatch": 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A first result and a result generated again with the same CID
+	for _, previousCID := range []string{"", "new"} {
+		for _, err := range executeEvents(t, h, trustingContract, []int{1, 2}, hooks.EVENT_TOKEN_GENERATED, previousCID) {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	sim.Commit()
+
+	if txs := sim.Blockchain().CurrentBlock().Transactions(); len(txs) != 0 {
+		t.Fatalf("Expected no transaction, got %d", len(txs))
+	}
+}
+
+func TestUntrustedContract(t *testing.T) {
+	hook, sim := newSimulatedHook(t, Limits{})
+
+	h, err := hook.WithSpec(map[string]interface{}{"maxBatch": 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range executeAll(t, h, untrustingContract, []int{1, 2}) {
+		if err == nil {
+			t.Fatal("Expected the delivery to fail for a contract not trusting the key")
+		}
+	}
+
+	sim.Commit()
+
+	if txs := sim.Blockchain().CurrentBlock().Transactions(); len(txs) != 0 {
+		t.Fatalf("Expected no transaction, got %d", len(txs))
+	}
+}
+
+func TestTransactionLimit(t *testing.T) {
+	hook, sim := newSimulatedHook(t, Limits{MaxTransactions: 2})
+
+	h, err := hook.WithSpec(map[string]interface{}{"maxBatch": 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ids 1, 3 and 5 are no range, so a transaction each
+	errs := executeAll(t, h, trustingContract, []int{1, 3, 5})
+	if errs[0] == nil {
+		t.Fatal("Expected the third transaction to exceed the limit")
+	}
+
+	sim.Commit()
+
+	if txs := sim.Blockchain().CurrentBlock().Transactions(); len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+}
+
+func TestFunctionAllowlist(t *testing.T) {
+	hook, _ := newSimulatedHook(t, Limits{})
+
+	specs := []map[string]interface{}{
+		{"function": "mint(uint256)"},
+		{"batchFunction": "burn(uint256,uint256)"},
+	}
+
+	for _, spec := range specs {
+		_, err := hook.WithSpec(spec, nil)
+		if err == nil {
+			t.Errorf("Expected %v to be rejected", spec)
+		}
+	}
+}
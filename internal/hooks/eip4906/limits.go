@@ -0,0 +1,100 @@
+package eip4906
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	DEFAULT_MAX_TRANSACTIONS = 100
+	DEFAULT_MAX_GAS          = 10000000
+	DEFAULT_PERIOD           = time.Hour
+)
+
+// Limits cap what the key spends, MaxGasLimit per transaction and
+// MaxTransactions and MaxGas per collection and Period
+type Limits struct {
+	MaxGasLimit     uint64
+	MaxTransactions int
+	MaxGas          uint64
+	Period          time.Duration
+}
+
+type limitKey struct {
+	chainId  int64
+	contract common.Address
+}
+
+type usage struct {
+	start        time.Time
+	transactions int
+	gas          uint64
+}
+
+// limiter counts the transactions and the gas limits of a collection in
+// fixed periods. Gas limits are counted rather than the gas used, which is
+// only known once the transaction is mined.
+type limiter struct {
+	mu     sync.Mutex
+	limits Limits
+	usage  map[limitKey]*usage
+}
+
+func newLimiter(limits Limits) *limiter {
+	if limits.MaxTransactions <= 0 {
+		limits.MaxTransactions = DEFAULT_MAX_TRANSACTIONS
+	}
+
+	if limits.MaxGas == 0 {
+		limits.MaxGas = DEFAULT_MAX_GAS
+	}
+
+	if limits.Period <= 0 {
+		limits.Period = DEFAULT_PERIOD
+	}
+
+	return &limiter{
+		limits: limits,
+		usage:  make(map[limitKey]*usage),
+	}
+}
+
+// reserve counts a transaction with the gas limit for the collection or
+// fails if that would exceed its limits. Transactions failing to send count
+// as well, so a broken collection cannot be retried endlessly.
+func (l *limiter) reserve(chainId int64, contract common.Address, gas uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	key := limitKey{chainId: chainId, contract: contract}
+
+	u, ok := l.usage[key]
+	if !ok || now.Sub(u.start) >= l.limits.Period {
+		// Drop the periods which are over, so the map does not grow
+		for k, u := range l.usage {
+			if now.Sub(u.start) >= l.limits.Period {
+				delete(l.usage, k)
+			}
+		}
+
+		u = &usage{start: now}
+		l.usage[key] = u
+	}
+
+	if u.transactions+1 > l.limits.MaxTransactions {
+		return fmt.Errorf("Collection %s on chain %d reached the limit of %d transactions per %s", contract, chainId, l.limits.MaxTransactions, l.limits.Period)
+	}
+
+	if u.gas+gas > l.limits.MaxGas {
+		return fmt.Errorf("Collection %s on chain %d reached the limit of %d gas per %s", contract, chainId, l.limits.MaxGas, l.limits.Period)
+	}
+
+	u.transactions++
+	u.gas += gas
+
+	return nil
+}
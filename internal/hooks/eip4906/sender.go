@@ -0,0 +1,213 @@
+package eip4906
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lmittmann/w3"
+	"github.com/sirupsen/logrus"
+)
+
+// LoadKey decrypts the private key from a keystore file
+func LoadKey(path string, password string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keystore.DecryptKey(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt keystore %s: %s", path, err)
+	}
+
+	return key.PrivateKey, nil
+}
+
+// TRUST_LIFETIME is how long a contract is known to trust the key before it
+// is checked again, so revoking the role takes effect eventually
+const TRUST_LIFETIME = 10 * time.Minute
+
+// UPDATER_ROLE is the AccessControl role a contract grants the server key to
+// let it emit metadata updates
+var UPDATER_ROLE = crypto.Keccak256Hash([]byte("METADATA_UPDATER_ROLE"))
+
+var (
+	ownerFunc   = w3.MustNewFunc("owner()", "address")
+	hasRoleFunc = w3.MustNewFunc("hasRole(bytes32,address)", "bool")
+)
+
+// sender signs and sends transactions for a single chain. Nonces are tracked
+// locally so transactions sent in quick succession do not collide, and are
+// reloaded from the node whenever sending fails.
+type sender struct {
+	mu          sync.Mutex
+	backend     bind.ContractBackend
+	chainId     *big.Int
+	key         *ecdsa.PrivateKey
+	from        common.Address
+	maxGasLimit uint64
+	nonce       *uint64
+	trust       map[common.Address]time.Time
+}
+
+func newSender(backend bind.ContractBackend, chainId int64, key *ecdsa.PrivateKey, maxGasLimit uint64) *sender {
+	return &sender{
+		backend:     backend,
+		chainId:     big.NewInt(chainId),
+		key:         key,
+		from:        crypto.PubkeyToAddress(key.PublicKey),
+		maxGasLimit: maxGasLimit,
+		trust:       make(map[common.Address]time.Time),
+	}
+}
+
+// trusted checks that the contract grants the key UPDATER_ROLE or that the
+// key owns it. Anyone can write a manifest for any contract, only the
+// contract itself can tell the key is meant to send to it.
+func (s *sender) trusted(ctx context.Context, contract common.Address) error {
+	s.mu.Lock()
+	until, ok := s.trust[contract]
+	s.mu.Unlock()
+	if ok && time.Now().Before(until) {
+		return nil
+	}
+
+	var hasRole bool
+	err := s.call(ctx, contract, hasRoleFunc, []interface{}{UPDATER_ROLE, s.from}, &hasRole)
+	if err != nil || !hasRole {
+		var owner common.Address
+		err = s.call(ctx, contract, ownerFunc, nil, &owner)
+		if err != nil || owner != s.from {
+			return fmt.Errorf("Contract %s neither grants %s the METADATA_UPDATER_ROLE nor is owned by it", contract, s.from)
+		}
+	}
+
+	s.mu.Lock()
+	s.trust[contract] = time.Now().Add(TRUST_LIFETIME)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *sender) call(ctx context.Context, contract common.Address, f *w3.Func, args []interface{}, result interface{}) error {
+	input, err := f.EncodeArgs(args...)
+	if err != nil {
+		return err
+	}
+
+	output, err := s.backend.CallContract(ctx, ethereum.CallMsg{From: s.from, To: &contract, Data: input}, nil)
+	if err != nil {
+		return err
+	}
+
+	return f.DecodeReturns(output, result)
+}
+
+// send signs and sends a transaction, reserve is called with its gas limit
+// right before and can refuse it
+func (s *sender) send(ctx context.Context, to common.Address, data []byte, gasLimit uint64, reserve func(gas uint64) error) (common.Hash, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nonce == nil {
+		nonce, err := s.backend.PendingNonceAt(ctx, s.from)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		s.nonce = &nonce
+	}
+
+	if gasLimit == 0 {
+		estimate, err := s.backend.EstimateGas(ctx, ethereum.CallMsg{From: s.from, To: &to, Data: data})
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("Failed to estimate gas: %s", err)
+		}
+		// Leave some room for state changes between estimation and inclusion
+		gasLimit = estimate * 12 / 10
+	}
+
+	if gasLimit > s.maxGasLimit {
+		return common.Hash{}, fmt.Errorf("Gas limit %d exceeds the maximum of %d", gasLimit, s.maxGasLimit)
+	}
+
+	err := reserve(gasLimit)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	txData, err := s.txData(ctx, to, data, gasLimit)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	tx, err := types.SignNewTx(s.key, types.LatestSignerForChainID(s.chainId), txData)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	err = s.backend.SendTransaction(ctx, tx)
+	if err != nil {
+		// The node knows better, e.g. after a restart or a transaction sent
+		// with the same key from elsewhere
+		s.nonce = nil
+		if strings.Contains(err.Error(), "nonce") {
+			logrus.Warnf("Resetting nonce of %s on chain %s: %s", s.from, s.chainId, err)
+		}
+		return common.Hash{}, err
+	}
+
+	*s.nonce++
+
+	return tx.Hash(), nil
+}
+
+func (s *sender) txData(ctx context.Context, to common.Address, data []byte, gasLimit uint64) (types.TxData, error) {
+	head, err := s.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if head.BaseFee == nil {
+		gasPrice, err := s.backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.LegacyTx{
+			Nonce:    *s.nonce,
+			GasPrice: gasPrice,
+			Gas:      gasLimit,
+			To:       &to,
+			Data:     data,
+		}, nil
+	}
+
+	tip, err := s.backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	feeCap := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+
+	return &types.DynamicFeeTx{
+		ChainID:   s.chainId,
+		Nonce:     *s.nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gasLimit,
+		To:        &to,
+		Data:      data,
+	}, nil
+}
@@ -0,0 +1,254 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/metaconflux/backend/internal/hooks"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_WORKERS      = 4
+	DEFAULT_MAX_ATTEMPTS = 8
+	POLL_INTERVAL        = 5 * time.Second
+	// LEASE has to be longer than a single delivery including the retries
+	// done by the hook itself, and the batching of asynchronous hooks
+	LEASE         = 5 * time.Minute
+	RETRY_BACKOFF = 30 * time.Second
+	MAX_BACKOFF   = time.Hour
+)
+
+// Dispatcher delivers hooks from the outbox at least once using a pool of
+// workers. Deliveries failing MaxAttempts times end up dead and have to be
+// redelivered manually.
+type Dispatcher struct {
+	store       IStore
+	hooks       hooks.HookManager
+	secrets     SecretProvider
+	workers     int
+	maxAttempts int
+	wake        chan struct{}
+}
+
+func NewDispatcher(store IStore, hooks hooks.HookManager, secrets SecretProvider, workers int, maxAttempts int) *Dispatcher {
+	if workers <= 0 {
+		workers = DEFAULT_WORKERS
+	}
+
+	if maxAttempts <= 0 {
+		maxAttempts = DEFAULT_MAX_ATTEMPTS
+	}
+
+	return &Dispatcher{
+		store:       store,
+		hooks:       hooks,
+		secrets:     secrets,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enqueue persists the delivery and wakes up the workers
+func (d *Dispatcher) Enqueue(ctx context.Context, delivery Delivery) (Delivery, error) {
+	delivery.Status = STATUS_PENDING
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+
+	delivery, err := d.store.Add(ctx, delivery)
+	if err != nil {
+		return delivery, err
+	}
+
+	d.notify()
+
+	return delivery, nil
+}
+
+func (d *Dispatcher) List(ctx context.Context, chainId int64, contract string, status string) ([]Delivery, error) {
+	return d.store.List(ctx, chainId, contract, status)
+}
+
+// Stats returns how many deliveries there are per status
+func (d *Dispatcher) Stats(ctx context.Context) (map[string]int64, error) {
+	return d.store.Count(ctx)
+}
+
+func (d *Dispatcher) Get(ctx context.Context, id uint) (Delivery, error) {
+	return d.store.Get(ctx, id)
+}
+
+// Redeliver resets a delivery (usually a dead one) so it is picked up again
+// with a fresh attempt budget
+func (d *Dispatcher) Redeliver(ctx context.Context, id uint) (Delivery, error) {
+	delivery, err := d.store.Get(ctx, id)
+	if err != nil {
+		return delivery, err
+	}
+
+	if delivery.Status == STATUS_DELIVERING && delivery.NextAttemptAt.After(time.Now()) {
+		return delivery, fmt.Errorf("Delivery %d is in progress", id)
+	}
+
+	delivery.Status = STATUS_PENDING
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+
+	err = d.store.Update(ctx, delivery)
+	if err != nil {
+		return delivery, err
+	}
+
+	d.notify()
+
+	return delivery, nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the poller and the workers until ctx is done. Deliveries left
+// in progress by a previous run are picked up once their lease expires.
+func (d *Dispatcher) Start(ctx context.Context) {
+	jobs := make(chan Delivery)
+
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range jobs {
+				d.deliver(ctx, delivery)
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+		}()
+
+		ticker := time.NewTicker(POLL_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			deliveries, err := d.store.Claim(ctx, d.workers, LEASE)
+			if err != nil {
+				logrus.Errorf("Failed to claim hook deliveries: %s", err)
+			}
+
+			for _, delivery := range deliveries {
+				select {
+				case jobs <- delivery:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			// A full batch means there is likely more waiting
+			if len(deliveries) == d.workers {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-d.wake:
+			}
+		}
+	}()
+}
+
+// deliver executes the hook of the delivery. Asynchronous hooks finish the
+// delivery later, so the worker is free for the next one meanwhile.
+func (d *Dispatcher) deliver(ctx context.Context, delivery Delivery) {
+	hook, secret, err := d.prepare(ctx, delivery)
+	if err != nil {
+		d.finish(delivery, nil, err)
+		return
+	}
+
+	if async, ok := hook.(hooks.IAsyncHook); ok {
+		async.ExecuteAsync(ctx, delivery.Payload, secret, func(attempts []hooks.Attempt, err error) {
+			d.finish(delivery, attempts, err)
+		})
+		return
+	}
+
+	attempts, err := hook.Execute(ctx, delivery.Payload, secret)
+	d.finish(delivery, attempts, err)
+}
+
+// finish records the attempts and schedules a retry if the delivery failed
+func (d *Dispatcher) finish(delivery Delivery, attempts []hooks.Attempt, err error) {
+	delivery.Attempts++
+	delivery.History = append(delivery.History, attempts...)
+	if len(delivery.History) > MAX_HISTORY {
+		delivery.History = delivery.History[len(delivery.History)-MAX_HISTORY:]
+	}
+
+	if err == nil {
+		delivery.Status = STATUS_DELIVERED
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.maxAttempts {
+			delivery.Status = STATUS_DEAD
+			logrus.Errorf("Hook delivery %d dead after %d attempts: %s", delivery.ID, delivery.Attempts, err)
+		} else {
+			delivery.Status = STATUS_PENDING
+			delivery.NextAttemptAt = time.Now().Add(backoff(delivery.Attempts))
+			logrus.Warnf("Hook delivery %d failed (attempt %d/%d), retrying at %s: %s", delivery.ID, delivery.Attempts, d.maxAttempts, delivery.NextAttemptAt, err)
+		}
+	}
+
+	// The update must not be lost on shutdown, otherwise the delivery is sent
+	// again after the lease expires
+	err = d.store.Update(context.Background(), delivery)
+	if err != nil {
+		logrus.Errorf("Failed to update hook delivery %d: %s", delivery.ID, err)
+	}
+}
+
+func (d *Dispatcher) prepare(ctx context.Context, delivery Delivery) (hooks.IHook, string, error) {
+	hook, err := d.hooks.Get(delivery.Type)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hook, err = hook.WithSpec(delivery.Spec, delivery.Params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var secret string
+	if d.secrets != nil {
+		secret, err = d.secrets(ctx, delivery.ChainID, delivery.Contract)
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to load hook secret: %s", err)
+		}
+	}
+
+	return hook, secret, nil
+}
+
+func backoff(attempts int) time.Duration {
+	b := RETRY_BACKOFF
+	for i := 1; i < attempts; i++ {
+		b *= 2
+		if b > MAX_BACKOFF {
+			return MAX_BACKOFF
+		}
+	}
+
+	return b
+}
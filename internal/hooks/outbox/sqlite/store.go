@@ -0,0 +1,272 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/metaconflux/backend/internal/hooks"
+	"github.com/metaconflux/backend/internal/hooks/outbox"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	outbox.IStore
+	db *gorm.DB
+}
+
+type DeliveryModel struct {
+	gorm.Model
+	ChainID       int64  `gorm:"index:idx_delivery_manifest"`
+	Contract      string `gorm:"index:idx_delivery_manifest"`
+	Type          string
+	Spec          string
+	Params        string
+	Payload       string
+	Status        string    `gorm:"index:idx_delivery_due"`
+	NextAttemptAt time.Time `gorm:"index:idx_delivery_due"`
+	Attempts      int
+	LastError     string
+	History       string
+}
+
+func NewStore(db *gorm.DB) (outbox.IStore, error) {
+	err := db.AutoMigrate(&DeliveryModel{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db: db,
+	}, nil
+}
+
+func (s *Store) Add(ctx context.Context, delivery outbox.Delivery) (outbox.Delivery, error) {
+	model, err := toModel(delivery)
+	if err != nil {
+		return delivery, err
+	}
+
+	result := s.db.WithContext(ctx).Create(&model)
+	if result.Error != nil {
+		return delivery, result.Error
+	}
+
+	return fromModel(model)
+}
+
+func (s *Store) Get(ctx context.Context, id uint) (outbox.Delivery, error) {
+	var model DeliveryModel
+	result := s.db.WithContext(ctx).First(&model, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return outbox.Delivery{}, outbox.ErrNotFound
+		}
+		return outbox.Delivery{}, result.Error
+	}
+
+	return fromModel(model)
+}
+
+func (s *Store) Claim(ctx context.Context, limit int, lease time.Duration) ([]outbox.Delivery, error) {
+	var models []DeliveryModel
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.
+			Where("status IN ? AND next_attempt_at <= ?", []string{outbox.STATUS_PENDING, outbox.STATUS_DELIVERING}, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&models)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if len(models) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(models))
+		for i := range models {
+			ids[i] = models[i].ID
+			models[i].Status = outbox.STATUS_DELIVERING
+			models[i].NextAttemptAt = now.Add(lease)
+		}
+
+		return tx.Model(&DeliveryModel{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":          outbox.STATUS_DELIVERING,
+				"next_attempt_at": now.Add(lease),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]outbox.Delivery, 0, len(models))
+	for _, m := range models {
+		d, err := fromModel(m)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Store) Update(ctx context.Context, delivery outbox.Delivery) error {
+	model, err := toModel(delivery)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.WithContext(ctx).Model(&DeliveryModel{}).Where("id = ?", delivery.ID).Updates(map[string]interface{}{
+		"status":          model.Status,
+		"next_attempt_at": model.NextAttemptAt,
+		"attempts":        model.Attempts,
+		"last_error":      model.LastError,
+		"history":         model.History,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return outbox.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) List(ctx context.Context, chainId int64, contract string, status string) ([]outbox.Delivery, error) {
+	var models []DeliveryModel
+
+	query := s.db.WithContext(ctx).Where("chain_id = ? AND contract = ?", chainId, contract)
+	if len(status) > 0 {
+		query = query.Where("status = ?", status)
+	}
+
+	result := query.Order("id desc").Limit(100).Find(&models)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	deliveries := make([]outbox.Delivery, 0, len(models))
+	for _, m := range models {
+		d, err := fromModel(m)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Store) Count(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+
+	result := s.db.WithContext(ctx).Model(&DeliveryModel{}).Select("status, count(*) as count").Group("status").Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	counts := map[string]int64{
+		outbox.STATUS_PENDING:    0,
+		outbox.STATUS_DELIVERING: 0,
+		outbox.STATUS_DELIVERED:  0,
+		outbox.STATUS_DEAD:       0,
+	}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	return counts, nil
+}
+
+func toModel(d outbox.Delivery) (DeliveryModel, error) {
+	model := DeliveryModel{
+		ChainID:       d.ChainID,
+		Contract:      d.Contract,
+		Type:          d.Type,
+		Status:        d.Status,
+		NextAttemptAt: d.NextAttemptAt,
+		Attempts:      d.Attempts,
+		LastError:     d.LastError,
+	}
+	model.ID = d.ID
+
+	fields := []struct {
+		in  interface{}
+		out *string
+	}{
+		{d.Spec, &model.Spec},
+		{d.Params, &model.Params},
+		{d.Payload, &model.Payload},
+		{d.History, &model.History},
+	}
+
+	for _, f := range fields {
+		b, err := json.Marshal(f.in)
+		if err != nil {
+			return model, err
+		}
+		*f.out = string(b)
+	}
+
+	return model, nil
+}
+
+func fromModel(m DeliveryModel) (outbox.Delivery, error) {
+	d := outbox.Delivery{
+		ID:            m.ID,
+		ChainID:       m.ChainID,
+		Contract:      m.Contract,
+		Type:          m.Type,
+		Status:        m.Status,
+		NextAttemptAt: m.NextAttemptAt,
+		Attempts:      m.Attempts,
+		LastError:     m.LastError,
+		CreatedAt:     m.CreatedAt,
+		UpdatedAt:     m.UpdatedAt,
+	}
+
+	var history []hooks.Attempt
+
+	fields := []struct {
+		in  string
+		out interface{}
+	}{
+		{m.Spec, &d.Spec},
+		{m.Params, &d.Params},
+		{m.Payload, &d.Payload},
+		{m.History, &history},
+	}
+
+	for _, f := range fields {
+		if len(f.in) == 0 {
+			continue
+		}
+		// Keep large token ids and amounts intact when payloads are resent
+		dec := json.NewDecoder(strings.NewReader(f.in))
+		dec.UseNumber()
+		err := dec.Decode(f.out)
+		if err != nil {
+			return d, err
+		}
+	}
+
+	d.History = history
+	if d.History == nil {
+		d.History = make([]hooks.Attempt, 0)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/metaconflux/backend/internal/hooks"
+)
+
+var ErrNotFound = fmt.Errorf("Delivery not found")
+
+const (
+	STATUS_PENDING    = "pending"
+	STATUS_DELIVERING = "delivering"
+	STATUS_DELIVERED  = "delivered"
+	STATUS_DEAD       = "dead"
+)
+
+// MAX_HISTORY limits how many attempts are kept per delivery
+const MAX_HISTORY = 20
+
+// Delivery is a single hook invocation waiting in (or gone through) the
+// outbox. Spec and params are stored raw and templated at delivery time.
+type Delivery struct {
+	ID            uint                   `json:"id"`
+	ChainID       int64                  `json:"chainId"`
+	Contract      string                 `json:"contract"`
+	Type          string                 `json:"type"`
+	Spec          interface{}            `json:"spec"`
+	Params        map[string]interface{} `json:"-"`
+	Payload       hooks.Payload          `json:"payload"`
+	Status        string                 `json:"status"`
+	Attempts      int                    `json:"attempts"`
+	LastError     string                 `json:"lastError,omitempty"`
+	NextAttemptAt time.Time              `json:"nextAttemptAt"`
+	History       []hooks.Attempt        `json:"history"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	UpdatedAt     time.Time              `json:"updatedAt"`
+}
+
+type IStore interface {
+	Add(ctx context.Context, delivery Delivery) (Delivery, error)
+	Get(ctx context.Context, id uint) (Delivery, error)
+	// Claim marks up to limit due deliveries as delivering until lease
+	// expires, so deliveries of a crashed worker are picked up again
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	Update(ctx context.Context, delivery Delivery) error
+	List(ctx context.Context, chainId int64, contract string, status string) ([]Delivery, error)
+	// Count returns how many deliveries there are per status
+	Count(ctx context.Context) (map[string]int64, error)
+}
+
+// SecretProvider returns the secret hook payloads of a manifest are signed with
+type SecretProvider func(ctx context.Context, chainId int64, contract string) (string, error)
@@ -1,6 +1,38 @@
 package hooks
 
-import "fmt"
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/metaconflux/backend/internal/diff"
+)
+
+const (
+	HEADER_SIGNATURE = "X-Metaconflux-Signature"
+	HEADER_TIMESTAMP = "X-Metaconflux-Timestamp"
+	HEADER_DELIVERY  = "X-Metaconflux-Delivery"
+)
+
+const (
+	EVENT_TOKEN_GENERATED  = "token.generated"
+	EVENT_TOKEN_CHANGED    = "token.changed"
+	EVENT_TOKEN_FAILED     = "token.failed"
+	EVENT_MANIFEST_UPDATED = "manifest.updated"
+	EVENT_MANIFEST_FROZEN  = "manifest.frozen"
+)
+
+var Events = []string{
+	EVENT_TOKEN_GENERATED,
+	EVENT_TOKEN_CHANGED,
+	EVENT_TOKEN_FAILED,
+	EVENT_MANIFEST_UPDATED,
+	EVENT_MANIFEST_FROZEN,
+}
 
 type HookManager struct {
 	hooks map[string]IHook
@@ -8,12 +40,110 @@ type HookManager struct {
 
 type IHook interface {
 	WithSpec(spec interface{}, params map[string]interface{}) (IHook, error)
-	Execute() error
+	// Execute delivers the payload, signing it with secret if it is not empty,
+	// and returns every attempt it made
+	Execute(ctx context.Context, payload Payload, secret string) ([]Attempt, error)
+}
+
+// IAsyncHook is implemented by hooks completing deliveries later, e.g. once
+// a batch of them was sent. ExecuteAsync must not block, done is called
+// exactly once with every attempt made.
+type IAsyncHook interface {
+	IHook
+	ExecuteAsync(ctx context.Context, payload Payload, secret string, done func([]Attempt, error))
+}
+
+// IValidator is implemented by hooks checking their spec when the manifest is
+// saved, before anything is delivered
+type IValidator interface {
+	Validate(spec interface{}) error
 }
 
 type Hook struct {
 	Type string      `json:"type"`
 	Spec interface{} `json:"spec"`
+	// Events the hook is triggered by, token.generated if empty
+	Events []string `json:"events,omitempty"`
+}
+
+// Subscribed reports whether the hook should run for the event
+func (h Hook) Subscribed(event string) bool {
+	if len(h.Events) == 0 {
+		return event == EVENT_TOKEN_GENERATED
+	}
+
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event describes what happened. Token fields are empty for manifest events,
+// CID is then the CID of the manifest.
+type Event struct {
+	Type        string        `json:"event"`
+	ChainID     string        `json:"chainId"`
+	Contract    string        `json:"contract"`
+	TokenID     string        `json:"tokenId,omitempty"`
+	CID         string        `json:"cid,omitempty"`
+	PreviousCID string        `json:"previousCid,omitempty"`
+	Result      interface{}   `json:"result,omitempty"`
+	Diff        []diff.Change `json:"diff,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Payload is the body sent to hook targets
+type Payload struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Event
+}
+
+// Attempt records a single delivery try
+type Attempt struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Status   int           `json:"status,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Reference identifies what the attempt produced, e.g. transaction hashes
+	Reference string `json:"reference,omitempty"`
+}
+
+func NewPayload(event Event) (Payload, error) {
+	id, err := NewSecret()
+	if err != nil {
+		return Payload{}, err
+	}
+
+	return Payload{
+		ID:        id[:32],
+		Timestamp: time.Now().Unix(),
+		Event:     event,
+	}, nil
+}
+
+// NewSecret generates a random hex encoded secret for signing payloads
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Sign computes the signature sent in HEADER_SIGNATURE. Receivers recompute
+// HMAC-SHA256 over "<timestamp>.<body>" with the manifest secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(body)
+
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
 }
 
 func NewHooksManager() HookManager {
@@ -40,3 +170,48 @@ func (h HookManager) Get(typ string) (IHook, error) {
 
 	return hook, nil
 }
+
+// Subscribers returns the hooks subscribed to the event
+func (h HookManager) Subscribers(subscriptions []Hook, event string) []Hook {
+	result := make([]Hook, 0)
+	for _, s := range subscriptions {
+		if s.Subscribed(event) {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// Validate checks the hooks of a manifest use known types and events and lets
+// the hooks check their specs
+func (h HookManager) Validate(subscriptions []Hook) error {
+	for i, s := range subscriptions {
+		hook, err := h.Get(s.Type)
+		if err != nil {
+			return fmt.Errorf("Hook %d: %s", i, err)
+		}
+
+		if validator, ok := hook.(IValidator); ok {
+			err = validator.Validate(s.Spec)
+			if err != nil {
+				return fmt.Errorf("Hook %d: %s", i, err)
+			}
+		}
+
+		for _, e := range s.Events {
+			known := false
+			for _, event := range Events {
+				if e == event {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return fmt.Errorf("Hook %d: unknown event %s", i, e)
+			}
+		}
+	}
+
+	return nil
+}
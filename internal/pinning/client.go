@@ -0,0 +1,114 @@
+package pinning
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RemotePin is the pin object of the IPFS Pinning Service API
+type RemotePin struct {
+	CID     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// RemoteStatus is the pin status object of the IPFS Pinning Service API
+type RemoteStatus struct {
+	RequestID string    `json:"requestid"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+	Pin       RemotePin `json:"pin"`
+	Delegates []string  `json:"delegates"`
+}
+
+type remoteError struct {
+	Error struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	} `json:"error"`
+}
+
+// Client talks to a service implementing the IPFS Pinning Service API
+// (https://ipfs.github.io/pinning-services-api-spec/)
+type Client struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func NewClient(endpoint string, token string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) Add(ctx context.Context, pin RemotePin) (RemoteStatus, error) {
+	var status RemoteStatus
+	err := c.do(ctx, http.MethodPost, "/pins", pin, &status)
+	return status, err
+}
+
+func (c *Client) Get(ctx context.Context, requestId string) (RemoteStatus, error) {
+	var status RemoteStatus
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/pins/%s", requestId), nil, &status)
+	return status, err
+}
+
+func (c *Client) Remove(ctx context.Context, requestId string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/pins/%s", requestId), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, target interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e remoteError
+		if json.Unmarshal(data, &e) == nil && len(e.Error.Reason) > 0 {
+			return fmt.Errorf("Pinning service responded %d: %s %s", resp.StatusCode, e.Error.Reason, e.Error.Details)
+		}
+		return fmt.Errorf("Pinning service responded %d", resp.StatusCode)
+	}
+
+	if target == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,106 @@
+package pinning
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/metaconflux/backend/internal/cache"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/sirupsen/logrus"
+)
+
+// Cache records every object pushed through it with the manager
+type Cache struct {
+	cache.ICache
+	manager *Manager
+}
+
+func NewCache(c cache.ICache, manager *Manager) cache.ICache {
+	return Cache{
+		ICache:  c,
+		manager: manager,
+	}
+}
+
+func (c Cache) Push(object interface{}) (string, error) {
+	id, err := c.ICache.Push(object)
+	if err != nil {
+		return id, err
+	}
+
+	err = c.manager.Pushed(context.Background(), id)
+	if err != nil {
+		logrus.Errorf("Failed to track pin %s: %s", id, err)
+	}
+
+	return id, nil
+}
+
+// Resolver turns resolver keys with one of the prefixes into refs, so objects
+// stay pinned while a key points to them. Tracking errors are logged only,
+// they must not fail serving metadata.
+type Resolver struct {
+	resolver.IResolver
+	manager  *Manager
+	prefixes []string
+}
+
+func NewResolver(r resolver.IResolver, manager *Manager, prefixes []string) resolver.IResolver {
+	return Resolver{
+		IResolver: r,
+		manager:   manager,
+		prefixes:  prefixes,
+	}
+}
+
+func (r Resolver) Set(key string, val string, timeout int64) error {
+	err := r.IResolver.Set(key, val, timeout)
+	if err != nil {
+		return err
+	}
+
+	if !r.tracked(key) {
+		return nil
+	}
+
+	_, err = cid.Decode(val)
+	if err != nil {
+		return nil
+	}
+
+	err = r.manager.Ref(context.Background(), key, val)
+	if err != nil {
+		logrus.Errorf("Failed to track ref %s: %s", key, err)
+	}
+
+	return nil
+}
+
+func (r Resolver) Delete(key string) error {
+	err := r.IResolver.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	if !r.tracked(key) {
+		return nil
+	}
+
+	err = r.manager.Unref(context.Background(), key)
+	if err != nil {
+		logrus.Errorf("Failed to untrack ref %s: %s", key, err)
+	}
+
+	return nil
+}
+
+func (r Resolver) tracked(key string) bool {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
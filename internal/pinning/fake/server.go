@@ -0,0 +1,103 @@
+package fake
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metaconflux/backend/internal/pinning"
+)
+
+// Service is an in-memory stand-in for a pinning service implementing the
+// parts of the IPFS Pinning Service API the client uses. Pins are reported
+// as pinned right away.
+type Service struct {
+	token string
+	lock  sync.Mutex
+	pins  map[string]pinning.RemoteStatus
+}
+
+func NewService(token string) *Service {
+	return &Service{
+		token: token,
+		pins:  make(map[string]pinning.RemoteStatus),
+	}
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	requestId := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pins"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && len(requestId) == 0:
+		results := make([]pinning.RemoteStatus, 0, len(s.pins))
+		for _, status := range s.pins {
+			if cid := r.URL.Query().Get("cid"); len(cid) > 0 && !strings.Contains(cid, status.Pin.CID) {
+				continue
+			}
+			results = append(results, status)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(results), "results": results})
+
+	case r.Method == http.MethodPost && len(requestId) == 0:
+		var pin pinning.RemotePin
+		err := json.NewDecoder(r.Body).Decode(&pin)
+		if err != nil || len(pin.CID) == 0 {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST")
+			return
+		}
+
+		id := make([]byte, 16)
+		_, _ = rand.Read(id)
+
+		status := pinning.RemoteStatus{
+			RequestID: hex.EncodeToString(id),
+			Status:    pinning.STATUS_PINNED,
+			Created:   time.Now(),
+			Pin:       pin,
+			Delegates: []string{},
+		}
+		s.pins[status.RequestID] = status
+		writeJSON(w, http.StatusAccepted, status)
+
+	case r.Method == http.MethodGet:
+		status, ok := s.pins[requestId]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NOT_FOUND")
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+
+	case r.Method == http.MethodDelete:
+		if _, ok := s.pins[requestId]; !ok {
+			writeError(w, http.StatusNotFound, "NOT_FOUND")
+			return
+		}
+		delete(s.pins, requestId)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	writeJSON(w, status, map[string]interface{}{"error": map[string]string{"reason": reason}})
+}
@@ -0,0 +1,223 @@
+package pinning
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_RETENTION   = 7 * 24 * time.Hour
+	DEFAULT_GC_INTERVAL = time.Hour
+	// REMOTE_INTERVAL is how often pending remote pins are requested or
+	// their status is checked
+	REMOTE_INTERVAL = 30 * time.Second
+	BATCH_SIZE      = 100
+)
+
+// Manager tracks the refs of pushed objects, mirrors them to a remote pinning
+// service and unpins orphaned objects after the retention window
+type Manager struct {
+	store     IStore
+	pinner    Pinner
+	remote    *Client
+	retention time.Duration
+	interval  time.Duration
+
+	// lock serializes Ref and the removal of a pin by GC, so a ref moving
+	// back to an object cannot pin it between the removal and the unpin
+	lock sync.Mutex
+}
+
+// NewManager creates a manager, remote is nil when no pinning service is
+// configured
+func NewManager(store IStore, pinner Pinner, remote *Client, retention time.Duration, interval time.Duration) *Manager {
+	if retention <= 0 {
+		retention = DEFAULT_RETENTION
+	}
+
+	if interval <= 0 {
+		interval = DEFAULT_GC_INTERVAL
+	}
+
+	return &Manager{
+		store:     store,
+		pinner:    pinner,
+		remote:    remote,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Pushed records an object added to the node
+func (m *Manager) Pushed(ctx context.Context, cid string) error {
+	return m.store.Add(ctx, cid, m.remote != nil)
+}
+
+// Ref points the ref to the CID, the object it pointed to before is orphaned
+// unless other refs point to it as well
+func (m *Manager) Ref(ctx context.Context, ref string, cid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	orphaned, created, err := m.store.SetRef(ctx, ref, cid, m.remote != nil)
+	if err != nil {
+		return err
+	}
+
+	// Objects pushed before they were tracked, or collected while the ref
+	// was moving back to them
+	if created {
+		err = m.pinner.Pin(cid)
+		if err != nil {
+			logrus.Warnf("Failed to pin %s: %s", cid, err)
+		}
+	}
+
+	for _, c := range orphaned {
+		logrus.Debugf("%s orphaned by %s", c, ref)
+	}
+
+	return nil
+}
+
+func (m *Manager) Unref(ctx context.Context, ref string) error {
+	orphaned, err := m.store.DeleteRef(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range orphaned {
+		logrus.Debugf("%s orphaned by removal of %s", c, ref)
+	}
+
+	return nil
+}
+
+// Start runs the remote pinning and the GC until the context is done
+func (m *Manager) Start(ctx context.Context) {
+	go func() {
+		remote := time.NewTicker(REMOTE_INTERVAL)
+		defer remote.Stop()
+
+		gc := time.NewTicker(m.interval)
+		defer gc.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-remote.C:
+				if m.remote == nil {
+					continue
+				}
+
+				err := m.SyncRemote(ctx)
+				if err != nil {
+					logrus.Errorf("Failed to sync remote pins: %s", err)
+				}
+			case <-gc.C:
+				count, err := m.GC(ctx)
+				if err != nil {
+					logrus.Errorf("Failed to collect orphaned pins: %s", err)
+				}
+				if count > 0 {
+					logrus.Infof("Unpinned %d orphaned objects", count)
+				}
+			}
+		}
+	}()
+}
+
+// GC unpins the objects orphaned for longer than the retention window and
+// returns how many were unpinned
+func (m *Manager) GC(ctx context.Context) (int, error) {
+	count := 0
+	cutoff := time.Now().Add(-m.retention)
+
+	for {
+		pins, err := m.store.Orphans(ctx, cutoff, BATCH_SIZE)
+		if err != nil {
+			return count, err
+		}
+
+		for _, pin := range pins {
+			removed, err := m.remove(ctx, pin.CID)
+			if err != nil {
+				return count, err
+			}
+			if !removed {
+				continue
+			}
+
+			if m.remote != nil && len(pin.RequestID) > 0 {
+				err = m.remote.Remove(ctx, pin.RequestID)
+				if err != nil {
+					logrus.Warnf("Failed to remove remote pin %s of %s: %s", pin.RequestID, pin.CID, err)
+				}
+			}
+
+			count++
+		}
+
+		if len(pins) < BATCH_SIZE {
+			return count, nil
+		}
+	}
+}
+
+// remove deletes the pin unless a ref came back since it was listed as
+// orphaned and unpins the object
+func (m *Manager) remove(ctx context.Context, cid string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	removed, err := m.store.Remove(ctx, cid)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	err = m.pinner.Unpin(cid)
+	if err != nil {
+		logrus.Warnf("Failed to unpin %s: %s", cid, err)
+	}
+
+	return true, nil
+}
+
+// SyncRemote requests remote pins of new objects and updates the status of
+// the ones in progress
+func (m *Manager) SyncRemote(ctx context.Context) error {
+	pins, err := m.store.Remote(ctx, BATCH_SIZE)
+	if err != nil {
+		return err
+	}
+
+	for _, pin := range pins {
+		var status RemoteStatus
+		if len(pin.RequestID) == 0 || pin.RemoteStatus == STATUS_FAILED {
+			pin.RemoteAttempts++
+			status, err = m.remote.Add(ctx, RemotePin{CID: pin.CID, Name: pin.CID})
+		} else {
+			status, err = m.remote.Get(ctx, pin.RequestID)
+		}
+
+		if err != nil {
+			pin.RemoteStatus = STATUS_FAILED
+			pin.RemoteError = err.Error()
+		} else {
+			pin.RequestID = status.RequestID
+			pin.RemoteStatus = status.Status
+			pin.RemoteError = ""
+		}
+
+		err = m.store.Update(ctx, pin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
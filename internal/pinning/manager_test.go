@@ -0,0 +1,227 @@
+package pinning_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metaconflux/backend/internal/pinning"
+	"github.com/metaconflux/backend/internal/pinning/fake"
+	sqlitepinning "github.com/metaconflux/backend/internal/pinning/sqlite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	token     = "secret"
+	oldCID    = "bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"
+	newCID    = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	resultRef = "token#1#0x00000000000000000000000000000000000000aa#1"
+)
+
+// pinner stands in for the IPFS node
+type pinner struct {
+	lock   sync.Mutex
+	pinned map[string]bool
+}
+
+func (p *pinner) Pin(path string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pinned[path] = true
+	return nil
+}
+
+func (p *pinner) Unpin(path string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.pinned, path)
+	return nil
+}
+
+func (p *pinner) isPinned(path string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.pinned[path]
+}
+
+func newStore(t *testing.T) pinning.IStore {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "pins.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single connection, sqlite fails concurrent writers otherwise
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	store, err := sqlitepinning.NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestGCUnpinsOrphans(t *testing.T) {
+	ctx := context.Background()
+
+	service := httptest.NewServer(fake.NewService(token))
+	t.Cleanup(service.Close)
+
+	remote := pinning.NewClient(service.URL, token)
+	node := &pinner{pinned: make(map[string]bool)}
+	m := pinning.NewManager(newStore(t), node, remote, time.Nanosecond, 0)
+
+	for _, cid := range []string{oldCID, newCID} {
+		node.Pin(cid)
+		err := m.Pushed(ctx, cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := m.Ref(ctx, resultRef, oldCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.SyncRemote(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Ref(ctx, resultRef, newCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := m.GC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Fatalf("Expected 1 unpinned object, got %d", count)
+	}
+
+	if node.isPinned(oldCID) {
+		t.Errorf("Expected %s to be unpinned", oldCID)
+	}
+
+	if !node.isPinned(newCID) {
+		t.Errorf("Expected %s to stay pinned", newCID)
+	}
+
+	err = m.SyncRemote(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := remotePins(t, service.URL)
+	if len(pinned) != 1 || !pinned[newCID] {
+		t.Errorf("Expected only %s to be pinned remotely, got %v", newCID, pinned)
+	}
+}
+
+// slowStore widens the window between removing an orphaned pin and unpinning
+// it, and moves the ref back to the object in the middle of it
+type slowStore struct {
+	pinning.IStore
+	revive func()
+}
+
+func (s slowStore) Remove(ctx context.Context, cid string) (bool, error) {
+	removed, err := s.IStore.Remove(ctx, cid)
+	if removed {
+		go s.revive()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return removed, err
+}
+
+func TestRefDuringGC(t *testing.T) {
+	ctx := context.Background()
+
+	var m *pinning.Manager
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	store := slowStore{IStore: newStore(t)}
+	store.revive = func() {
+		defer wg.Done()
+
+		err := m.Ref(ctx, resultRef, oldCID)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	node := &pinner{pinned: make(map[string]bool)}
+	m = pinning.NewManager(store, node, nil, time.Nanosecond, 0)
+
+	node.Pin(oldCID)
+	err := m.Ref(ctx, resultRef, oldCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Ref(ctx, resultRef, newCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.GC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if !node.isPinned(oldCID) {
+		t.Fatalf("Expected %s to be pinned again, the ref points to it", oldCID)
+	}
+}
+
+// remotePins lists the CIDs pinned on the fake service
+func remotePins(t *testing.T, endpoint string) map[string]bool {
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/pins", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Results []pinning.RemoteStatus `json:"results"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(map[string]bool)
+	for _, status := range list.Results {
+		result[status.Pin.CID] = true
+	}
+
+	return result
+}
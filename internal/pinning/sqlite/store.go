@@ -0,0 +1,256 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/metaconflux/backend/internal/pinning"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Store struct {
+	pinning.IStore
+	db *gorm.DB
+}
+
+type PinModel struct {
+	gorm.Model
+	CID            string `gorm:"column:cid;uniqueindex"`
+	RequestID      string
+	RemoteStatus   string `gorm:"index"`
+	RemoteAttempts int
+	RemoteError    string
+	OrphanedAt     *time.Time `gorm:"index"`
+}
+
+// RefModel is a resolver key pointing to a pinned object
+type RefModel struct {
+	ID        uint   `gorm:"primarykey"`
+	Ref       string `gorm:"uniqueindex"`
+	CID       string `gorm:"column:cid;index"`
+	UpdatedAt time.Time
+}
+
+func NewStore(db *gorm.DB) (pinning.IStore, error) {
+	err := db.AutoMigrate(&PinModel{}, &RefModel{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db: db,
+	}, nil
+}
+
+func (s *Store) Add(ctx context.Context, cid string, remote bool) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := ensurePin(tx, cid, remote)
+		return err
+	})
+}
+
+func (s *Store) SetRef(ctx context.Context, ref string, cid string, remote bool) ([]string, bool, error) {
+	var orphaned []string
+	var created bool
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var model RefModel
+		result := tx.Where("ref = ?", ref).Limit(1).Find(&model)
+		if result.Error != nil {
+			return result.Error
+		}
+		previous := model.CID
+
+		var err error
+		created, err = ensurePin(tx, cid, remote)
+		if err != nil {
+			return err
+		}
+
+		// Live again
+		result = tx.Model(&PinModel{}).Where("cid = ? AND orphaned_at IS NOT NULL", cid).Update("orphaned_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if previous == cid {
+			return nil
+		}
+
+		result = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "ref"}},
+			DoUpdates: clause.AssignmentColumns([]string{"cid", "updated_at"}),
+		}).Create(&RefModel{Ref: ref, CID: cid})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if len(previous) > 0 {
+			orphaned, err = orphan(tx, previous)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return orphaned, created, err
+}
+
+func (s *Store) DeleteRef(ctx context.Context, ref string) ([]string, error) {
+	var orphaned []string
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var model RefModel
+		result := tx.Where("ref = ?", ref).Limit(1).Find(&model)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		result = tx.Delete(&RefModel{}, model.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var err error
+		orphaned, err = orphan(tx, model.CID)
+		return err
+	})
+
+	return orphaned, err
+}
+
+func (s *Store) Orphans(ctx context.Context, before time.Time, limit int) ([]pinning.Pin, error) {
+	var models []PinModel
+	result := s.db.WithContext(ctx).Where("orphaned_at IS NOT NULL AND orphaned_at < ?", before).Order("orphaned_at").Limit(limit).Find(&models)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return fromModels(models), nil
+}
+
+func (s *Store) Remove(ctx context.Context, cid string) (bool, error) {
+	removed := false
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var refs int64
+		result := tx.Model(&RefModel{}).Where("cid = ?", cid).Count(&refs)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if refs > 0 {
+			return tx.Model(&PinModel{}).Where("cid = ?", cid).Update("orphaned_at", nil).Error
+		}
+
+		// Unscoped so the object can be tracked again if pushed again
+		result = tx.Unscoped().Where("cid = ? AND orphaned_at IS NOT NULL", cid).Delete(&PinModel{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		removed = result.RowsAffected > 0
+		return nil
+	})
+
+	return removed, err
+}
+
+func (s *Store) Remote(ctx context.Context, limit int) ([]pinning.Pin, error) {
+	var models []PinModel
+	result := s.db.WithContext(ctx).
+		Where("remote_status IN ? OR (remote_status = ? AND remote_attempts < ?)", []string{pinning.STATUS_QUEUED, pinning.STATUS_PINNING}, pinning.STATUS_FAILED, pinning.MAX_REMOTE_ATTEMPTS).
+		Order("updated_at").
+		Limit(limit).
+		Find(&models)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return fromModels(models), nil
+}
+
+func (s *Store) Update(ctx context.Context, pin pinning.Pin) error {
+	result := s.db.WithContext(ctx).Model(&PinModel{}).Where("cid = ?", pin.CID).Updates(map[string]interface{}{
+		"request_id":      pin.RequestID,
+		"remote_status":   pin.RemoteStatus,
+		"remote_attempts": pin.RemoteAttempts,
+		"remote_error":    pin.RemoteError,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return pinning.ErrNotFound
+	}
+
+	return nil
+}
+
+// ensurePin creates the pin unless it exists and reports whether it did
+func ensurePin(tx *gorm.DB, cid string, remote bool) (bool, error) {
+	var model PinModel
+	result := tx.Where("cid = ?", cid).Limit(1).Find(&model)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return false, nil
+	}
+
+	model = PinModel{CID: cid}
+	if remote {
+		model.RemoteStatus = pinning.STATUS_QUEUED
+	}
+
+	result = tx.Create(&model)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return true, nil
+}
+
+// orphan marks the pin as orphaned if no ref points to it anymore
+func orphan(tx *gorm.DB, cid string) ([]string, error) {
+	var refs int64
+	result := tx.Model(&RefModel{}).Where("cid = ?", cid).Count(&refs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if refs > 0 {
+		return nil, nil
+	}
+
+	result = tx.Model(&PinModel{}).Where("cid = ? AND orphaned_at IS NULL", cid).Update("orphaned_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return []string{cid}, nil
+}
+
+func fromModels(models []PinModel) []pinning.Pin {
+	pins := make([]pinning.Pin, 0, len(models))
+	for _, m := range models {
+		pins = append(pins, pinning.Pin{
+			CID:            m.CID,
+			RequestID:      m.RequestID,
+			RemoteStatus:   m.RemoteStatus,
+			RemoteAttempts: m.RemoteAttempts,
+			RemoteError:    m.RemoteError,
+			OrphanedAt:     m.OrphanedAt,
+			CreatedAt:      m.CreatedAt,
+		})
+	}
+
+	return pins
+}
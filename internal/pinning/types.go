@@ -0,0 +1,58 @@
+package pinning
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = fmt.Errorf("Pin not found")
+
+// Remote pin statuses, as defined by the Pinning Service API
+const (
+	STATUS_QUEUED  = "queued"
+	STATUS_PINNING = "pinning"
+	STATUS_PINNED  = "pinned"
+	STATUS_FAILED  = "failed"
+)
+
+// MAX_REMOTE_ATTEMPTS limits how often a failed remote pin is requested again
+const MAX_REMOTE_ATTEMPTS = 5
+
+// Pin is an object pushed by the backend. It is live as long as a ref (a
+// resolver key like the current manifest or token result) points to it. Once
+// the last ref moved on it is orphaned and unpinned after the retention
+// window. Objects which never had refs (e.g. composite images shared across
+// tokens) are never collected.
+type Pin struct {
+	CID            string     `json:"cid"`
+	RequestID      string     `json:"requestId,omitempty"`
+	RemoteStatus   string     `json:"remoteStatus,omitempty"`
+	RemoteAttempts int        `json:"remoteAttempts,omitempty"`
+	RemoteError    string     `json:"remoteError,omitempty"`
+	OrphanedAt     *time.Time `json:"orphanedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type IStore interface {
+	// Add records the pin unless it exists, remote pins are queued for the
+	// pinning service
+	Add(ctx context.Context, cid string, remote bool) error
+	// SetRef points the ref to the CID. It returns the CIDs which lost their
+	// last ref and whether the pin of the CID had to be created.
+	SetRef(ctx context.Context, ref string, cid string, remote bool) ([]string, bool, error)
+	DeleteRef(ctx context.Context, ref string) ([]string, error)
+	// Orphans returns up to limit pins orphaned before the given time
+	Orphans(ctx context.Context, before time.Time, limit int) ([]Pin, error)
+	// Remove deletes the pin if it is still orphaned
+	Remove(ctx context.Context, cid string) (bool, error)
+	// Remote returns up to limit pins which are not pinned remotely yet
+	Remote(ctx context.Context, limit int) ([]Pin, error)
+	Update(ctx context.Context, pin Pin) error
+}
+
+// Pinner pins on the local node, *shell.Shell implements it
+type Pinner interface {
+	Pin(path string) error
+	Unpin(path string) error
+}
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -58,40 +60,39 @@ func NewResolver(file string) (resolver.IResolver, error) {
 }
 
 func (r *Resolver) Get(key string) (string, error) {
+	value, _, err := r.GetWithLifetime(key)
+	return value, err
+}
+
+func (r *Resolver) GetWithLifetime(key string) (string, time.Time, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	data, err := ioutil.ReadFile(r.store)
+	resolverMap, err := r.read()
 	if err != nil {
-		return "", err
-	}
-	var resolverMap ResolverMap
-	err = json.Unmarshal(data, &resolverMap)
-	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 	result, ok := resolverMap[key]
 	if !ok {
-		return result.Value, resolver.ErrNotFound
+		return result.Value, time.Time{}, resolver.ErrNotFound
+	}
+
+	if result.expired(time.Now()) {
+		return result.Value, result.Lifetime, resolver.ErrNotFound
 	}
 
-	if result.Lifetime.Unix() > 0 && result.Lifetime.Before(time.Now()) {
-		return result.Value, resolver.ErrNotFound
+	if result.Lifetime.Unix() <= 0 {
+		return result.Value, time.Time{}, nil
 	}
 
-	return result.Value, nil
+	return result.Value, result.Lifetime, nil
 }
 
 func (r *Resolver) Set(key string, val string, timeout int64) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	data, err := ioutil.ReadFile(r.store)
-	if err != nil {
-		return err
-	}
-	var resolverMap ResolverMap
-	err = json.Unmarshal(data, &resolverMap)
+	resolverMap, err := r.read()
 	if err != nil {
 		return err
 	}
@@ -107,15 +108,147 @@ func (r *Resolver) Set(key string, val string, timeout int64) error {
 	}
 
 	resolverMap[key] = entry
-	data, err = json.Marshal(resolverMap)
+
+	return r.write(resolverMap)
+}
+
+func (r *Resolver) Delete(key string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	resolverMap, err := r.read()
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(r.store, data, 0700)
+	_, ok := resolverMap[key]
+	if !ok {
+		return resolver.ErrNotFound
+	}
+
+	delete(resolverMap, key)
+
+	return r.write(resolverMap)
+}
+
+func (r *Resolver) List(query resolver.Query) ([]resolver.Entry, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	resolverMap, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]resolver.Entry, 0)
+	for key, entry := range resolverMap {
+		if !strings.HasPrefix(key, query.Prefix) || key <= query.After {
+			continue
+		}
+
+		if !query.ExpiredBefore.IsZero() && !entry.expired(query.ExpiredBefore) {
+			continue
+		}
+
+		result := resolver.Entry{Key: key, Value: entry.Value}
+		if entry.Lifetime.Unix() > 0 {
+			result.Lifetime = entry.Lifetime
+		}
+		entries = append(entries, result)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	return entries, nil
+}
+
+func (r *Resolver) Count(prefix string) (resolver.Stats, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var stats resolver.Stats
+
+	resolverMap, err := r.read()
+	if err != nil {
+		return stats, err
+	}
+
+	now := time.Now()
+	for key, entry := range resolverMap {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		stats.Keys++
+		if entry.expired(now) {
+			stats.Expired++
+		}
+	}
+
+	return stats, nil
+}
+
+func (r *Resolver) Expire(keys []string) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	resolverMap, err := r.read()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	var expired int64
+	for _, key := range keys {
+		entry, ok := resolverMap[key]
+		if !ok || entry.expired(now) {
+			continue
+		}
+
+		entry.Lifetime = now
+		resolverMap[key] = entry
+		expired++
+	}
+
+	return expired, r.write(resolverMap)
+}
+
+// Purge has nothing to do, deleted entries are gone right away
+func (r *Resolver) Purge(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (r *Resolver) read() (ResolverMap, error) {
+	data, err := ioutil.ReadFile(r.store)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolverMap ResolverMap
+	err = json.Unmarshal(data, &resolverMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolverMap, nil
+}
+
+func (r *Resolver) write(resolverMap ResolverMap) error {
+	data, err := json.Marshal(resolverMap)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return ioutil.WriteFile(r.store, data, 0700)
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return e.Lifetime.Unix() > 0 && e.Lifetime.Before(now)
 }
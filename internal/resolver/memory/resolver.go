@@ -1,6 +1,8 @@
 package memory
 
 import (
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/metaconflux/backend/internal/resolver"
@@ -23,16 +25,25 @@ func NewResolver() resolver.IResolver {
 }
 
 func (r Resolver) Get(key string) (string, error) {
+	value, _, err := r.GetWithLifetime(key)
+	return value, err
+}
+
+func (r Resolver) GetWithLifetime(key string) (string, time.Time, error) {
 	result, ok := r.data[key]
 	if !ok {
-		return result.Value, resolver.ErrNotFound
+		return result.Value, time.Time{}, resolver.ErrNotFound
 	}
 
 	if result.Timeout.Unix() > 0 && result.Timeout.Before(time.Now()) {
-		return result.Value, resolver.ErrNotFound
+		return result.Value, result.Timeout, resolver.ErrNotFound
 	}
 
-	return result.Value, nil
+	if result.Timeout.Unix() <= 0 {
+		return result.Value, time.Time{}, nil
+	}
+
+	return result.Value, result.Timeout, nil
 }
 
 func (r Resolver) Set(key string, val string, timeout int64) error {
@@ -48,3 +59,95 @@ func (r Resolver) Set(key string, val string, timeout int64) error {
 	r.data[key] = entry
 	return nil
 }
+
+func (r Resolver) Delete(key string) error {
+	_, ok := r.data[key]
+	if !ok {
+		return resolver.ErrNotFound
+	}
+
+	delete(r.data, key)
+	return nil
+}
+
+func (r Resolver) List(query resolver.Query) ([]resolver.Entry, error) {
+	return list(r.data, query), nil
+}
+
+func (r Resolver) Count(prefix string) (resolver.Stats, error) {
+	return count(r.data, prefix), nil
+}
+
+func (r Resolver) Expire(keys []string) (int64, error) {
+	now := time.Now()
+
+	var expired int64
+	for _, key := range keys {
+		entry, ok := r.data[key]
+		if !ok || entry.expired(now) {
+			continue
+		}
+
+		entry.Timeout = now
+		r.data[key] = entry
+		expired++
+	}
+
+	return expired, nil
+}
+
+// Purge has nothing to do, deleted entries are gone right away
+func (r Resolver) Purge(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return e.Timeout.Unix() > 0 && e.Timeout.Before(now)
+}
+
+func list(data map[string]Entry, query resolver.Query) []resolver.Entry {
+	entries := make([]resolver.Entry, 0)
+	for key, entry := range data {
+		if !strings.HasPrefix(key, query.Prefix) || key <= query.After {
+			continue
+		}
+
+		if !query.ExpiredBefore.IsZero() && !entry.expired(query.ExpiredBefore) {
+			continue
+		}
+
+		result := resolver.Entry{Key: key, Value: entry.Value}
+		if entry.Timeout.Unix() > 0 {
+			result.Lifetime = entry.Timeout
+		}
+		entries = append(entries, result)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	return entries
+}
+
+func count(data map[string]Entry, prefix string) resolver.Stats {
+	var stats resolver.Stats
+
+	now := time.Now()
+	for key, entry := range data {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		stats.Keys++
+		if entry.expired(now) {
+			stats.Expired++
+		}
+	}
+
+	return stats
+}
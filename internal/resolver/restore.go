@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"math"
+	"time"
+)
+
+// Each calls fn for every entry with a key starting with the prefix, in the
+// order of the keys
+func Each(r IResolver, prefix string, fn func(entry Entry) error) error {
+	after := ""
+	for {
+		entries, err := r.List(Query{Prefix: prefix, After: after, Limit: SWEEP_BATCH_SIZE})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+			after = entry.Key
+		}
+
+		if len(entries) < SWEEP_BATCH_SIZE {
+			return nil
+		}
+	}
+}
+
+// Restore stores the entry with the rest of its lifetime, entries whose
+// lifetime is over are stored expired
+func Restore(r IResolver, entry Entry) error {
+	if entry.Lifetime.Unix() <= 0 {
+		return r.Set(entry.Key, entry.Value, 0)
+	}
+
+	remaining := time.Until(entry.Lifetime)
+	if remaining <= 0 {
+		err := r.Set(entry.Key, entry.Value, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.Expire([]string{entry.Key})
+		return err
+	}
+
+	// Lifetimes are set in minutes
+	return r.Set(entry.Key, entry.Value, int64(math.Ceil(remaining.Minutes())))
+}
+
+// Copy restores every entry of from in to and returns how many were copied
+func Copy(from IResolver, to IResolver) (int64, error) {
+	var copied int64
+
+	err := Each(from, "", func(entry Entry) error {
+		err := Restore(to, entry)
+		if err != nil {
+			return err
+		}
+
+		copied++
+		return nil
+	})
+
+	return copied, err
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/metaconflux/backend/internal/database"
 	"github.com/metaconflux/backend/internal/resolver"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -32,25 +33,34 @@ func NewResolver(db *gorm.DB) (resolver.IResolver, error) {
 }
 
 func (r *Resolver) Get(key string) (string, error) {
+	value, _, err := r.GetWithLifetime(key)
+	return value, err
+}
+
+func (r *Resolver) GetWithLifetime(key string) (string, time.Time, error) {
 	var model ResolverModel
 	result := r.db.Find(&model, "key = ?", key)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return "", resolver.ErrNotFound
+			return "", time.Time{}, resolver.ErrNotFound
 		}
 
-		return "", result.Error
+		return "", time.Time{}, result.Error
 	}
 
 	if model.Key == "" {
-		return "", resolver.ErrNotFound
+		return "", time.Time{}, resolver.ErrNotFound
 	}
 
 	if model.Lifetime.Unix() > 0 && model.Lifetime.Before(time.Now()) {
-		return model.Value, resolver.ErrLifetime
+		return model.Value, model.Lifetime, resolver.ErrLifetime
+	}
+
+	if model.Lifetime.Unix() <= 0 {
+		return model.Value, time.Time{}, nil
 	}
 
-	return model.Value, nil
+	return model.Value, model.Lifetime, nil
 }
 func (r *Resolver) Set(key string, val string, timeout int64) error {
 
@@ -95,3 +105,113 @@ func (r *Resolver) Delete(key string) error {
 
 	return nil
 }
+
+func (r *Resolver) List(query resolver.Query) ([]resolver.Entry, error) {
+	tx := withPrefix(r.db.Model(&ResolverModel{}), query.Prefix)
+
+	if len(query.After) > 0 {
+		tx = tx.Where(keyColumn(tx)+" > ?", query.After)
+	}
+
+	if !query.ExpiredBefore.IsZero() {
+		tx = tx.Where("lifetime > ? AND lifetime < ?", time.Unix(0, 0), query.ExpiredBefore)
+	}
+
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+
+	var models []ResolverModel
+	result := tx.Order(keyColumn(tx)).Find(&models)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	entries := make([]resolver.Entry, 0, len(models))
+	for _, model := range models {
+		entry := resolver.Entry{
+			Key:   model.Key,
+			Value: model.Value,
+		}
+		if model.Lifetime.Unix() > 0 {
+			entry.Lifetime = model.Lifetime
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (r *Resolver) Count(prefix string) (resolver.Stats, error) {
+	var stats resolver.Stats
+
+	result := withPrefix(r.db.Model(&ResolverModel{}), prefix).Count(&stats.Keys)
+	if result.Error != nil {
+		return stats, result.Error
+	}
+
+	result = withPrefix(r.db.Model(&ResolverModel{}), prefix).
+		Where("lifetime > ? AND lifetime < ?", time.Unix(0, 0), time.Now()).
+		Count(&stats.Expired)
+	if result.Error != nil {
+		return stats, result.Error
+	}
+
+	return stats, nil
+}
+
+func (r *Resolver) Expire(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+
+	result := r.db.Model(&ResolverModel{}).
+		Where("key IN ?", keys).
+		Where("lifetime <= ? OR lifetime >= ?", time.Unix(0, 0), now).
+		Update("lifetime", now)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+func (r *Resolver) Purge(before time.Time) (int64, error) {
+	result := r.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&ResolverModel{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// keyColumn compares keys byte by byte. Postgres sorts by the collation of
+// the database, which may ignore punctuation like the # of the namespaces.
+func keyColumn(tx *gorm.DB) string {
+	if tx.Dialector.Name() == database.DRIVER_POSTGRES {
+		return `key COLLATE "C"`
+	}
+
+	return "key"
+}
+
+// withPrefix selects the keys starting with the prefix as a key range, LIKE
+// is case insensitive in sqlite and needs escaping of _ and % everywhere
+func withPrefix(tx *gorm.DB, prefix string) *gorm.DB {
+	if len(prefix) == 0 {
+		return tx
+	}
+
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return tx.Where(keyColumn(tx)+" >= ? AND "+keyColumn(tx)+" < ?", prefix, string(end[:i+1]))
+		}
+	}
+
+	return tx.Where(keyColumn(tx)+" >= ?", prefix)
+}
@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_SWEEP_RETENTION = 30 * 24 * time.Hour
+	DEFAULT_SWEEP_INTERVAL  = time.Hour
+	SWEEP_BATCH_SIZE        = 500
+)
+
+// SweepPolicy lets the owner of the keys decide what is swept
+type SweepPolicy interface {
+	// Keep reports whether the expired entry has to stay, e.g. because its
+	// value is still served
+	Keep(key string) bool
+	// Related returns the keys deleted along with the swept entry
+	Related(key string) []string
+}
+
+type SweepResult struct {
+	Swept  int64 `json:"swept"`
+	Kept   int64 `json:"kept"`
+	Purged int64 `json:"purged"`
+}
+
+// Sweeper deletes entries whose lifetime is over for longer than the
+// retention and purges deleted entries once they are older than it. Expired
+// entries are kept for the retention as their values are still used, e.g. to
+// compare a regenerated result with the previous one.
+type Sweeper struct {
+	resolver  IResolver
+	policy    SweepPolicy
+	retention time.Duration
+	interval  time.Duration
+	lock      sync.Mutex
+}
+
+// NewSweeper creates a sweeper, everything expired is swept when the policy
+// is nil
+func NewSweeper(r IResolver, policy SweepPolicy, retention time.Duration, interval time.Duration) *Sweeper {
+	if retention <= 0 {
+		retention = DEFAULT_SWEEP_RETENTION
+	}
+
+	if interval <= 0 {
+		interval = DEFAULT_SWEEP_INTERVAL
+	}
+
+	return &Sweeper{
+		resolver:  r,
+		policy:    policy,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Start sweeps periodically until the context is done
+func (s *Sweeper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := s.Sweep(ctx)
+				if err != nil {
+					logrus.Errorf("Failed to sweep resolver: %s", err)
+				}
+				if result.Swept > 0 || result.Purged > 0 {
+					logrus.Infof("Swept %d expired and purged %d deleted resolver entries", result.Swept, result.Purged)
+				}
+			}
+		}
+	}()
+}
+
+func (s *Sweeper) Sweep(ctx context.Context) (SweepResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var result SweepResult
+	cutoff := time.Now().Add(-s.retention)
+
+	after := ""
+	for {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		entries, err := s.resolver.List(Query{After: after, ExpiredBefore: cutoff, Limit: SWEEP_BATCH_SIZE})
+		if err != nil {
+			return result, err
+		}
+
+		for _, entry := range entries {
+			after = entry.Key
+
+			if s.policy != nil && s.policy.Keep(entry.Key) {
+				result.Kept++
+				continue
+			}
+
+			keys := []string{entry.Key}
+			if s.policy != nil {
+				keys = append(keys, s.policy.Related(entry.Key)...)
+			}
+
+			for _, key := range keys {
+				err = s.resolver.Delete(key)
+				if err != nil && err != ErrNotFound {
+					return result, err
+				}
+			}
+
+			result.Swept++
+		}
+
+		if len(entries) < SWEEP_BATCH_SIZE {
+			break
+		}
+	}
+
+	purged, err := s.resolver.Purge(cutoff)
+	if err != nil {
+		return result, err
+	}
+	result.Purged = purged
+
+	return result, nil
+}
+
+// ExpirePrefix ends the lifetime of every entry with a key starting with the
+// prefix
+func ExpirePrefix(r IResolver, prefix string) (int64, error) {
+	if len(prefix) == 0 {
+		return 0, fmt.Errorf("Prefix cannot be empty")
+	}
+
+	keys := make([]string, 0)
+	err := Each(r, prefix, func(entry Entry) error {
+		keys = append(keys, entry.Key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var expired int64
+	for start := 0; start < len(keys); start += SWEEP_BATCH_SIZE {
+		end := start + SWEEP_BATCH_SIZE
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		count, err := r.Expire(keys[start:end])
+		if err != nil {
+			return expired, err
+		}
+		expired += count
+	}
+
+	return expired, nil
+}
@@ -1,12 +1,56 @@
 package resolver
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 var ErrNotFound = fmt.Errorf("Not Found")
 var ErrLifetime = fmt.Errorf("Lifetime is over")
 
 type IResolver interface {
 	Get(key string) (string, error)
+	// GetWithLifetime also returns the end of the lifetime, which is zero
+	// for values that do not expire
+	GetWithLifetime(key string) (string, time.Time, error)
 	Set(key string, val string, timeout int64) error
 	Delete(key string) error
+	// List returns the entries selected by the query ordered by key,
+	// including the ones whose lifetime is over
+	List(query Query) ([]Entry, error)
+	// Count returns how many entries have keys starting with the prefix
+	Count(prefix string) (Stats, error)
+	// Expire ends the lifetime of the entries and returns how many were
+	// still alive
+	Expire(keys []string) (int64, error)
+	// Purge permanently removes entries deleted before the given time, for
+	// backends keeping deleted entries around
+	Purge(before time.Time) (int64, error)
+}
+
+// Entry is a stored value with the end of its lifetime, which is zero for
+// values that do not expire
+type Entry struct {
+	Key      string    `json:"key"`
+	Value    string    `json:"value"`
+	Lifetime time.Time `json:"lifetime"`
+}
+
+func (e Entry) Expired(now time.Time) bool {
+	return e.Lifetime.Unix() > 0 && e.Lifetime.Before(now)
+}
+
+// Query selects entries by key prefix. Pages start after the last key of the
+// previous one.
+type Query struct {
+	Prefix string
+	After  string
+	// ExpiredBefore only selects entries whose lifetime ended before it
+	ExpiredBefore time.Time
+	Limit         int
+}
+
+type Stats struct {
+	Keys    int64 `json:"keys"`
+	Expired int64 `json:"expired"`
 }
@@ -48,6 +48,10 @@ func templateRecursive(copy, original reflect.Value, tags *reflect.StructTag, pa
 	// note that we have to call Elem() after creating a new object because otherwise
 	// we would end up with an actual pointer
 	case reflect.Interface:
+		// Nothing to translate in a nil interface
+		if original.IsNil() {
+			return nil
+		}
 		// Get rid of the wrapping interface
 		originalValue := original.Elem()
 		// Create a new object. Now new gives us a pointer, but we want the value it
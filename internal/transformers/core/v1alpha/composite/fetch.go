@@ -0,0 +1,39 @@
+package composite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/metaconflux/backend/internal/fetch"
+)
+
+// MAX_LAYER_SIZE limits how much data is read for a single layer
+const MAX_LAYER_SIZE = 20 << 20
+
+func (t Transformer) fetchImage(ctx context.Context, uri string) (image.Image, error) {
+	data, err := fetch.ReadAll(ctx, t.ipfsClient, uri, MAX_LAYER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is checked first, a small file can declare a huge image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s: %s", uri, err)
+	}
+
+	if config.Width > MAX_DIMENSION || config.Height > MAX_DIMENSION {
+		return nil, fmt.Errorf("%s is %dx%d, the maximum is %dx%d", uri, config.Width, config.Height, MAX_DIMENSION, MAX_DIMENSION)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s: %s", uri, err)
+	}
+
+	return img, nil
+}
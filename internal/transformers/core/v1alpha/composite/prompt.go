@@ -0,0 +1,72 @@
+package composite
+
+import (
+	"strconv"
+
+	"github.com/manifoldco/promptui"
+	"github.com/metaconflux/backend/internal/transformers"
+)
+
+func NewSpecFromPrompt() (transformers.BaseTransformer, error) {
+	var base transformers.BaseTransformer
+
+	validateInt := func(s string) error {
+		_, err := strconv.Atoi(s)
+		return err
+	}
+
+	prompt := promptui.Prompt{
+		Label:    "Width",
+		Default:  "1000",
+		Validate: validateInt,
+	}
+
+	width, err := prompt.Run()
+	if err != nil {
+		return base, err
+	}
+
+	prompt = promptui.Prompt{
+		Label:    "Height",
+		Default:  "1000",
+		Validate: validateInt,
+	}
+
+	height, err := prompt.Run()
+	if err != nil {
+		return base, err
+	}
+
+	spec := SpecSchema{
+		Layers: make([]Layer, 0),
+	}
+	spec.Width, _ = strconv.Atoi(width)
+	spec.Height, _ = strconv.Atoi(height)
+
+	for {
+		prompt := promptui.Prompt{
+			Label: "Layer source (ipfs:// or https://, empty to finish)",
+		}
+
+		source, err := prompt.Run()
+		if err != nil {
+			return base, err
+		}
+
+		if len(source) == 0 {
+			break
+		}
+
+		spec.Layers = append(spec.Layers, Layer{
+			Source: source,
+		})
+	}
+
+	base = transformers.BaseTransformer{
+		GroupVersionKind: GVK,
+		Spec:             spec,
+	}
+
+	return base, nil
+
+}
@@ -0,0 +1,79 @@
+package composite
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const DEFAULT_TEXT_SIZE = 24
+
+var (
+	regularFont     *opentype.Font
+	regularFontErr  error
+	regularFontOnce sync.Once
+)
+
+func loadFont() (*opentype.Font, error) {
+	regularFontOnce.Do(func() {
+		regularFont, regularFontErr = opentype.Parse(goregular.TTF)
+	})
+
+	return regularFont, regularFontErr
+}
+
+// drawText renders the text with its baseline at layer Y, X is the left edge,
+// center or right edge depending on the alignment
+func drawText(canvas *image.RGBA, l resolvedLayer) error {
+	f, err := loadFont()
+	if err != nil {
+		return err
+	}
+
+	size := l.Size
+	if size <= 0 {
+		size = DEFAULT_TEXT_SIZE
+	}
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	var col color.Color = color.Black
+	if len(l.Color) > 0 {
+		col, err = parseColor(l.Color)
+		if err != nil {
+			return err
+		}
+	}
+
+	d := font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(col),
+		Face: face,
+	}
+
+	x := fixed.I(l.X)
+	switch l.Align {
+	case "center":
+		x -= d.MeasureString(l.Text) / 2
+	case "right":
+		x -= d.MeasureString(l.Text)
+	}
+
+	d.Dot = fixed.Point26_6{X: x, Y: fixed.I(l.Y)}
+	d.DrawString(l.Text)
+
+	return nil
+}
@@ -0,0 +1,464 @@
+package composite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/metaconflux/backend/internal/cache"
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/gvk"
+	"github.com/metaconflux/backend/internal/resolver"
+	"github.com/metaconflux/backend/internal/template"
+	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/sjson"
+	xdraw "golang.org/x/image/draw"
+)
+
+var GVK = gvk.NewGroupVersionKind(
+	"core",
+	"v1alpha",
+	"image",
+)
+
+var deadline = 10 * time.Second
+var _ transformers.ITransformer = &Transformer{}
+
+const (
+	DEFAULT_TARGET = "image"
+	ATTRIBUTES     = "attributes"
+	MAX_DIMENSION  = 4096
+	MAX_TEXT_SIZE  = 512
+
+	// HTTP_LIFETIME expires renders with layers fetched over HTTP, unlike IPFS
+	// sources their content can change behind the same URL
+	HTTP_LIFETIME = 24 * time.Hour
+)
+
+// Transformer composites image and text layers into a PNG, pushes it to the
+// cache and points the metadata image to it. Renders are cached by a hash of
+// the resolved layers, so tokens sharing the same traits share the image.
+type Transformer struct {
+	spec        SpecSchema
+	params      map[string]interface{}
+	data        map[string]interface{}
+	initialized bool
+	ipfsClient  *shell.Shell
+	cache       cache.ICache
+	resolver    resolver.IResolver
+}
+
+type Layer struct {
+	When    string            `json:"when,omitempty"`
+	Source  string            `json:"source,omitempty" template:""`
+	Trait   string            `json:"trait,omitempty" template:""`
+	Sources map[string]string `json:"sources,omitempty"`
+	Text    string            `json:"text,omitempty"`
+	Size    float64           `json:"size,omitempty"`
+	Color   string            `json:"color,omitempty"`
+	Align   string            `json:"align,omitempty"`
+	X       int               `json:"x"`
+	Y       int               `json:"y"`
+	Width   int               `json:"width,omitempty"`
+	Height  int               `json:"height,omitempty"`
+}
+
+type SpecSchema struct {
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Background string  `json:"background,omitempty"`
+	Target     string  `json:"target,omitempty" template:""`
+	Layers     []Layer `json:"layers"`
+}
+
+// resolvedLayer is a layer after the conditions, traits and text expressions
+// were evaluated. It is what the render cache key is computed from.
+type resolvedLayer struct {
+	Source string  `json:"source,omitempty"`
+	Text   string  `json:"text,omitempty"`
+	Size   float64 `json:"size,omitempty"`
+	Color  string  `json:"color,omitempty"`
+	Align  string  `json:"align,omitempty"`
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width,omitempty"`
+	Height int     `json:"height,omitempty"`
+}
+
+type selection struct {
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	Background string          `json:"background"`
+	Layers     []resolvedLayer `json:"layers"`
+}
+
+func NewTransformer(shell *shell.Shell, cache cache.ICache, resolver resolver.IResolver) *Transformer {
+	return &Transformer{
+		ipfsClient: shell,
+		cache:      cache,
+		resolver:   resolver,
+	}
+}
+
+func (t Transformer) WithSpec(ispec interface{}, params map[string]interface{}) (transformers.ITransformer, error) {
+	var spec SpecSchema
+	err := utils.Remarshal(ispec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	transformer := Transformer{
+		params:      params,
+		data:        make(map[string]interface{}),
+		initialized: true,
+		ipfsClient:  t.ipfsClient,
+		cache:       t.cache,
+		resolver:    t.resolver,
+	}
+
+	err = template.Template(&spec, &transformer.spec, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transformer.spec.Target) == 0 {
+		transformer.spec.Target = DEFAULT_TARGET
+	}
+
+	return transformer, nil
+}
+
+func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (map[string]interface{}, error) {
+	// Manifests stored before a limit was introduced are checked again
+	err := t.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	sel, err := t.resolve(base)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := sel.hash()
+	if err != nil {
+		return nil, err
+	}
+
+	key := formatImageKey(hash)
+	cid, err := t.resolver.Get(key)
+	if err != nil {
+		if err != resolver.ErrNotFound && err != resolver.ErrLifetime {
+			return nil, err
+		}
+
+		cid, err = t.render(ctx, sel)
+		if err != nil {
+			return nil, err
+		}
+
+		err = t.resolver.Set(key, cid, sel.lifetime())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		logrus.Debugf("Using cached image %s for %s", cid, hash)
+	}
+
+	uri := fmt.Sprintf("ipfs://%s", cid)
+	t.data["image"] = uri
+	t.data["hash"] = hash
+
+	baseBytes, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := sjson.Set(string(baseBytes), t.spec.Target, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers stay json.Number, big token values would lose precision as
+	// float64
+	var resultMap map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(result))
+	d.UseNumber()
+	err = d.Decode(&resultMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultMap, nil
+}
+
+func formatImageKey(hash string) string {
+	return fmt.Sprintf("image#%s", hash)
+}
+
+func (t Transformer) resolve(base map[string]interface{}) (selection, error) {
+	sel := selection{
+		Width:      t.spec.Width,
+		Height:     t.spec.Height,
+		Background: t.spec.Background,
+		Layers:     make([]resolvedLayer, 0, len(t.spec.Layers)),
+	}
+
+	env := utils.MergeMaps(t.params)
+	env["result"] = base
+
+	for i, l := range t.spec.Layers {
+		if len(l.When) > 0 {
+			ok, err := expr.EvalBool(l.When, env)
+			if err != nil {
+				return sel, fmt.Errorf("Layer %d: %s", i, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		rl := resolvedLayer{
+			Source: l.Source,
+			X:      l.X,
+			Y:      l.Y,
+			Width:  l.Width,
+			Height: l.Height,
+		}
+
+		if len(l.Text) > 0 {
+			text, err := expr.Compile(l.Text)
+			if err != nil {
+				return sel, fmt.Errorf("Layer %d: %s", i, err)
+			}
+			val, err := text.Eval(env)
+			if err != nil {
+				return sel, fmt.Errorf("Layer %d: %s", i, err)
+			}
+			rl.Text = expr.ToString(val)
+			rl.Size = l.Size
+			rl.Color = l.Color
+			rl.Align = l.Align
+			rl.Source = ""
+		} else if len(l.Trait) > 0 {
+			traitVal := expr.Trait(base[ATTRIBUTES], l.Trait)
+			if source, ok := l.Sources[expr.ToString(traitVal)]; ok {
+				rl.Source = source
+			}
+		}
+
+		if len(rl.Source) == 0 && len(rl.Text) == 0 {
+			continue
+		}
+
+		sel.Layers = append(sel.Layers, rl)
+	}
+
+	return sel, nil
+}
+
+func (s selection) hash() (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lifetime returns the render cache lifetime in minutes, 0 keeps renders of
+// IPFS sources forever
+func (s selection) lifetime() int64 {
+	for _, l := range s.Layers {
+		if len(l.Source) > 0 && !strings.HasPrefix(l.Source, "ipfs://") {
+			return int64(HTTP_LIFETIME / time.Minute)
+		}
+	}
+
+	return 0
+}
+
+func (t Transformer) render(ctx context.Context, sel selection) (string, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, sel.Width, sel.Height))
+
+	if len(sel.Background) > 0 {
+		bg, err := parseColor(sel.Background)
+		if err != nil {
+			return "", err
+		}
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+
+	for i, l := range sel.Layers {
+		var err error
+		if len(l.Text) > 0 {
+			err = drawText(canvas, l)
+		} else {
+			err = t.drawImage(ctx, canvas, l)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Layer %d: %s", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, canvas)
+	if err != nil {
+		return "", err
+	}
+
+	return t.cache.Push(buf.Bytes())
+}
+
+func (t Transformer) drawImage(ctx context.Context, canvas *image.RGBA, l resolvedLayer) error {
+	src, err := t.fetchImage(ctx, l.Source)
+	if err != nil {
+		return err
+	}
+
+	width := l.Width
+	height := l.Height
+	if width == 0 {
+		width = src.Bounds().Dx()
+	}
+	if height == 0 {
+		height = src.Bounds().Dy()
+	}
+
+	rect := image.Rect(l.X, l.Y, l.X+width, l.Y+height)
+
+	if width == src.Bounds().Dx() && height == src.Bounds().Dy() {
+		draw.Draw(canvas, rect, src, src.Bounds().Min, draw.Over)
+		return nil
+	}
+
+	xdraw.CatmullRom.Scale(canvas, rect, src, src.Bounds(), xdraw.Over, nil)
+	return nil
+}
+
+func parseColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 0xff}
+	hexStr := strings.TrimPrefix(s, "#")
+
+	var err error
+	switch len(hexStr) {
+	case 6:
+		_, err = fmt.Sscanf(hexStr, "%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 8:
+		_, err = fmt.Sscanf(hexStr, "%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = fmt.Errorf("expected #rrggbb or #rrggbbaa")
+	}
+	if err != nil {
+		return c, fmt.Errorf("Invalid color '%s': %s", s, err)
+	}
+
+	return c, nil
+}
+
+func (t Transformer) Status() []transformers.Status {
+	return nil
+}
+
+func (t Transformer) Params() map[string]interface{} {
+	return t.params
+}
+
+func (t Transformer) Result() interface{} {
+	if len(t.data) == 0 {
+		return nil
+	}
+
+	return t.data
+}
+
+func (t Transformer) CreditsConsumed() int {
+	return 5
+}
+
+func (t Transformer) Deadline() time.Duration {
+	return deadline
+}
+
+func (t Transformer) Validate() error {
+	if !t.initialized {
+		return fmt.Errorf("Not initialized")
+	}
+
+	if t.spec.Width <= 0 || t.spec.Height <= 0 || t.spec.Width > MAX_DIMENSION || t.spec.Height > MAX_DIMENSION {
+		return fmt.Errorf("Width and height have to be between 1 and %d", MAX_DIMENSION)
+	}
+
+	if len(t.spec.Background) > 0 {
+		_, err := parseColor(t.spec.Background)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(t.spec.Layers) == 0 {
+		return fmt.Errorf("At least one layer is required")
+	}
+
+	for i, l := range t.spec.Layers {
+		if l.Width < 0 || l.Height < 0 || l.Width > MAX_DIMENSION || l.Height > MAX_DIMENSION {
+			return fmt.Errorf("Layer %d: width and height have to be between 0 and %d", i, MAX_DIMENSION)
+		}
+
+		if l.Size < 0 || l.Size > MAX_TEXT_SIZE {
+			return fmt.Errorf("Layer %d: size has to be between 0 and %d", i, MAX_TEXT_SIZE)
+		}
+
+		if len(l.When) > 0 {
+			_, err := expr.Compile(l.When)
+			if err != nil {
+				return fmt.Errorf("Layer %d: %s", i, err)
+			}
+		}
+
+		if len(l.Text) > 0 {
+			_, err := expr.Compile(l.Text)
+			if err != nil {
+				return fmt.Errorf("Layer %d: %s", i, err)
+			}
+
+			if len(l.Color) > 0 {
+				_, err = parseColor(l.Color)
+				if err != nil {
+					return fmt.Errorf("Layer %d: %s", i, err)
+				}
+			}
+
+			switch l.Align {
+			case "", "left", "center", "right":
+			default:
+				return fmt.Errorf("Layer %d: unknown align %s", i, l.Align)
+			}
+
+			continue
+		}
+
+		if len(l.Source) == 0 && (len(l.Trait) == 0 || len(l.Sources) == 0) {
+			return fmt.Errorf("Layer %d: needs a text, a source or a trait with sources", i)
+		}
+
+		if l.Width < 0 || l.Height < 0 {
+			return fmt.Errorf("Layer %d: negative size", i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package compute
+
+import (
+	"fmt"
+
+	"github.com/manifoldco/promptui"
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/transformers"
+)
+
+func NewSpecFromPrompt() (transformers.BaseTransformer, error) {
+	var base transformers.BaseTransformer
+
+	spec := SpecSchema{
+		Outputs: make([]Output, 0),
+	}
+
+	for {
+		prompt := promptui.Prompt{
+			Label: "Output path (e.g. attributes.3.value, empty to finish)",
+		}
+
+		name, err := prompt.Run()
+		if err != nil {
+			return base, err
+		}
+
+		if len(name) == 0 {
+			break
+		}
+
+		prompt = promptui.Prompt{
+			Label: fmt.Sprintf("Expression for %s", name),
+			Validate: func(s string) error {
+				_, err := expr.Compile(s)
+				return err
+			},
+		}
+
+		e, err := prompt.Run()
+		if err != nil {
+			return base, err
+		}
+
+		spec.Outputs = append(spec.Outputs, Output{
+			Name: name,
+			Expr: e,
+		})
+	}
+
+	base = transformers.BaseTransformer{
+		GroupVersionKind: GVK,
+		Spec:             spec,
+	}
+
+	return base, nil
+
+}
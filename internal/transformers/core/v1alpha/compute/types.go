@@ -0,0 +1,223 @@
+package compute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/gvk"
+	"github.com/metaconflux/backend/internal/template"
+	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/tidwall/sjson"
+)
+
+var GVK = gvk.NewGroupVersionKind(
+	"core",
+	"v1alpha",
+	"compute",
+)
+
+var deadline = 1 * time.Second
+var _ transformers.ITransformer = &Transformer{}
+
+const (
+	TYPE_STRING = "string"
+	TYPE_NUMBER = "number"
+	TYPE_BOOL   = "bool"
+)
+
+// Transformer evaluates expressions over the current result and params and
+// writes the values to the result, e.g. a "Level" trait derived from XP.
+type Transformer struct {
+	spec        SpecSchema
+	params      map[string]interface{}
+	data        map[string]interface{}
+	initialized bool
+}
+
+type Output struct {
+	Name string `json:"name" template:""`
+	Expr string `json:"expr"`
+	Type string `json:"type,omitempty"`
+}
+
+type SpecSchema struct {
+	Tables  map[string]interface{} `json:"tables,omitempty"`
+	Outputs []Output               `json:"outputs"`
+}
+
+func NewTransformer() *Transformer {
+	return &Transformer{}
+}
+
+func (t Transformer) WithSpec(ispec interface{}, params map[string]interface{}) (transformers.ITransformer, error) {
+	var spec SpecSchema
+	err := utils.Remarshal(ispec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	transformer := Transformer{
+		params:      params,
+		data:        make(map[string]interface{}),
+		initialized: true,
+	}
+
+	err = template.Template(&spec, &transformer.spec, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return transformer, nil
+}
+
+// Execute evaluates the outputs in order, each of them sees the result
+// including the values written by the previous ones.
+func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (map[string]interface{}, error) {
+	baseBytes, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	result := string(baseBytes)
+	if base == nil {
+		result = "{}"
+	}
+	output := "{}"
+
+	env := utils.MergeMaps(t.params)
+	env["result"] = base
+	env["tables"] = t.spec.Tables
+
+	for _, out := range t.spec.Outputs {
+		e, err := expr.Compile(out.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := e.Eval(env)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err = convert(val, out.Type)
+		if err != nil {
+			return nil, fmt.Errorf("Output %s: %s", out.Name, err)
+		}
+
+		result, err = sjson.Set(result, out.Name, val)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err = sjson.Set(output, out.Name, val)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := decode(result)
+		if err != nil {
+			return nil, err
+		}
+		env["result"] = current
+	}
+
+	outputMap, err := decode(output)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, val := range outputMap {
+		t.data[key] = val
+	}
+
+	return decode(result)
+}
+
+func decode(data string) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(data))
+	d.UseNumber()
+	err := d.Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func convert(val interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "":
+		return val, nil
+	case TYPE_STRING:
+		return expr.ToString(val), nil
+	case TYPE_NUMBER:
+		n, ok := expr.ToNumber(val)
+		if !ok {
+			return nil, fmt.Errorf("Value '%s' is not a number", expr.ToString(val))
+		}
+		return n, nil
+	case TYPE_BOOL:
+		return expr.Truthy(val), nil
+	}
+
+	return nil, fmt.Errorf("Unknown type %s", typ)
+}
+
+func (t Transformer) Status() []transformers.Status {
+	return nil
+}
+
+func (t Transformer) Params() map[string]interface{} {
+	return t.params
+}
+
+func (t Transformer) Result() interface{} {
+	if len(t.data) == 0 {
+		return nil
+	}
+
+	return t.data
+}
+
+func (t Transformer) CreditsConsumed() int {
+	return 1
+}
+
+func (t Transformer) Deadline() time.Duration {
+	return deadline
+}
+
+func (t Transformer) Validate() error {
+	if !t.initialized {
+		return fmt.Errorf("Not initialized")
+	}
+
+	if len(t.spec.Outputs) == 0 {
+		return fmt.Errorf("At least one output is required")
+	}
+
+	for i, out := range t.spec.Outputs {
+		if len(out.Name) == 0 {
+			return fmt.Errorf("Output %d: name is empty", i)
+		}
+
+		_, err := expr.Compile(out.Expr)
+		if err != nil {
+			return fmt.Errorf("Output %s: %s", out.Name, err)
+		}
+
+		switch out.Type {
+		case "", TYPE_STRING, TYPE_NUMBER, TYPE_BOOL:
+		default:
+			return fmt.Errorf("Output %s: unknown type %s", out.Name, out.Type)
+		}
+	}
+
+	return nil
+}
@@ -71,11 +71,15 @@ func (s SpecSchema) argValues() ([]any, error) {
 	for i, arg := range s.Args {
 		switch arg.Type {
 		case "uint256", "int256", "uint", "int":
-			val, err := strconv.Atoi(arg.Value)
-			if err != nil {
-				return nil, err
+			// Token ids are often larger than an int64
+			val, ok := parseInt(arg.Value)
+			if !ok {
+				return nil, fmt.Errorf("Invalid %s argument '%s'", arg.Type, arg.Value)
 			}
-			result[i] = big.NewInt(int64(val))
+			if val.Sign() < 0 && strings.HasPrefix(arg.Type, "uint") {
+				return nil, fmt.Errorf("Invalid %s argument '%s', it cannot be negative", arg.Type, arg.Value)
+			}
+			result[i] = val
 		case "address":
 			val := common.HexToAddress(arg.Value)
 			result[i] = val
@@ -93,6 +97,23 @@ func (s SpecSchema) argValues() ([]any, error) {
 	return result, nil
 }
 
+// parseInt reads decimal integers, or hex ones with 0x. Leading zeros stay
+// decimal, so 010 is 10 and not octal 8.
+func parseInt(value string) (*big.Int, bool) {
+	value = strings.TrimSpace(value)
+
+	sign := ""
+	if strings.HasPrefix(value, "-") {
+		sign, value = "-", value[1:]
+	}
+
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		return new(big.Int).SetString(sign+value[2:], 16)
+	}
+
+	return new(big.Int).SetString(sign+value, 10)
+}
+
 func (s SpecSchema) retTypes() string {
 	types := make([]string, len(s.Returns))
 
@@ -143,6 +164,7 @@ func (t Transformer) WithSpec(ispec interface{}, params map[string]interface{})
 
 	transformer := Transformer{
 		params:  params,
+		data:    make(map[string]interface{}),
 		clients: t.clients,
 	}
 
@@ -178,6 +200,7 @@ func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (
 	}
 
 	result := ""
+	output := "{}"
 
 	baseBytes, err := json.Marshal(base)
 	if err != nil {
@@ -192,6 +215,15 @@ func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (
 			return nil, err
 		}
 
+		output, err = sjson.Set(output, ret.Name, &(data[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = t.setData(output)
+	if err != nil {
+		return nil, err
 	}
 
 	var resultMap map[string]interface{}
@@ -206,10 +238,36 @@ func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (
 	return resultMap, nil
 }
 
-func (t Transformer) Result() interface{} {
+// setData stores the returned values so they are available as the step
+// output through Result()
+func (t Transformer) setData(output string) error {
+	if t.data == nil {
+		return nil
+	}
+
+	var outputMap map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(output))
+	d.UseNumber()
+	err := d.Decode(&outputMap)
+	if err != nil {
+		return err
+	}
+
+	for key, val := range outputMap {
+		t.data[key] = val
+	}
+
 	return nil
 }
 
+func (t Transformer) Result() interface{} {
+	if len(t.data) == 0 {
+		return nil
+	}
+
+	return t.data
+}
+
 func (t Transformer) Status() []transformers.Status {
 	return nil
 }
@@ -0,0 +1,42 @@
+package contract
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestIntegerArguments(t *testing.T) {
+	valid := map[string]*big.Int{
+		"010":   big.NewInt(10),
+		"0":     big.NewInt(0),
+		" 42 ":  big.NewInt(42),
+		"0x10":  big.NewInt(16),
+		"0X1f":  big.NewInt(31),
+		"-010":  big.NewInt(-10),
+		"-0x10": big.NewInt(-16),
+		"115792089237316195423570985008687907853269984665640564039457584007913129639935": new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)),
+	}
+
+	for value, expected := range valid {
+		spec := SpecSchema{Args: []Arg{{Type: "int256", Value: value}}}
+
+		args, err := spec.argValues()
+		if err != nil {
+			t.Errorf("Failed to parse %s: %s", value, err)
+			continue
+		}
+
+		if args[0].(*big.Int).Cmp(expected) != 0 {
+			t.Errorf("Expected %s to be %s, got %s", value, expected, args[0])
+		}
+	}
+
+	for _, value := range []string{"1_000", "0b101", "0o17", "", "0x", "-5"} {
+		spec := SpecSchema{Args: []Arg{{Type: "uint256", Value: value}}}
+
+		_, err := spec.argValues()
+		if err == nil {
+			t.Errorf("Expected %s to be rejected", value)
+		}
+	}
+}
@@ -71,13 +71,17 @@ func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (
 		return nil, err
 	}
 
-	r, err := t.ipfsClient.Cat(path)
+	resp, err := t.ipfsClient.Request("cat", path).Send(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer r.Close()
-	data, err := ioutil.ReadAll(r)
+	defer resp.Close()
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	data, err := ioutil.ReadAll(resp.Output)
 	if err != nil {
 		return nil, err
 	}
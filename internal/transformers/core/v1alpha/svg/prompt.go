@@ -0,0 +1,40 @@
+package svg
+
+import (
+	"github.com/manifoldco/promptui"
+	"github.com/metaconflux/backend/internal/transformers"
+)
+
+func NewSpecFromPrompt() (transformers.BaseTransformer, error) {
+	var base transformers.BaseTransformer
+
+	prompt := promptui.Prompt{
+		Label: "SVG template source (ipfs:// or https://)",
+	}
+
+	source, err := prompt.Run()
+	if err != nil {
+		return base, err
+	}
+
+	sel := promptui.Select{
+		Label: "Output",
+		Items: []string{OUTPUT_DATA, OUTPUT_IPFS},
+	}
+
+	_, output, err := sel.Run()
+	if err != nil {
+		return base, err
+	}
+
+	base = transformers.BaseTransformer{
+		GroupVersionKind: GVK,
+		Spec: SpecSchema{
+			Source: source,
+			Output: output,
+		},
+	}
+
+	return base, nil
+
+}
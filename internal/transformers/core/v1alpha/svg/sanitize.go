@@ -0,0 +1,219 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var forbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"audio":         true,
+	"video":         true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// Animation elements can set any attribute of their target, so they are
+// dropped if they target a reference or an event handler
+var animationElements = map[string]bool{
+	"set":              true,
+	"animate":          true,
+	"animatecolor":     true,
+	"animatemotion":    true,
+	"animatetransform": true,
+}
+
+// Only inline raster images may be referenced, data:image/svg+xml could carry
+// scripts again
+var allowedDataPrefixes = []string{
+	"data:image/png",
+	"data:image/jpeg",
+	"data:image/gif",
+	"data:image/webp",
+}
+
+var urlRe = regexp.MustCompile(`(?i)url\(\s*['"]?\s*([^'")\s]*)`)
+
+// imageFuncRe matches the CSS functions taking plain strings as URLs
+var imageFuncRe = regexp.MustCompile(`(?i)(image-set|image|cross-fade)\(`)
+
+// Sanitize removes scripts, event handlers and references to anything outside
+// of the document from an SVG
+func Sanitize(in []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(in))
+	d.Strict = true
+
+	var out bytes.Buffer
+	skipDepth := 0
+	inStyle := false
+	rootSeen := false
+	// The text of a style element is checked as a whole, CDATA sections and
+	// dropped comments could split an @import otherwise
+	var style bytes.Buffer
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SVG: %s", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			local := strings.ToLower(t.Name.Local)
+			if skipDepth > 0 || inStyle || forbiddenElements[local] || (animationElements[local] && !safeAnimation(t)) {
+				skipDepth++
+				continue
+			}
+
+			if !rootSeen {
+				if local != "svg" {
+					return nil, fmt.Errorf("Root element has to be svg, got %s", t.Name.Local)
+				}
+				rootSeen = true
+			}
+
+			inStyle = local == "style"
+			style.Reset()
+
+			out.WriteString("<")
+			out.WriteString(qualifiedName(t.Name))
+			for _, attr := range t.Attr {
+				if !allowedAttr(attr) {
+					continue
+				}
+				out.WriteString(" ")
+				out.WriteString(qualifiedName(attr.Name))
+				out.WriteString(`="`)
+				xml.EscapeText(&out, []byte(attr.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if inStyle && safeStyle(style.String()) {
+				xml.EscapeText(&out, style.Bytes())
+			}
+			inStyle = false
+			out.WriteString("</")
+			out.WriteString(qualifiedName(t.Name))
+			out.WriteString(">")
+
+		case xml.CharData:
+			if skipDepth > 0 {
+				continue
+			}
+			if inStyle {
+				style.Write(t)
+				continue
+			}
+			xml.EscapeText(&out, t)
+
+		case xml.ProcInst:
+			if t.Target == "xml" && out.Len() == 0 {
+				out.WriteString("<?xml ")
+				out.Write(t.Inst)
+				out.WriteString("?>")
+			}
+
+		// Comments and directives (DOCTYPE, ENTITY) are dropped
+		default:
+		}
+	}
+
+	if !rootSeen {
+		return nil, fmt.Errorf("Invalid SVG: no svg element")
+	}
+
+	return out.Bytes(), nil
+}
+
+func qualifiedName(name xml.Name) string {
+	if len(name.Space) > 0 {
+		return fmt.Sprintf("%s:%s", name.Space, name.Local)
+	}
+
+	return name.Local
+}
+
+func allowedAttr(attr xml.Attr) bool {
+	local := strings.ToLower(attr.Name.Local)
+	value := strings.TrimSpace(attr.Value)
+
+	if strings.HasPrefix(local, "on") {
+		return false
+	}
+
+	if local == "href" || local == "src" {
+		if strings.HasPrefix(value, "#") {
+			return true
+		}
+		for _, prefix := range allowedDataPrefixes {
+			if strings.HasPrefix(strings.ToLower(value), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return safeStyle(value)
+}
+
+// safeAnimation reports whether the animation element leaves references and
+// event handlers of its target alone
+func safeAnimation(el xml.StartElement) bool {
+	for _, attr := range el.Attr {
+		if strings.ToLower(attr.Name.Local) != "attributename" {
+			continue
+		}
+
+		target := strings.ToLower(strings.TrimSpace(attr.Value))
+		if i := strings.LastIndex(target, ":"); i >= 0 {
+			target = target[i+1:]
+		}
+
+		if target == "href" || target == "src" || strings.HasPrefix(target, "on") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// safeStyle reports whether CSS (or any attribute value) references only
+// fragments of the document itself. Escapes are refused instead of decoded,
+// \75rl( is url( to a browser.
+func safeStyle(value string) bool {
+	lower := strings.ToLower(value)
+	if strings.Contains(lower, "\\") || strings.Contains(lower, "@import") || strings.Contains(lower, "javascript:") || strings.Contains(lower, "expression(") {
+		return false
+	}
+
+	// Image functions load quoted strings like url() does, the arguments may
+	// nest, so any quote after one is refused
+	if loc := imageFuncRe.FindStringIndex(value); loc != nil && strings.ContainsAny(value[loc[0]:], `'"`) {
+		return false
+	}
+
+	for _, match := range urlRe.FindAllStringSubmatch(value, -1) {
+		if !strings.HasPrefix(match[1], "#") {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,49 @@
+package svg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeExternalStyles(t *testing.T) {
+	docs := []string{
+		`<svg><style>@\69mport "http://evil/x.css";</style></svg>`,
+		`<svg><style>rect { background: \75rl(http://evil/b.png) }</style></svg>`,
+		`<svg><style>rect { background: image-set("http://evil/a.png" 1x) }</style></svg>`,
+		`<svg><style>rect { background: -webkit-image-set(url(#a) 1x, "http://evil/a.png" 2x) }</style></svg>`,
+		`<svg><style>@imp<![CDATA[ort "http://evil/x.css";]]></style></svg>`,
+		`<svg><style>@imp<!-- -->ort "http://evil/x.css";</style></svg>`,
+		`<svg><rect style="background:\75rl(http://evil/b.png)"/></svg>`,
+		`<svg><rect style="background:image-set('http://evil/a.png' 1x)"/></svg>`,
+		`<svg><rect style="fill:url(http://evil/p.svg#a)"/></svg>`,
+	}
+
+	for _, doc := range docs {
+		out, err := Sanitize([]byte(doc))
+		if err != nil {
+			t.Fatalf("Failed to sanitize %s: %s", doc, err)
+		}
+
+		if strings.Contains(string(out), "evil") {
+			t.Errorf("Expected the external reference to be removed from %s, got %s", doc, out)
+		}
+	}
+}
+
+func TestSanitizeKeepsLocalStyles(t *testing.T) {
+	docs := []string{
+		`<svg><style>rect { fill: url(#gradient); stroke: red }</style></svg>`,
+		`<svg><rect style="fill:url('#gradient')"/></svg>`,
+	}
+
+	for _, doc := range docs {
+		out, err := Sanitize([]byte(doc))
+		if err != nil {
+			t.Fatalf("Failed to sanitize %s: %s", doc, err)
+		}
+
+		if !strings.Contains(string(out), "#gradient") {
+			t.Errorf("Expected the style of %s to be kept, got %s", doc, out)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package svg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/metaconflux/backend/internal/cache"
+	"github.com/metaconflux/backend/internal/fetch"
+	"github.com/metaconflux/backend/internal/gvk"
+	"github.com/metaconflux/backend/internal/template"
+	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"github.com/tidwall/sjson"
+)
+
+var GVK = gvk.NewGroupVersionKind(
+	"core",
+	"v1alpha",
+	"svg",
+)
+
+var deadline = 5 * time.Second
+var _ transformers.ITransformer = &Transformer{}
+
+const (
+	DEFAULT_TARGET   = "image"
+	DEFAULT_MAX_SIZE = 256 * 1024
+	MAX_SIZE         = 2 * 1024 * 1024
+	MAX_DIMENSION    = 4096
+
+	OUTPUT_DATA = "data"
+	OUTPUT_IPFS = "ipfs"
+)
+
+// Transformer renders a mustache templated SVG from the current result and
+// params, sanitises it and stores it either inline as a data URI or in the
+// cache. The SVG can optionally be rasterised to PNG.
+type Transformer struct {
+	spec        SpecSchema
+	params      map[string]interface{}
+	data        map[string]interface{}
+	initialized bool
+	ipfsClient  *shell.Shell
+	cache       cache.ICache
+}
+
+type Rasterize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type SpecSchema struct {
+	// Template is rendered per token, so it is deliberately not templated
+	// when the spec is loaded
+	Template  string     `json:"template,omitempty"`
+	Source    string     `json:"source,omitempty" template:""`
+	Output    string     `json:"output,omitempty"`
+	Target    string     `json:"target,omitempty" template:""`
+	MaxSize   int        `json:"maxSize,omitempty"`
+	Rasterize *Rasterize `json:"rasterize,omitempty"`
+}
+
+func NewTransformer(shell *shell.Shell, cache cache.ICache) *Transformer {
+	return &Transformer{
+		ipfsClient: shell,
+		cache:      cache,
+	}
+}
+
+func (t Transformer) WithSpec(ispec interface{}, params map[string]interface{}) (transformers.ITransformer, error) {
+	var spec SpecSchema
+	err := utils.Remarshal(ispec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	transformer := Transformer{
+		params:      params,
+		data:        make(map[string]interface{}),
+		initialized: true,
+		ipfsClient:  t.ipfsClient,
+		cache:       t.cache,
+	}
+
+	err = template.Template(&spec, &transformer.spec, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transformer.spec.Target) == 0 {
+		transformer.spec.Target = DEFAULT_TARGET
+	}
+
+	if len(transformer.spec.Output) == 0 {
+		transformer.spec.Output = OUTPUT_DATA
+	}
+
+	if transformer.spec.MaxSize == 0 {
+		transformer.spec.MaxSize = DEFAULT_MAX_SIZE
+	}
+
+	return transformer, nil
+}
+
+func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (map[string]interface{}, error) {
+	tmpl, err := t.loadTemplate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	env := utils.MergeMaps(t.params)
+	env["result"] = base
+
+	rendered, err := utils.Template(tmpl, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rendered) > t.spec.MaxSize {
+		return nil, fmt.Errorf("Rendered SVG is larger than %d bytes", t.spec.MaxSize)
+	}
+
+	content, err := Sanitize([]byte(rendered))
+	if err != nil {
+		return nil, err
+	}
+
+	mime := "image/svg+xml"
+	if t.spec.Rasterize != nil {
+		content, err = rasterize(content, t.spec.Rasterize.Width, t.spec.Rasterize.Height)
+		if err != nil {
+			return nil, err
+		}
+		mime = "image/png"
+	}
+
+	var uri string
+	switch t.spec.Output {
+	case OUTPUT_IPFS:
+		cid, err := t.cache.Push(content)
+		if err != nil {
+			return nil, err
+		}
+		uri = fmt.Sprintf("ipfs://%s", cid)
+	default:
+		uri = fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(content))
+	}
+
+	t.data["image"] = uri
+
+	baseBytes, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := sjson.Set(string(baseBytes), t.spec.Target, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers stay json.Number, big token values would lose precision as
+	// float64
+	var resultMap map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(result))
+	d.UseNumber()
+	err = d.Decode(&resultMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultMap, nil
+}
+
+func (t Transformer) loadTemplate(ctx context.Context) (string, error) {
+	if len(t.spec.Template) > 0 {
+		return t.spec.Template, nil
+	}
+
+	data, err := fetch.ReadAll(ctx, t.ipfsClient, t.spec.Source, int64(t.spec.MaxSize))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func rasterize(content []byte, width int, height int) ([]byte, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(content), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse SVG: %s", err)
+	}
+
+	if width == 0 {
+		width = int(icon.ViewBox.W)
+	}
+	if height == 0 {
+		height = int(icon.ViewBox.H)
+	}
+	if width <= 0 || height <= 0 || width > MAX_DIMENSION || height > MAX_DIMENSION {
+		return nil, fmt.Errorf("Raster size has to be between 1 and %d", MAX_DIMENSION)
+	}
+
+	icon.SetTarget(0, 0, float64(width), float64(height))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1.0)
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (t Transformer) Status() []transformers.Status {
+	return nil
+}
+
+func (t Transformer) Params() map[string]interface{} {
+	return t.params
+}
+
+func (t Transformer) Result() interface{} {
+	if len(t.data) == 0 {
+		return nil
+	}
+
+	return t.data
+}
+
+func (t Transformer) CreditsConsumed() int {
+	if t.spec.Rasterize != nil {
+		return 3
+	}
+
+	return 1
+}
+
+func (t Transformer) Deadline() time.Duration {
+	return deadline
+}
+
+func (t Transformer) Validate() error {
+	if !t.initialized {
+		return fmt.Errorf("Not initialized")
+	}
+
+	if len(t.spec.Template) == 0 && len(t.spec.Source) == 0 {
+		return fmt.Errorf("Either template or source is required")
+	}
+
+	if len(t.spec.Template) > 0 && len(t.spec.Source) > 0 {
+		return fmt.Errorf("Only one of template and source can be set")
+	}
+
+	if t.spec.MaxSize < 0 || t.spec.MaxSize > MAX_SIZE {
+		return fmt.Errorf("Max size has to be between 1 and %d", MAX_SIZE)
+	}
+
+	switch t.spec.Output {
+	case OUTPUT_DATA:
+	case OUTPUT_IPFS:
+		if t.cache == nil {
+			return fmt.Errorf("Output %s is not available, no cache configured", OUTPUT_IPFS)
+		}
+	default:
+		return fmt.Errorf("Unknown output %s, expected %s or %s", t.spec.Output, OUTPUT_DATA, OUTPUT_IPFS)
+	}
+
+	if t.spec.Rasterize != nil {
+		r := t.spec.Rasterize
+		if r.Width < 0 || r.Height < 0 || r.Width > MAX_DIMENSION || r.Height > MAX_DIMENSION {
+			return fmt.Errorf("Raster size has to be between 1 and %d", MAX_DIMENSION)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package switchcase
+
+import (
+	"github.com/manifoldco/promptui"
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/transformers"
+)
+
+func NewSpecFromPrompt() (transformers.BaseTransformer, error) {
+	var base transformers.BaseTransformer
+
+	spec := SpecSchema{
+		Cases: make([]Case, 0),
+	}
+
+	for {
+		prompt := promptui.Prompt{
+			Label: "Case condition (e.g. id >= 1000, empty to finish)",
+			Validate: func(s string) error {
+				if len(s) == 0 {
+					return nil
+				}
+				_, err := expr.Compile(s)
+				return err
+			},
+		}
+
+		when, err := prompt.Run()
+		if err != nil {
+			return base, err
+		}
+
+		if len(when) == 0 {
+			break
+		}
+
+		spec.Cases = append(spec.Cases, Case{
+			When:         when,
+			Transformers: make([]transformers.BaseTransformer, 0),
+		})
+	}
+
+	base = transformers.BaseTransformer{
+		GroupVersionKind: GVK,
+		Spec:             spec,
+	}
+
+	return base, nil
+
+}
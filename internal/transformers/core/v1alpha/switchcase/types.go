@@ -0,0 +1,189 @@
+package switchcase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/gvk"
+	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/utils"
+)
+
+var GVK = gvk.NewGroupVersionKind(
+	"core",
+	"v1alpha",
+	"switch",
+)
+
+var _ transformers.ITransformer = &Transformer{}
+var _ transformers.INested = &Transformer{}
+
+// Transformer runs the transformers of the first case whose condition holds,
+// or the default ones if none does.
+type Transformer struct {
+	spec        SpecSchema
+	params      map[string]interface{}
+	manager     *transformers.Transformers
+	initialized bool
+	// consumed is shared by the copies of the transformer, so Execute can
+	// record the credits of the branch it ran
+	consumed *consumption
+}
+
+type consumption struct {
+	credits  int
+	executed bool
+}
+
+type Case struct {
+	When         string                         `json:"when"`
+	Transformers []transformers.BaseTransformer `json:"transformers"`
+}
+
+type SpecSchema struct {
+	Cases   []Case                         `json:"cases"`
+	Default []transformers.BaseTransformer `json:"default,omitempty"`
+}
+
+func NewTransformer(manager *transformers.Transformers) *Transformer {
+	return &Transformer{
+		manager: manager,
+	}
+}
+
+func (t Transformer) WithSpec(ispec interface{}, params map[string]interface{}) (transformers.ITransformer, error) {
+	var spec SpecSchema
+	err := utils.Remarshal(ispec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nested specs are templated when their case is executed, so they see the
+	// params as they are at that point
+	transformer := Transformer{
+		spec:        spec,
+		params:      params,
+		manager:     t.manager,
+		initialized: true,
+		consumed:    &consumption{},
+	}
+
+	return transformer, nil
+}
+
+func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (map[string]interface{}, error) {
+	branch, err := t.selectBranch()
+	if err != nil {
+		return nil, err
+	}
+	if branch == nil {
+		*t.consumed = consumption{executed: true}
+		return base, nil
+	}
+
+	result, credits, err := t.manager.ExecuteSteps(ctx, branch, base, t.params)
+	if err != nil {
+		return nil, err
+	}
+	*t.consumed = consumption{credits: credits, executed: true}
+
+	return result, nil
+}
+
+func (t Transformer) selectBranch() ([]transformers.BaseTransformer, error) {
+	for i, c := range t.spec.Cases {
+		match, err := expr.EvalBool(c.When, t.params)
+		if err != nil {
+			return nil, fmt.Errorf("Case %d: %s", i, err)
+		}
+
+		if match {
+			return c.Transformers, nil
+		}
+	}
+
+	return t.spec.Default, nil
+}
+
+func (t Transformer) Status() []transformers.Status {
+	return nil
+}
+
+func (t Transformer) Params() map[string]interface{} {
+	return t.params
+}
+
+func (t Transformer) Result() interface{} {
+	return nil
+}
+
+// CreditsConsumed counts the steps of the branch which ran once executed, the
+// most expensive branch before
+func (t Transformer) CreditsConsumed() int {
+	if t.consumed != nil && t.consumed.executed {
+		return 1 + t.consumed.credits
+	}
+
+	branch := 0
+	for _, b := range t.Branches() {
+		credits := t.manager.CalculateCredits(b)
+		if credits > branch {
+			branch = credits
+		}
+	}
+
+	return 1 + branch
+}
+
+func (t Transformer) Branches() [][]transformers.BaseTransformer {
+	branches := make([][]transformers.BaseTransformer, 0, len(t.spec.Cases)+1)
+	for _, c := range t.spec.Cases {
+		branches = append(branches, c.Transformers)
+	}
+
+	return append(branches, t.spec.Default)
+}
+
+// Deadline is the budget of the slowest branch, so its nested steps get their
+// own deadlines
+func (t Transformer) Deadline() time.Duration {
+	var result time.Duration
+	for _, b := range t.Branches() {
+		deadline := t.manager.CalculateDeadline(b)
+		if deadline > result {
+			result = deadline
+		}
+	}
+
+	return result
+}
+
+func (t Transformer) Validate() error {
+	if !t.initialized {
+		return fmt.Errorf("Not initialized")
+	}
+
+	if len(t.spec.Cases) == 0 {
+		return fmt.Errorf("At least one case is required")
+	}
+
+	for i, c := range t.spec.Cases {
+		if len(c.When) == 0 {
+			return fmt.Errorf("Case %d: condition is empty", i)
+		}
+
+		_, err := expr.Compile(c.When)
+		if err != nil {
+			return fmt.Errorf("Case %d: %s", i, err)
+		}
+
+		err = t.manager.Validate(c.Transformers)
+		if err != nil {
+			return fmt.Errorf("Case %d: %s", i, err)
+		}
+	}
+
+	return t.manager.Validate(t.spec.Default)
+}
@@ -0,0 +1,67 @@
+package traits
+
+import (
+	"github.com/manifoldco/promptui"
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/transformers"
+)
+
+func NewSpecFromPrompt() (transformers.BaseTransformer, error) {
+	var base transformers.BaseTransformer
+
+	spec := SpecSchema{
+		Traits: make([]Trait, 0),
+	}
+
+	for {
+		prompt := promptui.Prompt{
+			Label: "Trait type (empty to finish)",
+		}
+
+		traitType, err := prompt.Run()
+		if err != nil {
+			return base, err
+		}
+
+		if len(traitType) == 0 {
+			break
+		}
+
+		prompt = promptui.Prompt{
+			Label: "Value expression (e.g. steps.stats.level)",
+			Validate: func(s string) error {
+				_, err := expr.Compile(s)
+				return err
+			},
+		}
+
+		value, err := prompt.Run()
+		if err != nil {
+			return base, err
+		}
+
+		promptS := promptui.Select{
+			Label: "Display Type",
+			Items: []string{"", "number", "boost_number", "boost_percentage", "date"},
+		}
+
+		_, displayType, err := promptS.Run()
+		if err != nil {
+			return base, err
+		}
+
+		spec.Traits = append(spec.Traits, Trait{
+			TraitType:   traitType,
+			Value:       value,
+			DisplayType: displayType,
+		})
+	}
+
+	base = transformers.BaseTransformer{
+		GroupVersionKind: GVK,
+		Spec:             spec,
+	}
+
+	return base, nil
+
+}
@@ -0,0 +1,273 @@
+package traits
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/metaconflux/backend/internal/expr"
+	"github.com/metaconflux/backend/internal/gvk"
+	"github.com/metaconflux/backend/internal/template"
+	"github.com/metaconflux/backend/internal/transformers"
+	"github.com/metaconflux/backend/internal/utils"
+)
+
+var GVK = gvk.NewGroupVersionKind(
+	"core",
+	"v1alpha",
+	"traits",
+)
+
+var deadline = 1 * time.Second
+var _ transformers.ITransformer = &Transformer{}
+
+const ATTRIBUTES = "attributes"
+
+var displayTypes = map[string]bool{
+	"":                 true,
+	"number":           true,
+	"boost_number":     true,
+	"boost_percentage": true,
+	"date":             true,
+}
+
+// Transformer maps raw values to labels and upserts them into the
+// OpenSea-style attributes array by trait type.
+type Transformer struct {
+	spec        SpecSchema
+	params      map[string]interface{}
+	data        map[string]interface{}
+	initialized bool
+}
+
+// Range matches values in [From, To), either end can be omitted
+type Range struct {
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+	Label interface{} `json:"label"`
+}
+
+type Trait struct {
+	TraitType   string                 `json:"traitType" template:""`
+	Value       string                 `json:"value"`
+	Map         map[string]interface{} `json:"map,omitempty"`
+	Ranges      []Range                `json:"ranges,omitempty"`
+	Default     interface{}            `json:"default,omitempty"`
+	DisplayType string                 `json:"displayType,omitempty"`
+	MaxValue    interface{}            `json:"maxValue,omitempty"`
+}
+
+type SpecSchema struct {
+	Traits []Trait `json:"traits"`
+}
+
+func NewTransformer() *Transformer {
+	return &Transformer{}
+}
+
+func (t Transformer) WithSpec(ispec interface{}, params map[string]interface{}) (transformers.ITransformer, error) {
+	var spec SpecSchema
+	err := utils.Remarshal(ispec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	transformer := Transformer{
+		params:      params,
+		data:        make(map[string]interface{}),
+		initialized: true,
+	}
+
+	err = template.Template(&spec, &transformer.spec, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return transformer, nil
+}
+
+func (t Transformer) Execute(ctx context.Context, base map[string]interface{}) (map[string]interface{}, error) {
+	result := utils.MergeMaps(base)
+
+	var attributes []interface{}
+	if existing, ok := result[ATTRIBUTES]; ok && existing != nil {
+		list, ok := existing.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Field %s is not a list", ATTRIBUTES)
+		}
+		attributes = append(attributes, list...)
+	}
+
+	env := utils.MergeMaps(t.params)
+	env["result"] = base
+
+	for _, trait := range t.spec.Traits {
+		val, err := trait.resolve(env)
+		if err != nil {
+			return nil, fmt.Errorf("Trait %s: %s", trait.TraitType, err)
+		}
+
+		if val == nil {
+			continue
+		}
+
+		attributes = upsert(attributes, trait.attribute(val))
+		t.data[trait.TraitType] = val
+	}
+
+	result[ATTRIBUTES] = attributes
+
+	return result, nil
+}
+
+// resolve evaluates the value and maps it through the table and ranges
+func (tr Trait) resolve(env map[string]interface{}) (interface{}, error) {
+	e, err := expr.Compile(tr.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := e.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if val == nil {
+		return tr.Default, nil
+	}
+
+	if len(tr.Map) == 0 && len(tr.Ranges) == 0 {
+		return val, nil
+	}
+
+	if label, ok := tr.Map[expr.ToString(val)]; ok {
+		return label, nil
+	}
+
+	for _, r := range tr.Ranges {
+		match, err := r.contains(val)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			return r.Label, nil
+		}
+	}
+
+	return tr.Default, nil
+}
+
+func (r Range) contains(val interface{}) (bool, error) {
+	if r.From != nil {
+		c, err := expr.Compare(val, r.From)
+		if err != nil {
+			return false, err
+		}
+		if c < 0 {
+			return false, nil
+		}
+	}
+
+	if r.To != nil {
+		c, err := expr.Compare(val, r.To)
+		if err != nil {
+			return false, err
+		}
+		if c >= 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (tr Trait) attribute(val interface{}) map[string]interface{} {
+	attr := map[string]interface{}{
+		"trait_type": tr.TraitType,
+		"value":      val,
+	}
+
+	if len(tr.DisplayType) > 0 {
+		attr["display_type"] = tr.DisplayType
+	}
+
+	if tr.MaxValue != nil {
+		attr["max_value"] = tr.MaxValue
+	}
+
+	return attr
+}
+
+// upsert replaces the attribute with the same trait_type or appends a new one
+func upsert(attributes []interface{}, attr map[string]interface{}) []interface{} {
+	for i, item := range attributes {
+		existing, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if existing["trait_type"] == attr["trait_type"] {
+			attributes[i] = attr
+			return attributes
+		}
+	}
+
+	return append(attributes, attr)
+}
+
+func (t Transformer) Status() []transformers.Status {
+	return nil
+}
+
+func (t Transformer) Params() map[string]interface{} {
+	return t.params
+}
+
+func (t Transformer) Result() interface{} {
+	if len(t.data) == 0 {
+		return nil
+	}
+
+	return t.data
+}
+
+func (t Transformer) CreditsConsumed() int {
+	return 1
+}
+
+func (t Transformer) Deadline() time.Duration {
+	return deadline
+}
+
+func (t Transformer) Validate() error {
+	if !t.initialized {
+		return fmt.Errorf("Not initialized")
+	}
+
+	if len(t.spec.Traits) == 0 {
+		return fmt.Errorf("At least one trait is required")
+	}
+
+	for i, trait := range t.spec.Traits {
+		if len(trait.TraitType) == 0 {
+			return fmt.Errorf("Trait %d: traitType is empty", i)
+		}
+
+		_, err := expr.Compile(trait.Value)
+		if err != nil {
+			return fmt.Errorf("Trait %s: %s", trait.TraitType, err)
+		}
+
+		if !displayTypes[trait.DisplayType] {
+			return fmt.Errorf("Trait %s: unknown displayType %s", trait.TraitType, trait.DisplayType)
+		}
+
+		for j, r := range trait.Ranges {
+			if r.From == nil && r.To == nil {
+				return fmt.Errorf("Trait %s: range %d has no bounds", trait.TraitType, j)
+			}
+		}
+	}
+
+	return nil
+}
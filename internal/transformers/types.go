@@ -5,16 +5,37 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/metaconflux/backend/internal/expr"
 	"github.com/metaconflux/backend/internal/gvk"
+	"github.com/metaconflux/backend/internal/utils"
+	"github.com/sirupsen/logrus"
 )
 
 var DEFAULT_DEADLINE = 5 * time.Second
 
+// MAX_DEADLINE caps the budget of a whole manifest run, whether it comes from
+// the sum of the step deadlines or from the manifest config
+var MAX_DEADLINE = 60 * time.Second
+
+// STEPS_PARAM is the params key under which outputs of the steps with an id
+// are available to the following steps, e.g. {{steps.<id>.<path>}}
+const STEPS_PARAM = "steps"
+
+// MANIFEST_INFO is the result key holding ManifestInfo. It changes with every
+// run, so it is not part of the content of the metadata.
+const MANIFEST_INFO = "manifestInfo"
+
+var stepIdRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 var ErrTransformerTimeout = fmt.Errorf("Transformer exceeded deadline")
 var ErrTransformerUnknown = fmt.Errorf("Transformer unknown")
+var ErrTransformerCanceled = fmt.Errorf("Transformer canceled")
+var ErrBudgetExceeded = fmt.Errorf("Manifest exceeded deadline budget")
 
 type ITransformer interface {
 	//Prepare() error
@@ -29,6 +50,12 @@ type ITransformer interface {
 	Deadline() time.Duration
 }
 
+// INested is implemented by transformers running nested steps, only one of
+// the branches runs
+type INested interface {
+	Branches() [][]BaseTransformer
+}
+
 type NewTransformerFunc = func(spec interface{}, params map[string]interface{}) (ITransformer, error)
 type NewSpecFromPrompt = func() (BaseTransformer, error)
 
@@ -49,10 +76,14 @@ type ManifestInfo struct {
 	TransformerCount int       `json:"transformerCount"`
 	Runtime          int64     `json:"runtime"`
 	ManifestCID      string    `json:"manifestCID"`
+	// Credits consumed by the steps which ran
+	Credits int `json:"credits"`
 }
 
 type BaseTransformer struct {
 	gvk.GroupVersionKind
+	ID     string      `json:"id,omitempty"`
+	When   string      `json:"when,omitempty"`
 	Spec   interface{} `json:"spec"`
 	Status []Status    `json:"status,omitempty"`
 }
@@ -105,11 +136,17 @@ func (t Transformers) GetRegistered() []gvk.GroupVersionKind {
 	return keys
 }
 
+// UpdateParams merges toUpdate into params. Nested maps are merged key by key,
+// other values are replaced as long as the types match.
 func (t Transformers) UpdateParams(params *map[string]interface{}, toUpdate map[string]interface{}) error {
-	locParams := *params
-	for key, val := range locParams { //TODO: Nested stuff!!
-		updateVal, ok := toUpdate[key]
-		if !ok {
+	return mergeParams(*params, toUpdate, "")
+}
+
+func mergeParams(params map[string]interface{}, toUpdate map[string]interface{}, path string) error {
+	for key, updateVal := range toUpdate {
+		val, ok := params[key]
+		if !ok || val == nil || updateVal == nil {
+			params[key] = updateVal
 			continue
 		}
 
@@ -117,20 +154,30 @@ func (t Transformers) UpdateParams(params *map[string]interface{}, toUpdate map[
 		fieldTypeUpdate := reflect.TypeOf(updateVal).Kind()
 
 		if fieldType != fieldTypeUpdate {
-			return fmt.Errorf("Cannot update non-matching types %s != %s", fieldType, fieldTypeUpdate)
+			return fmt.Errorf("Cannot update non-matching types for %s%s: %s != %s", path, key, fieldType, fieldTypeUpdate)
 		}
 
 		switch fieldType {
 		case reflect.Map:
-			//t.UpdateParams(&val, updateVal)
+			valMap, okVal := val.(map[string]interface{})
+			updateMap, okUpdate := updateVal.(map[string]interface{})
+			if okVal && okUpdate {
+				err := mergeParams(valMap, updateMap, fmt.Sprintf("%s%s.", path, key))
+				if err != nil {
+					return err
+				}
+				continue
+			}
 		}
 
-		(*params)[key] = updateVal
+		params[key] = updateVal
 	}
 
 	return nil
 }
 
+// CalculateCredits sums the credits of the transformers. Transformers are
+// asked with their spec, so nested steps are counted as well.
 func (t Transformers) CalculateCredits(transformers []BaseTransformer) int {
 	result := 0
 	for _, tSpec := range transformers {
@@ -139,97 +186,336 @@ func (t Transformers) CalculateCredits(transformers []BaseTransformer) int {
 			return 0
 		}
 
-		result += ti.Credits
+		transformer, err := ti.New(tSpec.Spec, map[string]interface{}{})
+		if err != nil {
+			result += ti.Credits
+			continue
+		}
+
+		result += transformer.CreditsConsumed()
 	}
 
 	return result
 }
 
-func (t Transformers) Execute(transformers []BaseTransformer, params map[string]interface{}) (result map[string]interface{}, err error) {
-	//results := make([]interface{}, len(transformers))
-	start := time.Now()
+// CalculateDeadline returns the budget for running the transformers, the sum
+// of their deadlines capped at MAX_DEADLINE
+func (t Transformers) CalculateDeadline(transformers []BaseTransformer) time.Duration {
+	var result time.Duration
 	for _, tSpec := range transformers {
-		func() {
-			var ti TransformerInfo
-			ti, err = t.Get(tSpec.GroupVersionKind)
-			if err != nil {
-				return
-			}
+		ti, err := t.Get(tSpec.GroupVersionKind)
+		if err != nil {
+			continue
+		}
 
-			var transformer ITransformer
-			transformer, err = ti.New(tSpec.Spec, params)
-			if err != nil {
-				return
-			}
-			defer func() {
-				tSpec.Status = transformer.Status()
-			}()
+		result += specDeadline(tSpec, ti)
+	}
 
-			ctx, cancel := context.WithTimeout(context.Background(), ti.Deadline)
-			defer cancel()
+	if result <= 0 {
+		return DEFAULT_DEADLINE
+	}
 
-			resultCh := make(chan map[string]interface{}, 1)
-			errCh := make(chan error, 1)
+	if result > MAX_DEADLINE {
+		return MAX_DEADLINE
+	}
 
-			go func() {
-				result, err := transformer.Execute(ctx, result)
-				if err != nil {
-					errCh <- err
-					return
-				}
+	return result
+}
 
-				resultCh <- result
-			}()
-
-			select {
-			case <-ctx.Done():
-				err = fmt.Errorf("%s: %s", tSpec.GroupVersionKind.String(), ErrTransformerTimeout)
-				return
-			case r := <-resultCh:
-				result = r
-			case e := <-errCh:
-				err = fmt.Errorf("%s: %s", tSpec.GroupVersionKind.String(), e)
-				return
-			}
+// specDeadline asks the transformer for its deadline with its spec, as it
+// may depend on nested steps, and falls back to the registered one
+func specDeadline(tSpec BaseTransformer, ti TransformerInfo) time.Duration {
+	transformer, err := ti.New(tSpec.Spec, map[string]interface{}{})
+	if err != nil {
+		return ti.Deadline
+	}
 
-			params["result"] = result
+	return transformer.Deadline()
+}
 
-			err = t.UpdateParams(&params, transformer.Params())
-			if err != nil {
-				return
-			}
+type stepResult struct {
+	result map[string]interface{}
+	err    error
+}
 
-		}()
-		if err != nil {
-			return
-		}
+// Execute runs the transformers in order. The overall budget is taken from the
+// ctx deadline (CalculateDeadline when ctx has none) and split across the steps
+// proportionally to their own deadlines, so time left over by fast steps is
+// available to the following ones.
+func (t Transformers) Execute(ctx context.Context, transformers []BaseTransformer, params map[string]interface{}) (result map[string]interface{}, err error) {
+	return t.ExecuteOn(ctx, transformers, nil, params)
+}
+
+// ExecuteOn is Execute starting from base instead of an empty result
+func (t Transformers) ExecuteOn(ctx context.Context, transformers []BaseTransformer, base map[string]interface{}, params map[string]interface{}) (result map[string]interface{}, err error) {
+	start := time.Now()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.CalculateDeadline(transformers))
+		defer cancel()
 	}
 
-	end := time.Now()
+	if _, ok := params[STEPS_PARAM]; !ok {
+		params[STEPS_PARAM] = make(map[string]interface{})
+	}
 
+	result, credits, err := t.ExecuteSteps(ctx, transformers, base, params)
 	if err != nil {
-		return
+		return nil, err
 	}
 
+	end := time.Now()
+
 	manifestCID := ""
 	if _, ok := params["manifestCID"]; ok {
 		manifestCID = params["manifestCID"].(string)
 	}
 
-	result["manifestInfo"] = ManifestInfo{
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+
+	result[MANIFEST_INFO] = ManifestInfo{
 		GeneratedAt:      time.Now(),
 		TransformerCount: len(transformers),
 		Runtime:          end.Sub(start).Milliseconds(),
 		ManifestCID:      manifestCID,
+		Credits:          credits,
 	}
 
 	return
 }
 
+// ExecuteSteps runs the transformers on top of base within the deadline of ctx
+// and returns the credits consumed by the steps which ran. Steps with a `when`
+// expression which evaluates to false are skipped.
+func (t Transformers) ExecuteSteps(ctx context.Context, transformers []BaseTransformer, base map[string]interface{}, params map[string]interface{}) (result map[string]interface{}, credits int, err error) {
+	result = base
+
+	infos := make([]TransformerInfo, len(transformers))
+	deadlines := make([]time.Duration, len(transformers))
+	var total time.Duration
+	for i, tSpec := range transformers {
+		infos[i], err = t.Get(tSpec.GroupVersionKind)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %s", tSpec.GroupVersionKind.String(), err)
+		}
+		deadlines[i] = specDeadline(tSpec, infos[i])
+		total += deadlines[i]
+	}
+
+	for i, tSpec := range transformers {
+		err = ctxError(ctx)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %s", tSpec.GroupVersionKind.String(), err)
+		}
+
+		deadline := stepDeadline(ctx, deadlines[i], total)
+		total -= deadlines[i]
+
+		if len(tSpec.When) > 0 {
+			var run bool
+			run, err = evalWhen(tSpec.When, params)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %s", tSpec.GroupVersionKind.String(), err)
+			}
+
+			if !run {
+				logrus.Debugf("Skipping %s, condition '%s' not met", tSpec.GroupVersionKind.String(), tSpec.When)
+				continue
+			}
+		}
+
+		var stepCredits int
+		result, stepCredits, err = t.executeStep(ctx, tSpec, infos[i], deadline, result, params)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %s", tSpec.GroupVersionKind.String(), err)
+		}
+		credits += stepCredits
+	}
+
+	return result, credits, nil
+}
+
+// evalWhen evaluates the condition of a step, a panic in the expression
+// fails the step like one in the transformer does
+func evalWhen(when string, params map[string]interface{}) (run bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Condition '%s' panicked: %v", when, r)
+		}
+	}()
+
+	return expr.EvalBool(when, params)
+}
+
+// executeStep runs a single step and returns its result and the credits it
+// consumed
+func (t Transformers) executeStep(ctx context.Context, tSpec BaseTransformer, ti TransformerInfo, deadline time.Duration, base map[string]interface{}, params map[string]interface{}) (map[string]interface{}, int, error) {
+	transformer, err := ti.New(tSpec.Spec, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		tSpec.Status = transformer.Status()
+	}()
+
+	stepCtx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	// Buffered, so the goroutine can always finish and be collected even when
+	// nobody is waiting for it anymore. The step works on its own deep copy of
+	// the base so a late return cannot touch the result of the following steps.
+	doneCh := make(chan stepResult, 1)
+	stepBase := utils.DeepCopy(base)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				doneCh <- stepResult{err: fmt.Errorf("Transformer panicked: %v", r)}
+			}
+		}()
+
+		result, err := transformer.Execute(stepCtx, stepBase)
+		doneCh <- stepResult{result: result, err: err}
+	}()
+
+	var result map[string]interface{}
+	select {
+	case <-stepCtx.Done():
+		err = ctxError(ctx)
+		if err == nil {
+			err = ErrTransformerTimeout
+		}
+		return nil, 0, err
+	case r := <-doneCh:
+		if r.err != nil {
+			return nil, 0, r.err
+		}
+		result = r.result
+	}
+
+	params["result"] = result
+
+	if len(tSpec.ID) > 0 {
+		err = setStepOutput(params, tSpec.ID, transformer, result)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	err = t.UpdateParams(&params, transformer.Params())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return result, transformer.CreditsConsumed(), nil
+}
+
+// setStepOutput exposes the output of a step under steps.<id> in params. The
+// output is the transformer Result() or the whole step result if the
+// transformer does not provide one.
+func setStepOutput(params map[string]interface{}, id string, transformer ITransformer, result map[string]interface{}) error {
+	steps, ok := params[STEPS_PARAM].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Param %s is not a map", STEPS_PARAM)
+	}
+
+	var output interface{} = result
+	if r := transformer.Result(); r != nil {
+		output = r
+	}
+
+	generic, err := utils.ToGeneric(output)
+	if err != nil {
+		return err
+	}
+
+	steps[id] = generic
+
+	return nil
+}
+
+// stepDeadline returns the share of the remaining budget for a step which is
+// allowed to run for at most own, where total is the sum of deadlines of this
+// and all the following steps.
+func stepDeadline(ctx context.Context, own time.Duration, total time.Duration) time.Duration {
+	budgetEnd, ok := ctx.Deadline()
+	if !ok || total <= 0 {
+		return own
+	}
+
+	remaining := time.Until(budgetEnd)
+	share := time.Duration(float64(remaining) * float64(own) / float64(total))
+	if share > own {
+		return own
+	}
+
+	return share
+}
+
+// stepIds returns the ids of the steps and of their nested steps
+func (t Transformers) stepIds(transformers []BaseTransformer) []string {
+	var ids []string
+	for _, ts := range transformers {
+		if len(ts.ID) > 0 {
+			ids = append(ids, ts.ID)
+		}
+
+		tf, err := t.Get(ts.GroupVersionKind)
+		if err != nil {
+			continue
+		}
+
+		transformer, err := tf.New(ts.Spec, map[string]interface{}{})
+		if err != nil {
+			continue
+		}
+
+		if nested, ok := transformer.(INested); ok {
+			for _, branch := range nested.Branches() {
+				ids = append(ids, t.stepIds(branch)...)
+			}
+		}
+	}
+
+	return ids
+}
+
+// ctxError maps the state of the pipeline context to the transformer errors.
+func ctxError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.Canceled:
+		return ErrTransformerCanceled
+	default:
+		return ErrBudgetExceeded
+	}
+}
+
 func (t Transformers) Validate(transformers []BaseTransformer) error {
 	var failedTransformers []string
 	var failedValidations []string
+	ids := make(map[string]bool)
 	for _, ts := range transformers {
+		if len(ts.ID) > 0 {
+			if !stepIdRe.MatchString(ts.ID) {
+				failedValidations = append(failedValidations, fmt.Sprintf("Invalid id '%s' of %s", ts.ID, ts.GroupVersionKind.String()))
+			} else if ids[ts.ID] {
+				failedValidations = append(failedValidations, fmt.Sprintf("Duplicate id '%s'", ts.ID))
+			}
+			ids[ts.ID] = true
+		}
+
+		if len(ts.When) > 0 {
+			_, err := expr.Compile(ts.When)
+			if err != nil {
+				failedValidations = append(failedValidations, fmt.Sprintf("Invalid condition of %s: %s", ts.GroupVersionKind.String(), err))
+			}
+		}
+
 		tf, err := t.Get(ts.GroupVersionKind)
 		if errors.Is(err, ErrTransformerUnknown) {
 			failedTransformers = append(failedTransformers, ts.GroupVersionKind.String())
@@ -239,12 +525,33 @@ func (t Transformers) Validate(transformers []BaseTransformer) error {
 		transformer, err := tf.New(ts.Spec, map[string]interface{}{})
 		if err != nil {
 			failedValidations = append(failedValidations, fmt.Sprintf("Failed to instantiate %s: %s", ts.GroupVersionKind.String(), err))
+			continue
 		}
 
 		err = transformer.Validate()
 		if err != nil {
 			failedValidations = append(failedValidations, fmt.Sprintf("Failed to validate %s: %s", ts.GroupVersionKind.String(), err))
 		}
+
+		// Nested steps write to the same steps param, branches may share ids
+		// as only one of them runs
+		if nested, ok := transformer.(INested); ok {
+			var nestedIds []string
+			for _, branch := range nested.Branches() {
+				nestedIds = append(nestedIds, t.stepIds(branch)...)
+			}
+			sort.Strings(nestedIds)
+
+			for i, id := range nestedIds {
+				if i > 0 && nestedIds[i-1] == id {
+					continue
+				}
+				if ids[id] {
+					failedValidations = append(failedValidations, fmt.Sprintf("Duplicate id '%s' in nested steps of %s", id, ts.GroupVersionKind.String()))
+				}
+				ids[id] = true
+			}
+		}
 	}
 
 	var result string
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"reflect"
 
 	"github.com/vpavlin/mustache"
 )
@@ -21,6 +23,25 @@ func Remarshal(in interface{}, out interface{}) error {
 
 }
 
+// ToGeneric converts in to plain maps, slices and values. Numbers are kept as
+// json.Number so big integers do not lose precision.
+func ToGeneric(in interface{}) (interface{}, error) {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var out interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err = d.Decode(&out)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 func MergeMaps(maps ...map[string]interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
 	for _, m := range maps {
@@ -31,6 +52,49 @@ func MergeMaps(maps ...map[string]interface{}) map[string]interface{} {
 	return result
 }
 
+// DeepCopy copies the map with all the maps and slices nested in it, other
+// values are shared. A nil map gives an empty one, like MergeMaps.
+func DeepCopy(in map[string]interface{}) map[string]interface{} {
+	if in == nil {
+		return make(map[string]interface{})
+	}
+
+	return deepCopy(reflect.ValueOf(in)).Interface().(map[string]interface{})
+}
+
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	}
+
+	return v
+}
+
 func Template(content string, params map[string]interface{}) (string, error) {
 	mustache.Experimental = true
 	data, err := mustache.Render(content, params)